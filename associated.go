@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/ericchiang/k8s"
+)
+
+// associatedObject is a ConfigMap or Secret created for a single job run.
+type associatedObject struct {
+	kind string
+	name string
+}
+
+// listAssociated lists the ConfigMaps and Secrets, as enabled in cfg, in the
+// job's namespace that carry any of the job label keys with the job's name as
+// value.
+func listAssociated(client *k8s.Client, dj kubeJob, cfg *runConfig) ([]associatedObject, error) {
+	seen := make(map[associatedObject]bool)
+	var objs []associatedObject
+	add := func(obj associatedObject) {
+		if !seen[obj] {
+			seen[obj] = true
+			objs = append(objs, obj)
+		}
+	}
+	for _, key := range cfg.jobLabels {
+		ls := new(k8s.LabelSelector)
+		ls.Eq(key, dj.name)
+		if cfg.deleteConfigMaps {
+			countAPICall(apiList)
+			list, err := client.CoreV1().ListConfigMaps(context.Background(), dj.namespace, ls.Selector())
+			if err != nil {
+				return nil, fmt.Errorf("Unable to list ConfigMaps: %v", err)
+			}
+			for _, cm := range list.Items {
+				add(associatedObject{kind: "ConfigMap", name: cm.Metadata.GetName()})
+			}
+		}
+		if cfg.deleteSecrets {
+			countAPICall(apiList)
+			list, err := client.CoreV1().ListSecrets(context.Background(), dj.namespace, ls.Selector())
+			if err != nil {
+				return nil, fmt.Errorf("Unable to list Secrets: %v", err)
+			}
+			for _, s := range list.Items {
+				add(associatedObject{kind: "Secret", name: s.Metadata.GetName()})
+			}
+		}
+	}
+	return objs, nil
+}
+
+// cleanupAssociated deletes the ConfigMaps and Secrets labelled with the name
+// of a job that was just deleted, or lists them in dry-run.
+func cleanupAssociated(client *k8s.Client, dj kubeJob, cfg *runConfig, w io.Writer, sum *runSummary) {
+	if !cfg.deleteConfigMaps && !cfg.deleteSecrets {
+		return
+	}
+	objs, err := listAssociated(client, dj, cfg)
+	if err != nil {
+		fmt.Fprintf(w, "\t%s of job %s.\n", err.Error(), dj.name)
+		sum.Errors++
+		return
+	}
+	for _, obj := range objs {
+		if !cfg.deleteJobs {
+			fmt.Fprintf(w, "\t%s: %s\tNamespace: %s\n", obj.kind, obj.name, dj.namespace)
+			sum.associated(obj.kind)
+			continue
+		}
+		fmt.Fprintf(w, "\tDeleting %s: %s\n", obj.kind, obj.name)
+		deleteLimiter.wait()
+		countAPICall(apiDelete)
+		if obj.kind == "ConfigMap" {
+			err = client.CoreV1().DeleteConfigMap(context.Background(), obj.name, dj.namespace)
+		} else {
+			err = client.CoreV1().DeleteSecret(context.Background(), obj.name, dj.namespace)
+		}
+		if err != nil && !isNotFound(err) {
+			fmt.Fprintf(w, "\tUnable to delete %s %s. Error: %s\n", obj.kind, obj.name, err.Error())
+			sum.Errors++
+			if cfg.stopped(sum) {
+				return
+			}
+			continue
+		}
+		sum.associated(obj.kind)
+	}
+}
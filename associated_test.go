@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ericchiang/k8s"
+	"github.com/ericchiang/k8s/api/unversioned"
+	apiv1 "github.com/ericchiang/k8s/api/v1"
+	metav1 "github.com/ericchiang/k8s/apis/meta/v1"
+)
+
+func labelledMeta(name string, labels map[string]string) *metav1.ObjectMeta {
+	return &metav1.ObjectMeta{Name: k8s.String(name), Namespace: k8s.String("ns"), Labels: labels}
+}
+
+// serveAssociated serves the ConfigMaps and Secrets of namespace "ns",
+// filtered by label selector, and accepts deleting any of them.
+func (f *fakeAPI) serveAssociated(configMaps []*apiv1.ConfigMap, secrets []*apiv1.Secret) {
+	path := "/api/v1/namespaces/ns/"
+	f.handle("GET", path+"configmaps", func(w http.ResponseWriter, r *http.Request) {
+		list := &apiv1.ConfigMapList{Metadata: &metav1.ListMeta{}}
+		for _, cm := range configMaps {
+			if matchesSelector(cm.Metadata.GetLabels(), r.URL.Query().Get("labelSelector")) {
+				list.Items = append(list.Items, cm)
+			}
+		}
+		writeObject(w, r, list)
+	})
+	f.handle("GET", path+"secrets", func(w http.ResponseWriter, r *http.Request) {
+		list := &apiv1.SecretList{Metadata: &metav1.ListMeta{}}
+		for _, s := range secrets {
+			if matchesSelector(s.Metadata.GetLabels(), r.URL.Query().Get("labelSelector")) {
+				list.Items = append(list.Items, s)
+			}
+		}
+		writeObject(w, r, list)
+	})
+	deleted := func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, &unversioned.Status{Status: k8s.String("Success")})
+	}
+	for _, cm := range configMaps {
+		f.handle("DELETE", path+"configmaps/"+cm.Metadata.GetName(), deleted)
+	}
+	for _, s := range secrets {
+		f.handle("DELETE", path+"secrets/"+s.Metadata.GetName(), deleted)
+	}
+}
+
+// deletedPaths returns the paths of the DELETE requests made, in order.
+func (f *fakeAPI) deletedPaths() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var paths []string
+	for _, r := range f.requests {
+		if r.Method == "DELETE" {
+			paths = append(paths, r.URL.Path)
+		}
+	}
+	return paths
+}
+
+func TestCleanupAssociated(t *testing.T) {
+	configMaps := []*apiv1.ConfigMap{
+		{Metadata: labelledMeta("backup-config", map[string]string{"job-name": "backup", "batch.kubernetes.io/job-name": "backup"})},
+		{Metadata: labelledMeta("other-config", map[string]string{"job-name": "other"})},
+		{Metadata: labelledMeta("shared-config", nil)},
+	}
+	secrets := []*apiv1.Secret{
+		{Metadata: labelledMeta("backup-creds", map[string]string{"batch.kubernetes.io/job-name": "backup"})},
+		{Metadata: labelledMeta("shared-creds", map[string]string{"app": "backup"})},
+	}
+	dj := kubeJob{name: "backup", namespace: "ns"}
+
+	for _, tt := range []struct {
+		name        string
+		deleteJobs  bool
+		secrets     bool
+		wantDeleted []string
+		wantByKind  map[string]int
+	}{
+		{"delete", true, true, []string{
+			"/api/v1/namespaces/ns/configmaps/backup-config",
+			"/api/v1/namespaces/ns/secrets/backup-creds",
+		}, map[string]int{"ConfigMap": 1, "Secret": 1}},
+		{"ConfigMaps only", true, false, []string{
+			"/api/v1/namespaces/ns/configmaps/backup-config",
+		}, map[string]int{"ConfigMap": 1}},
+		{"dry-run", false, true, nil, map[string]int{"ConfigMap": 1, "Secret": 1}},
+	} {
+		api, client := newFakeAPI(t)
+		api.serveAssociated(configMaps, secrets)
+		cfg := testConfig()
+		cfg.jobLabels = []string{"job-name", "batch.kubernetes.io/job-name"}
+		cfg.deleteJobs = tt.deleteJobs
+		cfg.deleteConfigMaps = true
+		cfg.deleteSecrets = tt.secrets
+		var out bytes.Buffer
+		sum := &runSummary{}
+		cleanupAssociated(client, dj, cfg, &out, sum)
+
+		if got := api.deletedPaths(); !equalStrings(got, tt.wantDeleted) {
+			t.Errorf("%s: deleted %v, want %v", tt.name, got, tt.wantDeleted)
+		}
+		if !reflect.DeepEqual(sum.AssociatedByKind, tt.wantByKind) || sum.Errors != 0 {
+			t.Errorf("%s: counted %v with %d errors, want %v", tt.name, sum.AssociatedByKind, sum.Errors, tt.wantByKind)
+		}
+		if !tt.secrets && api.count("GET", "/api/v1/namespaces/ns/secrets") != 0 {
+			t.Errorf("%s: Secrets listed without -delete-secrets", tt.name)
+		}
+		if strings.Contains(out.String(), "shared") || strings.Contains(out.String(), "other") {
+			t.Errorf("%s: unrelated objects in output:\n%s", tt.name, out.String())
+		}
+	}
+}
+
+func TestCleanupAssociatedListError(t *testing.T) {
+	api, client := newFakeAPI(t)
+	api.handle("GET", "/api/v1/namespaces/ns/configmaps", func(w http.ResponseWriter, r *http.Request) {
+		writeStatus(w, r, http.StatusForbidden, "forbidden")
+	})
+	cfg := testConfig()
+	cfg.deleteJobs = true
+	cfg.deleteConfigMaps = true
+	var out bytes.Buffer
+	sum := &runSummary{}
+	cleanupAssociated(client, kubeJob{name: "backup", namespace: "ns"}, cfg, &out, sum)
+	if sum.Errors != 1 || api.count("DELETE", "/") != 0 {
+		t.Errorf("Got %d errors and %d deletes, want 1 error and no deletes", sum.Errors, api.count("DELETE", "/"))
+	}
+	if !strings.Contains(out.String(), "Unable to list ConfigMaps") {
+		t.Errorf("Output %q doesn't report the list error", out.String())
+	}
+}
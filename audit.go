@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ericchiang/k8s"
+	apiv1 "github.com/ericchiang/k8s/api/v1"
+	metav1 "github.com/ericchiang/k8s/apis/meta/v1"
+)
+
+// auditLogKey is the ConfigMap data key holding the audit log, one JSON
+// encoded auditEntry per line, oldest first.
+const auditLogKey = "audit.log"
+
+// auditEntry is the record of a single run written to the audit ConfigMap.
+type auditEntry struct {
+	Time  string `json:"time"`
+	Mode  string `json:"mode"`
+	RunID string `json:"run_id,omitempty"`
+	runSummary
+}
+
+func newAuditEntry(now time.Time, cfg *runConfig, sum *runSummary) auditEntry {
+	mode := "dry-run"
+	if cfg.deleteJobs {
+		mode = "delete"
+	}
+	return auditEntry{Time: now.UTC().Format(time.RFC3339), Mode: mode, RunID: runID, runSummary: *sum}
+}
+
+// appendAuditLog appends the entry to the audit log in the named ConfigMap,
+// creating the ConfigMap if it doesn't exist and keeping only the last
+// maxEntries entries.
+func appendAuditLog(client *k8s.Client, namespace, name string, entry auditEntry, maxEntries int) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("Failed to encode audit entry: %v", err)
+	}
+
+	countAPICall(apiGet)
+	cm, err := client.CoreV1().GetConfigMap(context.Background(), name, namespace)
+	if err != nil {
+		if !isNotFound(err) {
+			return fmt.Errorf("Failed to get ConfigMap %s/%s: %v", namespace, name, err)
+		}
+		cm = &apiv1.ConfigMap{
+			Metadata: &metav1.ObjectMeta{Name: k8s.String(name), Namespace: k8s.String(namespace)},
+			Data:     map[string]string{auditLogKey: string(line)},
+		}
+		countAPICall(apiCreate)
+		if _, err := client.CoreV1().CreateConfigMap(context.Background(), cm); err != nil {
+			return fmt.Errorf("Failed to create ConfigMap %s/%s: %v", namespace, name, err)
+		}
+		return nil
+	}
+
+	if cm.Data == nil {
+		cm.Data = make(map[string]string)
+	}
+	cm.Data[auditLogKey] = trimAuditLog(cm.Data[auditLogKey], string(line), maxEntries)
+	countAPICall(apiUpdate)
+	if _, err := client.CoreV1().UpdateConfigMap(context.Background(), cm); err != nil {
+		return fmt.Errorf("Failed to update ConfigMap %s/%s: %v", namespace, name, err)
+	}
+	return nil
+}
+
+// trimAuditLog appends line to the newline separated log and drops the oldest
+// lines so that at most maxEntries remain.
+func trimAuditLog(log, line string, maxEntries int) string {
+	var lines []string
+	if log != "" {
+		lines = strings.Split(log, "\n")
+	}
+	lines = append(lines, line)
+	if maxEntries > 0 && len(lines) > maxEntries {
+		lines = lines[len(lines)-maxEntries:]
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	apiv1 "github.com/ericchiang/k8s/api/v1"
+)
+
+func TestAppendAuditLog(t *testing.T) {
+	api, client := newFakeAPI(t)
+	path := "/api/v1/namespaces/ops/configmaps"
+	var mu sync.Mutex
+	var stored *apiv1.ConfigMap
+	save := func(w http.ResponseWriter, r *http.Request) {
+		cm := new(apiv1.ConfigMap)
+		readObject(t, r, cm)
+		mu.Lock()
+		stored = cm
+		mu.Unlock()
+		writeObject(w, r, cm)
+	}
+	api.handle("GET", path+"/audit", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		cm := stored
+		mu.Unlock()
+		if cm == nil {
+			writeStatus(w, r, http.StatusNotFound, "not found")
+			return
+		}
+		writeObject(w, r, cm)
+	})
+	api.handle("POST", path, save)
+	api.handle("PUT", path+"/audit", save)
+
+	cfg := testConfig()
+	cfg.deleteJobs = true
+	for i := 1; i <= 4; i++ {
+		entry := newAuditEntry(testNow, cfg, &runSummary{JobsDeleted: i})
+		if err := appendAuditLog(client, "ops", "audit", entry, 3); err != nil {
+			t.Fatalf("Run %d: %v", i, err)
+		}
+	}
+	if n := api.count("POST", path); n != 1 {
+		t.Errorf("%d creates, want the ConfigMap created once", n)
+	}
+	if n := api.count("PUT", path+"/audit"); n != 3 {
+		t.Errorf("%d updates, want 3", n)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if stored.Metadata.GetName() != "audit" || stored.Metadata.GetNamespace() != "ops" {
+		t.Errorf("ConfigMap %s/%s, want ops/audit", stored.Metadata.GetNamespace(), stored.Metadata.GetName())
+	}
+	lines := strings.Split(stored.Data[auditLogKey], "\n")
+	if len(lines) != 3 {
+		t.Fatalf("%d entries, want the last 3:\n%s", len(lines), stored.Data[auditLogKey])
+	}
+	for i, line := range lines {
+		var entry auditEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Entry %q: %v", line, err)
+		}
+		if entry.JobsDeleted != i+2 || entry.Mode != "delete" || entry.Time != "2024-03-15T12:00:00Z" {
+			t.Errorf("Entry %d = %+v, want run %d in delete mode", i, entry, i+2)
+		}
+	}
+}
+
+func TestAppendAuditLogGetError(t *testing.T) {
+	api, client := newFakeAPI(t)
+	api.handle("GET", "/api/v1/namespaces/ops/configmaps/audit", func(w http.ResponseWriter, r *http.Request) {
+		writeStatus(w, r, http.StatusForbidden, "forbidden")
+	})
+	entry := newAuditEntry(testNow, testConfig(), &runSummary{})
+	if err := appendAuditLog(client, "ops", "audit", entry, 3); err == nil {
+		t.Error("Expected an error when the ConfigMap can't be read")
+	}
+	if n := api.count("POST", "/api/v1/namespaces/ops/configmaps"); n != 0 {
+		t.Errorf("%d creates after a forbidden get, want none", n)
+	}
+}
+
+func TestTrimAuditLog(t *testing.T) {
+	tests := []struct {
+		log        string
+		maxEntries int
+		want       string
+	}{
+		{"", 3, "new"},
+		{"a\nb", 3, "a\nb\nnew"},
+		{"a\nb\nc", 3, "b\nc\nnew"},
+		{"a\nb\nc", 1, "new"},
+		{"a\nb\nc", 0, "a\nb\nc\nnew"},
+	}
+	for _, tt := range tests {
+		if got := trimAuditLog(tt.log, "new", tt.maxEntries); got != tt.want {
+			t.Errorf("trimAuditLog(%q, %d) = %q, want %q", tt.log, tt.maxEntries, got, tt.want)
+		}
+	}
+}
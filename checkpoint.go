@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+// checkpoint records the jobs a run deleted in a file, so a restarted run can
+// skip them, see "-checkpoint". A nil checkpoint records nothing.
+//
+// Each job is appended as its own "namespace/name/uid" line and synced, so a
+// crash loses at most the line being written, which is ignored when loading.
+type checkpoint struct {
+	mu   sync.Mutex
+	f    *os.File
+	done map[string]bool
+}
+
+// checkpointKey identifies the exact job object, a job recreated with the same
+// name isn't skipped.
+func checkpointKey(dj kubeJob) string {
+	return dj.namespace + "/" + dj.name + "/" + dj.uid
+}
+
+// openCheckpoint loads the jobs recorded in the file at path, creating it if
+// it doesn't exist, and opens it for recording further jobs.
+func openCheckpoint(path string) (*checkpoint, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("Failed to read checkpoint: %v", err)
+	}
+	done := make(map[string]bool)
+	lines := strings.Split(string(data), "\n")
+	// The last element is empty unless the last write was cut short.
+	for _, line := range lines[:len(lines)-1] {
+		if line != "" {
+			done[line] = true
+		}
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open checkpoint: %v", err)
+	}
+	if last := lines[len(lines)-1]; last != "" {
+		// Start the next record on its own line.
+		if _, err := f.WriteString("\n"); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("Failed to write checkpoint: %v", err)
+		}
+	}
+	return &checkpoint{f: f, done: done}, nil
+}
+
+// filter returns the jobs that weren't recorded yet and how many were.
+func (c *checkpoint) filter(jobs []kubeJob) ([]kubeJob, int) {
+	if c == nil {
+		return jobs, 0
+	}
+	var remaining []kubeJob
+	for _, dj := range jobs {
+		if !c.done[checkpointKey(dj)] {
+			remaining = append(remaining, dj)
+		}
+	}
+	return remaining, len(jobs) - len(remaining)
+}
+
+// record appends the job to the checkpoint. It's safe for concurrent use.
+func (c *checkpoint) record(dj kubeJob) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.f.WriteString(checkpointKey(dj) + "\n"); err != nil {
+		return fmt.Errorf("Failed to write checkpoint: %v", err)
+	}
+	if err := c.f.Sync(); err != nil {
+		return fmt.Errorf("Failed to write checkpoint: %v", err)
+	}
+	c.done[checkpointKey(dj)] = true
+	return nil
+}
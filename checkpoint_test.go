@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckpointResume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint")
+	var jobs []kubeJob
+	api, client := newFakeAPI(t)
+	for i := 0; i < 3; i++ {
+		j := completedJob("ns", fmt.Sprintf("job-%d", i), 5)
+		api.serveJobs(j)
+		jobs = append(jobs, newKubeJob(j, testNow))
+	}
+	api.servePods("ns")
+	// The run is cut short after deleting the first job.
+	api.handle("DELETE", "/apis/batch/v1/namespaces/ns/jobs/job-1", func(w http.ResponseWriter, r *http.Request) {
+		writeStatus(w, r, http.StatusInternalServerError, "etcd timeout")
+	})
+	cfg := testConfig()
+	cfg.deleteJobs = true
+	cfg.failFast = true
+	var err error
+	if cfg.checkpoint, err = openCheckpoint(path); err != nil {
+		t.Fatal(err)
+	}
+	captureStdout(t)
+	cleanupJobs(client, jobs, cfg, &runSummary{}, 1)
+	cfg.checkpoint.f.Close()
+
+	// A crash left half a record behind.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("ns/job-2/ns-jo")
+	f.Close()
+
+	resumed, err := openCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resumed.f.Close()
+	remaining, done := resumed.filter(jobs)
+	if done != 1 || len(remaining) != 2 || remaining[0].name != "job-1" || remaining[1].name != "job-2" {
+		t.Errorf("Resumed with %d done and %v remaining, want job-0 done", done, remaining)
+	}
+	// A job recreated under the same name is a different job.
+	recreated := jobs[0]
+	recreated.uid = "new"
+	if _, done := resumed.filter([]kubeJob{recreated}); done != 0 {
+		t.Error("Recreated job skipped")
+	}
+
+	if err := resumed.record(remaining[0]); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "ns/job-0/ns-job-0\nns/job-2/ns-jo\nns/job-1/ns-job-1\n"; string(data) != want {
+		t.Errorf("Checkpoint %q, want %q", data, want)
+	}
+}
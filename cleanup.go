@@ -0,0 +1,457 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ericchiang/k8s"
+	apiv1 "github.com/ericchiang/k8s/api/v1"
+)
+
+// jobResult is the outcome of cleaning up a single job. Each concurrent worker
+// owns its jobResult, so no locking is needed until they are merged.
+type jobResult struct {
+	out bytes.Buffer
+	sum runSummary
+}
+
+// cleanupJobs cleans up the jobs, running up to concurrency of them at a
+// time. Output and counts are merged into sum in job order, so the result is
+// the same regardless of the order in which the jobs finish.
+func cleanupJobs(client *k8s.Client, jobs []kubeJob, cfg *runConfig, sum *runSummary, concurrency int) {
+	if concurrency <= 1 {
+		for _, dj := range jobs {
+			deleted := sum.JobsDeleted
+			cleanupJob(client, dj, cfg, stdout, sum)
+			recordDeleted(dj, sum.JobsDeleted > deleted, cfg, stdout, sum)
+			if cfg.stopped(sum) {
+				return
+			}
+		}
+		return
+	}
+	results := make([]jobResult, len(jobs))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	// failed is set once a job had an error with "-fail-fast", no more jobs
+	// are started after that. Jobs already running are finished.
+	var failed int32
+	for i := range jobs {
+		sem <- struct{}{}
+		if atomic.LoadInt32(&failed) != 0 || interrupted() {
+			break
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			cleanupJob(client, jobs[i], cfg, &results[i].out, &results[i].sum)
+			recordDeleted(jobs[i], results[i].sum.JobsDeleted > 0, cfg, &results[i].out, &results[i].sum)
+			if cfg.stopped(&results[i].sum) {
+				atomic.StoreInt32(&failed, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	for i := range results {
+		stdout.Write(results[i].out.Bytes())
+		sum.add(&results[i].sum)
+	}
+}
+
+// recordDeleted records the job in cfg.checkpoint if it was deleted.
+func recordDeleted(dj kubeJob, deleted bool, cfg *runConfig, w io.Writer, sum *runSummary) {
+	if !deleted || !cfg.deleteJobs {
+		return
+	}
+	if err := cfg.checkpoint.record(dj); err != nil {
+		fmt.Fprintf(w, "%s\n", err.Error())
+		sum.Errors++
+	}
+}
+
+// cleanupJob lists the pods belonging to the job and, if cfg.deleteJobs is set,
+// deletes the finished ones followed by the job itself. Output is written to w
+// and the outcome is recorded in sum.
+func cleanupJob(client *k8s.Client, dj kubeJob, cfg *runConfig, w io.Writer, sum *runSummary) {
+	w = cfg.scopedWriter(w, dj.namespace, dj.name)
+	podsBefore := sum.PodsDeleted
+	if cfg.verifyCompletion {
+		countAPICall(apiGet)
+		j, err := client.BatchV1().GetJob(context.Background(), dj.name, dj.namespace)
+		if err != nil {
+			fmt.Fprintf(w, "Unable to verify completion of job %s. Skipping this job. Error: %s\n", dj.name, err.Error())
+			sum.Errors++
+			return
+		}
+		if changed(dj, j.Metadata) {
+			fmt.Fprintf(w, "Job %s in namespace %s changed since it was selected, skipping.\n", dj.name, dj.namespace)
+			sum.skip("changed", 1)
+			return
+		}
+		if !jobDone(j, cfg) && !dj.staleActive && !dj.forced {
+			conditions := strings.Join(cfg.completionConditions, " or ")
+			check, reason := conditions+" condition?", "has no "+conditions+" condition"
+			if cfg.completionStrategy == strategyCounts {
+				check, reason = "finished by pod counts?", "isn't finished by its pod counts"
+			}
+			if cfg.explain {
+				fmt.Fprintf(w, "Explain %s/%s: %s no → SKIPPED\n", dj.namespace, dj.name, check)
+			}
+			fmt.Fprintf(w, "Job %s in namespace %s %s, skipping.\n", dj.name, dj.namespace, reason)
+			sum.skip("unfinished", 1)
+			return
+		}
+	}
+	if cfg.deleteJobs {
+		cfg.printItem(w, jobItem(dj, actionDelete), "Deleting job: %s\tNamespace:%s\tAge:%vd\n", dj.name, dj.namespace, dj.age)
+	} else {
+		cfg.printItem(w, jobItem(dj, actionWouldDelete), "Name: %s\tNamespace: %s\tAge:%vd\n", dj.name, dj.namespace, dj.age)
+	}
+	if cfg.noPodScan {
+		// Only allowed in dry-run, the pods are simply left out.
+		sum.JobsDeleted++
+		sum.deleted(dj.reason)
+		return
+	}
+	if cfg.deleteJobsFirst && !cfg.deleteJobs {
+		// The pods are still listed, so the dry-run shows what the garbage
+		// collector would delete.
+		fmt.Fprintf(w, "\tPods will be deleted by the garbage collector.\n")
+	} else if cfg.deleteJobsFirst {
+		// Let the garbage collector remove the pods, saving the pod list and
+		// every pod delete. Pods are only deleted explicitly if that fails.
+		propagation := cfg.jobPropagation
+		if propagation == "" {
+			propagation = "Background"
+		}
+		err := deleteJob(client, dj, propagation)
+		if isConflict(err) {
+			fmt.Fprintf(w, "Job %s in namespace %s changed since it was selected, not deleting it.\n", dj.name, dj.namespace)
+			sum.skip("changed", 1)
+			return
+		}
+		if err == nil {
+			sum.JobsDeleted++
+			sum.deleted(dj.reason)
+			reportJobGone(client, dj, cfg, w, sum)
+			cleanupAssociated(client, dj, cfg, w, sum)
+			return
+		}
+		fmt.Fprintf(w, "\tUnable to delete job %s with background propagation, deleting its pods first. Error: %s\n", dj.name, err.Error())
+	}
+	// First use the job labels to find the corresponding pods to delete
+	listStart := time.Now()
+	pods, podErr := listJobPods(client, dj, cfg.jobLabels, w)
+	fdebugf(w, "Listing pods for job %s in namespace %s took %v\n", dj.name, dj.namespace, time.Since(listStart))
+	if podErr != nil {
+		fmt.Fprintf(w, "Unable to list pods labelled with job %s. Skipping this job.", dj.name)
+		fmt.Fprintf(w, "ERROR: Job %s skipped. %s.", dj.name, podErr.Error())
+		sum.Errors++
+		return
+	}
+	var eligiblePods []kubePod
+	if len(pods) > 0 {
+		for _, p := range pods {
+			// Build a slice of eligible jobs to avoid calling the API more than needed
+			if *p.Status.Phase == "Succeeded" || *p.Status.Phase == "Failed" {
+				eligiblePods = append(eligiblePods, newKubePod(p))
+				continue
+			}
+			if cfg.handleDeadNodes {
+				dead, err := deadNodes.onDeadNode(client, p)
+				if err != nil {
+					fmt.Fprintf(w, "\t%s.\n", err.Error())
+				}
+				if dead {
+					fmt.Fprintf(w, "\tPod %s is on node %s which no longer exists.\n", p.Metadata.GetName(), p.Spec.GetNodeName())
+					dp := newKubePod(p)
+					dp.deadNode = true
+					eligiblePods = append(eligiblePods, dp)
+					continue
+				}
+			}
+			if !cfg.quiet() {
+				fmt.Fprintf(w, "\tPod associated with %s is not in \"Succeeded\" or \"Failed\" phase but job is complete.", dj.name)
+				fmt.Fprintf(w, "\tPod %s is in phase %s, skipping.\n", p.Metadata.GetName(), p.Status.GetPhase())
+			}
+		}
+		if cfg.reportRestarts {
+			reportRestarts(dj, pods, w, sum)
+		}
+		if left := len(pods) - len(eligiblePods); left > 0 && !cfg.deleteJobs && cfg.jobPropagation == "Orphan" {
+			// Plain deletes of the job would leave these for the orphan
+			// scan of a later run.
+			fmt.Fprintf(w, "\tWould orphan %d pods of job %s with -job-propagation Orphan.\n", left, dj.name)
+			sum.PodsOrphaned += left
+		}
+		if len(eligiblePods) > 0 {
+			deleteStart := time.Now()
+			deleted := 0
+			for _, dp := range eligiblePods {
+				if !cfg.deleteJobs {
+					cfg.printItem(w, podItem(dp, actionWouldDelete), "\tPod: %s\tNamespace: %s\tPhase: %s\n", dp.name, dp.namespace, dp.phase)
+					sum.PodsDeleted++
+					continue
+				}
+				if err := savePodLogs(client, dp, cfg); err != nil {
+					fmt.Fprintf(w, "\tUnable to save logs of pod %s, not deleting it. Error: %s\n", dp.name, err.Error())
+					sum.Errors++
+					if cfg.stopped(sum) {
+						return
+					}
+					continue
+				}
+				cfg.printItem(w, podItem(dp, actionDelete), "\tDeleting pod: %s\tPhase: %s\n", dp.name, dp.phase)
+				if dp.deadNode {
+					podErr = forceDeletePod(client, dp)
+				} else {
+					podErr = deletePod(client, dp, cfg, w)
+				}
+				if podErr != nil {
+					fmt.Fprintf(w, "\tUnable to delete pod %s. Error: %s\n", dp.name, podErr.Error())
+					sum.Errors++
+					if cfg.stopped(sum) {
+						return
+					}
+					continue
+				}
+				sum.PodsDeleted++
+				deleted++
+				cfg.progress(w, deleted, len(eligiblePods), dj.name)
+			}
+			if cfg.deleteJobs {
+				fdebugf(w, "Deleting %d pods for job %s in namespace %s took %v\n", len(eligiblePods), dj.name, dj.namespace, time.Since(deleteStart))
+			}
+		} else if !cfg.quiet() {
+			fmt.Fprintf(w, "\tNo pods eligible for deletion associated with job %s, %s.\n", dj.name, jobOnly(cfg))
+		}
+	} else if !cfg.quiet() {
+		// Usually the pods were already garbage collected, the job itself is
+		// still deleted.
+		fmt.Fprintf(w, "\tNo pods associated with job %s, %s.\n", dj.name, jobOnly(cfg))
+	}
+
+	if !cfg.deleteJobs {
+		sum.JobsDeleted++
+		sum.deleted(dj.reason)
+		cleanupAssociated(client, dj, cfg, w, sum)
+		return
+	}
+	err := deleteJob(client, dj, cfg.jobPropagation)
+	if err != nil && !isConflict(err) && sum.PodsDeleted > podsBefore {
+		// The pods are already gone, so try once more rather than leave an
+		// empty job behind.
+		fmt.Fprintf(w, "\tUnable to delete job %s after deleting its pods, retrying. Error: %s\n", dj.name, err.Error())
+		time.Sleep(time.Second)
+		err = deleteJob(client, dj, cfg.jobPropagation)
+	}
+	if isConflict(err) {
+		fmt.Fprintf(w, "Job %s in namespace %s changed since it was selected, not deleting it.\n", dj.name, dj.namespace)
+		sum.skip("changed", 1)
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(w, "Unable to delete job %s.\n Error: %v\n", dj.name, err.Error())
+		sum.Errors++
+		if sum.PodsDeleted > podsBefore {
+			sum.PartiallyDeleted = append(sum.PartiallyDeleted, dj.namespace+"/"+dj.name)
+		}
+		return
+	}
+	sum.JobsDeleted++
+	sum.deleted(dj.reason)
+	reportJobGone(client, dj, cfg, w, sum)
+	cleanupAssociated(client, dj, cfg, w, sum)
+}
+
+// reportRestarts prints the total container restarts of the job's pods and
+// records jobs with any restarts in sum.
+func reportRestarts(dj kubeJob, pods []*apiv1.Pod, w io.Writer, sum *runSummary) {
+	var restarts int32
+	for _, p := range pods {
+		restarts += newKubePod(p).restarts
+	}
+	fmt.Fprintf(w, "\tRestarts: %d across %d pods\n", restarts, len(pods))
+	if restarts > 0 {
+		if sum.Restarts == nil {
+			sum.Restarts = make(map[string]int32)
+		}
+		sum.Restarts[dj.namespace+"/"+dj.name] += restarts
+	}
+}
+
+// jobOnly describes what happens to a job none of whose pods are deleted.
+func jobOnly(cfg *runConfig) string {
+	if cfg.deleteJobs {
+		return "deleting the job only"
+	}
+	return "would delete the job only"
+}
+
+// reapJobPods deletes the finished pods of a job that is kept because it's
+// younger than "-days", if the pods are at least cfg.podDays old. The job
+// itself isn't touched.
+func reapJobPods(client *k8s.Client, dj kubeJob, cfg *runConfig, w io.Writer, sum *runSummary) {
+	w = cfg.scopedWriter(w, dj.namespace, dj.name)
+	pods, err := listJobPods(client, dj, cfg.jobLabels, w)
+	if err != nil {
+		fmt.Fprintf(w, "Unable to list pods labelled with job %s. Error: %s\n", dj.name, err.Error())
+		sum.Errors++
+		return
+	}
+	now := time.Now()
+	var reap []kubePod
+	for _, p := range pods {
+		phase := p.Status.GetPhase()
+		if (phase == "Succeeded" || phase == "Failed") && podDaysOld(p, now) >= cfg.podDays {
+			reap = append(reap, newKubePod(p))
+		}
+	}
+	deleteReapedPods(client, dj, reap, cfg, w, sum)
+}
+
+// reapRunningJobPods deletes the succeeded pods of an active job that are at
+// least cfg.podDays old, leaving the job and its other pods alone.
+//
+// A succeeded pod can only be deleted once the job controller has counted it,
+// or the job would run it again. That's the case when the job is tracked with
+// finalizers and the pod no longer carries the tracking finalizer. Jobs
+// tracked the legacy way, by counting the pods that exist, are skipped.
+func reapRunningJobPods(client *k8s.Client, dj kubeJob, cfg *runConfig, w io.Writer, sum *runSummary) {
+	w = cfg.scopedWriter(w, dj.namespace, dj.name)
+	pods, err := listJobPods(client, dj, cfg.jobLabels, w)
+	if err != nil {
+		fmt.Fprintf(w, "Unable to list pods labelled with job %s. Error: %s\n", dj.name, err.Error())
+		sum.Errors++
+		return
+	}
+	tracked := dj.finalizerTracked
+	for _, p := range pods {
+		if hasTrackingFinalizer(p) {
+			tracked = true
+		}
+	}
+	if !tracked {
+		debugf("Job %s in namespace %s isn't tracked with finalizers, not reaping its pods\n", dj.name, dj.namespace)
+		return
+	}
+	now := time.Now()
+	var reap []kubePod
+	for _, p := range pods {
+		if p.Status.GetPhase() == "Succeeded" && !hasTrackingFinalizer(p) && podDaysOld(p, now) >= cfg.podDays {
+			reap = append(reap, newKubePod(p))
+		}
+	}
+	deleteReapedPods(client, dj, reap, cfg, w, sum)
+}
+
+// hasTrackingFinalizer reports whether the job controller has yet to count the
+// pod.
+func hasTrackingFinalizer(p *apiv1.Pod) bool {
+	for _, f := range p.Metadata.GetFinalizers() {
+		if f == jobTrackingFinalizer {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteReapedPods deletes the reaped pods of a job that is kept.
+func deleteReapedPods(client *k8s.Client, dj kubeJob, reap []kubePod, cfg *runConfig, w io.Writer, sum *runSummary) {
+	if len(reap) == 0 {
+		return
+	}
+	cfg.printItem(w, jobItem(dj, actionKeep), "Keeping job: %s\tNamespace: %s\tAge:%vd\n", dj.name, dj.namespace, dj.age)
+	for _, dp := range reap {
+		if !cfg.deleteJobs {
+			cfg.printItem(w, podItem(dp, actionWouldDelete), "\tPod: %s\tNamespace: %s\tPhase: %s\n", dp.name, dp.namespace, dp.phase)
+			sum.PodsDeleted++
+			continue
+		}
+		if err := savePodLogs(client, dp, cfg); err != nil {
+			fmt.Fprintf(w, "\tUnable to save logs of pod %s, not deleting it. Error: %s\n", dp.name, err.Error())
+			sum.Errors++
+			if cfg.stopped(sum) {
+				return
+			}
+			continue
+		}
+		cfg.printItem(w, podItem(dp, actionDelete), "\tDeleting pod: %s\tPhase: %s\n", dp.name, dp.phase)
+		if err := deletePod(client, dp, cfg, w); err != nil {
+			fmt.Fprintf(w, "\tUnable to delete pod %s. Error: %s\n", dp.name, err.Error())
+			sum.Errors++
+			if cfg.stopped(sum) {
+				return
+			}
+			continue
+		}
+		sum.PodsDeleted++
+	}
+}
+
+// otherJobOwner returns the name of the job owning the pod if that isn't dj,
+// comparing UIDs so a job recreated with the same name is told apart. Pods
+// without a Job owner reference aren't attributed elsewhere.
+func otherJobOwner(p *apiv1.Pod, dj kubeJob) (string, bool) {
+	if dj.uid == "" {
+		return "", false
+	}
+	owner := ""
+	for _, ref := range p.Metadata.GetOwnerReferences() {
+		if ref.GetKind() != "Job" {
+			continue
+		}
+		if ref.GetUid() == dj.uid {
+			return "", false
+		}
+		owner = ref.GetName()
+	}
+	if owner == "" {
+		return "", false
+	}
+	if owner == dj.name {
+		owner += " (a different UID)"
+	}
+	return owner, true
+}
+
+// listJobPods lists the pods in the job's namespace that carry any of the job
+// label keys with the job's name as value. Pods owned by a different job are
+// mislabelled and left out with a warning written to w, so a job's cleanup
+// never deletes another job's pods.
+func listJobPods(client *k8s.Client, dj kubeJob, jobLabels []string, w io.Writer) ([]*apiv1.Pod, error) {
+	seen := make(map[string]bool)
+	var pods []*apiv1.Pod
+	for _, key := range jobLabels {
+		podLS := new(k8s.LabelSelector)
+		podLS.Eq(key, dj.name)
+		var list *apiv1.PodList
+		err := apiRetry.do(func() (err error) {
+			countAPICall(apiList)
+			list, err = client.CoreV1().ListPods(context.Background(), dj.namespace, podLS.Selector())
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range list.Items {
+			if seen[p.Metadata.GetName()] {
+				continue
+			}
+			seen[p.Metadata.GetName()] = true
+			if owner, ok := otherJobOwner(p, dj); ok {
+				fmt.Fprintf(w, "\tPod %s is labelled with job %s but owned by job %s, skipping it.\n", p.Metadata.GetName(), dj.name, owner)
+				continue
+			}
+			pods = append(pods, p)
+		}
+	}
+	return pods, nil
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ericchiang/k8s"
+	apiv1 "github.com/ericchiang/k8s/api/v1"
+	"github.com/golang/protobuf/proto"
+)
+
+func TestJobFinished(t *testing.T) {
+	conditions := []string{"Complete", "Failed"}
+	pending := newTestJob("ns", "pending")
+	if jobFinished(pending, conditions) {
+		t.Error("unstarted job is finished")
+	}
+	if !jobFinished(completedJob("ns", "done", 1), conditions) {
+		t.Error("completed job isn't finished")
+	}
+}
+
+func TestVerifyCompletion(t *testing.T) {
+	pending := newTestJob("ns", "pending")
+	done := completedJob("ns", "done", 5)
+	api, client := newFakeAPI(t)
+	api.serveJobs(pending, done)
+	api.servePods("ns", testPod(done, "done-1", "Succeeded"))
+	cfg := testConfig()
+	cfg.deleteJobs = true
+	cfg.verifyCompletion = true
+	sum := &runSummary{}
+	// Both were selected, but the pending one was recreated since and hasn't
+	// started.
+	cleanupJobs(client, []kubeJob{newKubeJob(pending, testNow), newKubeJob(done, testNow)}, cfg, sum, 1)
+	if sum.JobsDeleted != 1 || sum.Skipped["unfinished"] != 1 {
+		t.Errorf("deleted %d jobs, skipped %v, want 1 deleted and 1 unfinished", sum.JobsDeleted, sum.Skipped)
+	}
+	if api.count("DELETE", "/apis/batch/v1/namespaces/ns/jobs/pending") != 0 {
+		t.Error("pending job was deleted")
+	}
+	if api.count("DELETE", "/apis/batch/v1/namespaces/ns/jobs/done") != 1 {
+		t.Error("completed job wasn't deleted")
+	}
+}
+
+func TestListJobPodsLabelKeys(t *testing.T) {
+	j := completedJob("ns", "backup", 5)
+	legacy := testPod(j, "legacy", "Succeeded")
+	current := testPod(j, "current", "Succeeded")
+	current.Metadata.Labels = map[string]string{"batch.kubernetes.io/job-name": "backup"}
+	both := testPod(j, "both", "Succeeded")
+	both.Metadata.Labels["batch.kubernetes.io/job-name"] = "backup"
+	other := testPod(completedJob("ns", "report", 5), "other", "Succeeded")
+	api, client := newFakeAPI(t)
+	api.servePods("ns", legacy, current, both, other)
+	pods, err := listJobPods(client, newKubeJob(j, testNow), []string{"batch.kubernetes.io/job-name", "job-name"}, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, p := range pods {
+		names = append(names, p.Metadata.GetName())
+	}
+	if want := []string{"current", "both", "legacy"}; !equalStrings(names, want) {
+		t.Errorf("pods = %v, want %v", names, want)
+	}
+	if n := api.count("GET", "/api/v1/namespaces/ns/pods"); n != 2 {
+		t.Errorf("%d pod lists, want one per label key", n)
+	}
+}
+
+func TestListJobPodsSkipsMislabelledPods(t *testing.T) {
+	j := completedJob("ns", "backup", 5)
+	own := testPod(j, "own", "Succeeded")
+	// Labelled with backup but owned by report, and by an earlier backup
+	// that was deleted and recreated.
+	other := testPod(completedJob("ns", "report", 5), "other", "Succeeded")
+	other.Metadata.Labels["job-name"] = "backup"
+	earlier := testPod(j, "earlier", "Succeeded")
+	earlier.Metadata.OwnerReferences[0].Uid = k8s.String("ns-backup-old")
+	// Without a Job owner reference the label is all there is to go on.
+	unowned := testPod(j, "unowned", "Succeeded")
+	unowned.Metadata.OwnerReferences = nil
+	api, client := newFakeAPI(t)
+	api.servePods("ns", own, other, earlier, unowned)
+	var out bytes.Buffer
+	pods, err := listJobPods(client, newKubeJob(j, testNow), []string{"job-name"}, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, p := range pods {
+		names = append(names, p.Metadata.GetName())
+	}
+	if want := []string{"own", "unowned"}; !equalStrings(names, want) {
+		t.Errorf("pods = %v, want %v", names, want)
+	}
+	for _, want := range []string{
+		"Pod other is labelled with job backup but owned by job report, skipping it.",
+		"Pod earlier is labelled with job backup but owned by job backup (a different UID), skipping it.",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output %q doesn't contain %q", out.String(), want)
+		}
+	}
+
+	// A job without a UID can't tell its pods apart from another's.
+	if owner, ok := otherJobOwner(other, kubeJob{name: "backup", namespace: "ns"}); ok {
+		t.Errorf("pod attributed to %s for a job without a UID", owner)
+	}
+}
+
+func TestCleanupJobsConcurrentSummary(t *testing.T) {
+	api, client := newFakeAPI(t)
+	var jobs []kubeJob
+	var pods []*apiv1.Pod
+	for i := 0; i < 20; i++ {
+		j := completedJob("ns", fmt.Sprintf("job-%02d", i), 5)
+		api.serveJobs(j)
+		pods = append(pods, testPod(j, fmt.Sprintf("job-%02d-a", i), "Succeeded"), testPod(j, fmt.Sprintf("job-%02d-b", i), "Failed"))
+		jobs = append(jobs, newKubeJob(j, testNow))
+	}
+	api.servePods("ns", pods...)
+	cfg := testConfig()
+	cfg.deleteJobs = true
+	sum := &runSummary{}
+	cleanupJobs(client, jobs, cfg, sum, 8)
+	if sum.JobsDeleted != 20 || sum.PodsDeleted != 40 || sum.Errors != 0 {
+		t.Errorf("deleted %d jobs and %d pods with %d errors, want 20, 40, 0", sum.JobsDeleted, sum.PodsDeleted, sum.Errors)
+	}
+	if n := sum.DeletedByReason[string(reasonCompletedAndOld)]; n != 20 {
+		t.Errorf("%d jobs deleted as completed and old, want 20", n)
+	}
+}
+
+func TestReapUnderThreshold(t *testing.T) {
+	cfg := testConfig()
+	cfg.olderThanDays = 7
+	cfg.reapPods = true
+	cfg.podDays = 2
+	if _, ok := reapableJob(completedJob("ns", "old", 10), testNow, cfg); ok {
+		t.Error("job over -days is reapable, it should be deleted instead")
+	}
+	if _, ok := reapableJob(newTestJob("ns", "pending"), testNow, cfg); ok {
+		t.Error("unfinished job is reapable")
+	}
+	j := completedJob("ns", "young", 3)
+	dj, ok := reapableJob(j, testNow, cfg)
+	if !ok {
+		t.Fatal("job under -days isn't reapable")
+	}
+
+	// Pod ages are judged at the time of the run.
+	started := func(p *apiv1.Pod, daysAgo int) *apiv1.Pod {
+		p.Status.StartTime = metaTime(time.Now().AddDate(0, 0, -daysAgo))
+		return p
+	}
+	api, client := newFakeAPI(t)
+	api.servePods("ns",
+		started(testPod(j, "young-old", "Succeeded"), 5),
+		started(testPod(j, "young-failed", "Failed"), 2),
+		started(testPod(j, "young-recent", "Succeeded"), 1),
+		started(testPod(j, "young-running", "Running"), 5))
+	cfg.deleteJobs = true
+	sum := &runSummary{}
+	reapJobPods(client, dj, cfg, ioutil.Discard, sum)
+	if sum.PodsDeleted != 2 || sum.JobsDeleted != 0 {
+		t.Errorf("deleted %d pods and %d jobs, want 2 and 0", sum.PodsDeleted, sum.JobsDeleted)
+	}
+	for pod, want := range map[string]int{"young-old": 1, "young-failed": 1, "young-recent": 0, "young-running": 0} {
+		if n := api.count("DELETE", "/api/v1/namespaces/ns/pods/"+pod); n != want {
+			t.Errorf("pod %s deleted %d times, want %d", pod, n, want)
+		}
+	}
+	if api.count("DELETE", "/apis/batch/v1/") != 0 {
+		t.Error("the kept job was deleted")
+	}
+}
+
+func TestDeleteJobsFirstDryRunListsPods(t *testing.T) {
+	j := completedJob("ns", "backup", 5)
+	api, client := newFakeAPI(t)
+	api.servePods("ns", testPod(j, "backup-1", "Succeeded"), testPod(j, "backup-2", "Failed"))
+	cfg := testConfig()
+	cfg.deleteJobsFirst = true
+	cfg.jobPropagation = "Background"
+	sum := &runSummary{}
+	cleanupJob(client, newKubeJob(j, testNow), cfg, ioutil.Discard, sum)
+	if sum.JobsDeleted != 1 || sum.PodsDeleted != 2 {
+		t.Errorf("dry-run counted %d jobs and %d pods, want 1 and 2", sum.JobsDeleted, sum.PodsDeleted)
+	}
+	if api.count("DELETE", "/") != 0 {
+		t.Error("dry-run deleted something")
+	}
+}
+
+// BenchmarkCleanupJobAPICalls reports the API calls made to delete a job with
+// 20 pods, with and without -delete-jobs-first.
+func BenchmarkCleanupJobAPICalls(b *testing.B) {
+	j := completedJob("ns", "backup", 5)
+	var pods []*apiv1.Pod
+	for i := 0; i < 20; i++ {
+		pods = append(pods, testPod(j, fmt.Sprintf("backup-%d", i), "Succeeded"))
+	}
+	for _, jobsFirst := range []bool{false, true} {
+		name := "pods-first"
+		if jobsFirst {
+			name = "jobs-first"
+		}
+		b.Run(name, func(b *testing.B) {
+			api, client := newFakeAPI(b)
+			api.servePods("ns", pods...)
+			api.handle("DELETE", "/apis/batch/v1/namespaces/ns/jobs/backup", func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, map[string]string{"status": "Success"})
+			})
+			cfg := testConfig()
+			cfg.deleteJobs = true
+			cfg.deleteJobsFirst = jobsFirst
+			cfg.jobPropagation = "Background"
+			dj := newKubeJob(j, testNow)
+			before := totalAPICalls()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				cleanupJob(client, dj, cfg, ioutil.Discard, &runSummary{})
+			}
+			b.ReportMetric(float64(totalAPICalls()-before)/float64(b.N), "calls/job")
+		})
+	}
+}
+
+func TestPartialDeletionRetry(t *testing.T) {
+	for _, failures := range []int{1, 2} {
+		j := completedJob("ns", "backup", 5)
+		api, client := newFakeAPI(t)
+		api.servePods("ns", testPod(j, "backup-1", "Succeeded"))
+		n := 0
+		api.handle("DELETE", "/apis/batch/v1/namespaces/ns/jobs/backup", func(w http.ResponseWriter, r *http.Request) {
+			if n++; n <= failures {
+				writeStatus(w, r, http.StatusInternalServerError, "etcd unavailable")
+				return
+			}
+			writeJSON(w, map[string]string{"status": "Success"})
+		})
+		cfg := testConfig()
+		cfg.deleteJobs = true
+		sum := &runSummary{}
+		cleanupJob(client, newKubeJob(j, testNow), cfg, ioutil.Discard, sum)
+		if n != 2 {
+			t.Errorf("%d failures: job delete sent %d times, want 2", failures, n)
+		}
+		if failures == 1 && (sum.JobsDeleted != 1 || sum.Errors != 0 || len(sum.PartiallyDeleted) != 0) {
+			t.Errorf("retry succeeded: %d deleted, %d errors, partial %v", sum.JobsDeleted, sum.Errors, sum.PartiallyDeleted)
+		}
+		if failures == 2 && (sum.JobsDeleted != 0 || sum.Errors != 1 || !equalStrings(sum.PartiallyDeleted, []string{"ns/backup"})) {
+			t.Errorf("retry failed: %d deleted, %d errors, partial %v", sum.JobsDeleted, sum.Errors, sum.PartiallyDeleted)
+		}
+	}
+
+	// Without any pod deleted, a failed job delete isn't retried.
+	j := completedJob("ns", "empty", 5)
+	api, client := newFakeAPI(t)
+	api.servePods("ns")
+	api.handle("DELETE", "/apis/batch/v1/namespaces/ns/jobs/empty", func(w http.ResponseWriter, r *http.Request) {
+		writeStatus(w, r, http.StatusInternalServerError, "etcd unavailable")
+	})
+	cfg := testConfig()
+	cfg.deleteJobs = true
+	sum := &runSummary{}
+	cleanupJob(client, newKubeJob(j, testNow), cfg, ioutil.Discard, sum)
+	if n := api.count("DELETE", "/apis/batch/v1/"); n != 1 || len(sum.PartiallyDeleted) != 0 {
+		t.Errorf("job without pods: %d deletes, partial %v, want 1 and none", n, sum.PartiallyDeleted)
+	}
+}
+
+func TestCleanupJobWithoutPods(t *testing.T) {
+	j := completedJob("ns", "backup", 5)
+	for _, deleteJobs := range []bool{false, true} {
+		api, client := newFakeAPI(t)
+		api.serveJobs(j)
+		api.servePods("ns")
+		cfg := testConfig()
+		cfg.deleteJobs = deleteJobs
+		var out bytes.Buffer
+		sum := &runSummary{}
+		cleanupJob(client, newKubeJob(j, testNow), cfg, &out, sum)
+		if sum.JobsDeleted != 1 || sum.PodsDeleted != 0 || sum.Errors != 0 {
+			t.Errorf("-f=%v: %d jobs, %d pods, %d errors, want the job only", deleteJobs, sum.JobsDeleted, sum.PodsDeleted, sum.Errors)
+		}
+		if !strings.Contains(out.String(), "No pods associated with job backup, "+jobOnly(cfg)) {
+			t.Errorf("-f=%v: output %q doesn't mention the missing pods", deleteJobs, out.String())
+		}
+		want := 0
+		if deleteJobs {
+			want = 1
+		}
+		if n := api.count("DELETE", "/apis/batch/v1/namespaces/ns/jobs/backup"); n != want {
+			t.Errorf("-f=%v: job deleted %d times, want %d", deleteJobs, n, want)
+		}
+	}
+}
+
+func TestReapRunningJobPodsCompletionCounting(t *testing.T) {
+	j := newTestJob("ns", "indexed")
+	j.Status.Active = proto.Int32(2)
+	counted := testPod(j, "indexed-0", "Succeeded")
+	uncounted := testPod(j, "indexed-1", "Succeeded")
+	uncounted.Metadata.Finalizers = []string{jobTrackingFinalizer}
+	recent := testPod(j, "indexed-2", "Succeeded")
+	recent.Status.StartTime = metaTime(time.Now())
+	running := testPod(j, "indexed-3", "Running")
+	running.Metadata.Finalizers = []string{jobTrackingFinalizer}
+
+	cfg := testConfig()
+	cfg.deleteJobs = true
+	cfg.podDays = 1
+	captureStdout(t)
+	for _, tt := range []struct {
+		name    string
+		pods    []*apiv1.Pod
+		deleted []string
+	}{
+		// Only the pod the job controller has counted may go.
+		{"tracked with finalizers", []*apiv1.Pod{counted, uncounted, recent, running}, []string{"indexed-0"}},
+		// Pods of a job counting the pods that exist are never deleted.
+		{"legacy tracking", []*apiv1.Pod{counted, recent}, nil},
+	} {
+		api, client := newFakeAPI(t)
+		api.servePods("ns", tt.pods...)
+		cfg := *cfg
+		kj, ok := runningReapableJob(j, testNow)
+		if !ok {
+			t.Fatal("Active job not reapable")
+		}
+		sum := &runSummary{}
+		reapRunningJobPods(client, kj, &cfg, ioutil.Discard, sum)
+		var deleted []string
+		for _, p := range tt.pods {
+			if api.count("DELETE", podsPath("ns")+"/"+p.Metadata.GetName()) > 0 {
+				deleted = append(deleted, p.Metadata.GetName())
+			}
+		}
+		if !equalStrings(deleted, tt.deleted) {
+			t.Errorf("%s: deleted %v, want %v", tt.name, deleted, tt.deleted)
+		}
+		if api.count("DELETE", jobsPath("ns")) > 0 {
+			t.Errorf("%s: deleted the running job", tt.name)
+		}
+	}
+
+	j.Status.CompletionTime = metaTime(testNow)
+	if _, ok := runningReapableJob(j, testNow); ok {
+		t.Error("Completed job reapable as running")
+	}
+}
+
+func TestCleanupJobPropagation(t *testing.T) {
+	j := completedJob("ns", "backup", 5)
+	tests := []struct {
+		name        string
+		jobsFirst   bool
+		propagation string
+		want        string
+	}{
+		// Pods skipped as still running must not be removed along with
+		// the job.
+		{"default", false, "", ""},
+		{"-delete-jobs-first", true, "", "Background"},
+		{"explicit", false, "Foreground", "Foreground"},
+	}
+	for _, tt := range tests {
+		api, client := newFakeAPI(t)
+		api.servePods("ns", testPod(j, "backup-1", "Succeeded"), testPod(j, "backup-2", "Running"))
+		var sent []string
+		api.handle("DELETE", "/apis/batch/v1/namespaces/ns/jobs/backup", func(w http.ResponseWriter, r *http.Request) {
+			var opts deleteOptions
+			if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+				t.Errorf("decoding delete options: %v", err)
+			}
+			sent = append(sent, opts.PropagationPolicy)
+			writeJSON(w, map[string]string{"status": "Success"})
+		})
+		cfg := testConfig()
+		cfg.deleteJobs = true
+		cfg.deleteJobsFirst = tt.jobsFirst
+		cfg.jobPropagation = tt.propagation
+		cfg.podPropagation = "Background"
+		sum := &runSummary{}
+		cleanupJob(client, newKubeJob(j, testNow), cfg, ioutil.Discard, sum)
+		if len(sent) != 1 || sent[0] != tt.want {
+			t.Errorf("%s: job deletes sent propagation %q, want %q", tt.name, sent, tt.want)
+		}
+		if api.count("DELETE", podsPath("ns")+"/backup-2") != 0 {
+			t.Errorf("%s: deleted the running pod", tt.name)
+		}
+	}
+}
+
+func TestNoPodScanListsNoPods(t *testing.T) {
+	api, client := newFakeAPI(t)
+	var jobs []kubeJob
+	var pods []*apiv1.Pod
+	for i := 0; i < 5; i++ {
+		j := completedJob("ns", fmt.Sprintf("job-%d", i), 5)
+		pods = append(pods, testPod(j, fmt.Sprintf("job-%d-a", i), "Succeeded"))
+		jobs = append(jobs, newKubeJob(j, testNow))
+	}
+	api.servePods("ns", pods...)
+	cfg := testConfig()
+	cfg.noPodScan = true
+	cfg.reportRestarts = true
+	sum := &runSummary{}
+	captureStdout(t)
+	cleanupJobs(client, jobs, cfg, sum, 1)
+	if n := api.count("GET", podsPath("ns")); n != 0 {
+		t.Errorf("%d pod lists with -no-pod-scan, want none", n)
+	}
+	if sum.JobsDeleted != 5 || sum.PodsDeleted != 0 {
+		t.Errorf("Counted %d jobs and %d pods, want 5 jobs only", sum.JobsDeleted, sum.PodsDeleted)
+	}
+}
+
+func TestDryRunOrphanPropagation(t *testing.T) {
+	j := completedJob("ns", "backup", 5)
+	for _, propagation := range []string{"Orphan", "Background", ""} {
+		api, client := newFakeAPI(t)
+		api.servePods("ns", testPod(j, "backup-1", "Succeeded"), testPod(j, "backup-2", "Running"), testPod(j, "backup-3", "Pending"))
+		cfg := testConfig()
+		cfg.jobPropagation = propagation
+		var out bytes.Buffer
+		sum := &runSummary{}
+		cleanupJob(client, newKubeJob(j, testNow), cfg, &out, sum)
+		want := 0
+		if propagation == "Orphan" {
+			want = 2
+			if !strings.Contains(out.String(), "\n\tWould orphan 2 pods of job backup with -job-propagation Orphan.\n") {
+				t.Errorf("Orphaned pods not reported, output: %q", out.String())
+			}
+		}
+		if sum.PodsOrphaned != want {
+			t.Errorf("-job-propagation %q: %d pods orphaned, want %d", propagation, sum.PodsOrphaned, want)
+		}
+		if api.count("DELETE", "/") != 0 {
+			t.Errorf("-job-propagation %q: dry-run deleted something", propagation)
+		}
+	}
+}
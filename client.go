@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/ericchiang/k8s"
+	"github.com/ghodss/yaml"
+)
+
+// Exit codes used when the client can't be set up, so wrappers can tell the
+// causes apart.
+const (
+	exitConfig       = 10
+	exitConnectivity = 11
+	exitAuth         = 12
+)
+
+// setupError is an error setting up the Kubernetes client, classified by
+// cause.
+type setupError struct {
+	code   int
+	reason string
+	err    error
+}
+
+func (e *setupError) Error() string {
+	return e.err.Error()
+}
+
+// exitSetupError prints err followed by a machine-readable reason line and
+// exits with the code matching its cause, or 1 if it isn't a setupError.
+func exitSetupError(err error) {
+	fmt.Fprintln(stdout, err.Error())
+	sErr, ok := err.(*setupError)
+	if !ok {
+		os.Exit(1)
+	}
+	fmt.Fprintf(stdout, "JOBLITERATOR_SETUP_ERROR reason=%s exit_code=%d\n", sErr.reason, sErr.code)
+	os.Exit(sErr.code)
+}
+
+// loadClient creates the client from the in-cluster config or the kubeconfig,
+// also returning the namespace set in the kubeconfig context if any.
+// Each request made by the client fails if the API server doesn't respond
+// within timeout, so a single hung connection can't stall the whole run. A
+// zero timeout disables this.
+func loadClient(kubeconfigPath, kubeContext string, inCluster bool, timeout time.Duration) (*k8s.Client, string, error) {
+	if inCluster {
+		client, err := k8s.NewInClusterClient()
+		if err != nil {
+			return nil, "", &setupError{exitConfig, "config", fmt.Errorf("Failed to create in-cluster client: %v", err)}
+		}
+		setResponseTimeout(client, timeout)
+		return client, "", nil
+	}
+	data, err := ioutil.ReadFile(kubeconfigPath)
+	if err != nil {
+		return nil, "", &setupError{exitConfig, "config", fmt.Errorf("Failed to read kubeconfig: %v", err)}
+	}
+
+	// Unmarshal YAML into a Kubernetes config object.
+	var config k8s.Config
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, "", &setupError{exitConfig, "config", fmt.Errorf("Failed to unmarshal kubeconfig: %v", err)}
+	}
+	if kubeContext != "" {
+		config.CurrentContext = kubeContext
+	}
+	client, err := k8s.NewClient(&config)
+	if err != nil {
+		return nil, "", &setupError{exitConfig, "config", fmt.Errorf("Failed to create client from kubeconfig: %v", err)}
+	}
+	setResponseTimeout(client, timeout)
+	namespace := ""
+	for _, c := range config.Contexts {
+		if c.Name == config.CurrentContext {
+			namespace = c.Context.Namespace
+		}
+	}
+	return client, namespace, nil
+}
+
+// setResponseTimeout makes the client's requests fail if no response headers
+// arrive within timeout. Unlike http.Client.Timeout, reading the response body
+// isn't limited, so a large list or a slow log download isn't cut off.
+func setResponseTimeout(client *k8s.Client, timeout time.Duration) {
+	if t, ok := client.Client.Transport.(*http.Transport); ok {
+		t.ResponseHeaderTimeout = timeout
+	}
+}
+
+// checkConnection makes a cheap request to the API server to tell an
+// unreachable cluster apart from rejected credentials before doing any work.
+// With impersonation, a 403 means the client isn't allowed to impersonate.
+func checkConnection(client *k8s.Client) error {
+	countAPICall(apiGet)
+	_, err := client.Discovery().Version(context.Background())
+	if err == nil {
+		return nil
+	}
+	if t, ok := client.Client.Transport.(*impersonatingTransport); ok && isForbidden(err) {
+		return &setupError{exitAuth, "impersonation", fmt.Errorf("Not allowed to impersonate user %q (groups %q), check the impersonate permissions of your own identity: %v", t.user, t.groups, err)}
+	}
+	if apiErr, ok := err.(*k8s.APIError); ok && (apiErr.Code == 401 || apiErr.Code == 403) {
+		return &setupError{exitAuth, "auth", fmt.Errorf("Failed to authenticate to the cluster: %v", err)}
+	}
+	return &setupError{exitConnectivity, "connectivity", fmt.Errorf("Failed to connect to the cluster: %v", err)}
+}
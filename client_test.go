@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeKubeconfig writes a kubeconfig with a context per namespace, all
+// pointing at server, and returns its path. An empty namespace leaves the
+// context's namespace unset.
+func writeKubeconfig(t *testing.T, server, current string, namespaces map[string]string) string {
+	t.Helper()
+	config := fmt.Sprintf("apiVersion: v1\nkind: Config\ncurrent-context: %s\nclusters:\n- name: test\n  cluster:\n    server: %s\nusers:\n- name: test\n  user: {}\ncontexts:\n", current, server)
+	for name, ns := range namespaces {
+		config += fmt.Sprintf("- name: %s\n  context:\n    cluster: test\n    user: test\n    namespace: %q\n", name, ns)
+	}
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	if err := ioutil.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestKubeHTTPTimeoutBoundsOnlyTheResponseHeaders(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow-headers", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("{}"))
+	})
+	mux.HandleFunc("/slow-body", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("{}"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	path := writeKubeconfig(t, srv.URL, "test", map[string]string{"test": ""})
+	client, _, err := loadClient(path, "", false, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(p string) error {
+		req, err := http.NewRequestWithContext(context.Background(), "GET", srv.URL+p, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		_, err = ioutil.ReadAll(resp.Body)
+		return err
+	}
+	if err := get("/slow-headers"); err == nil {
+		t.Error("Request whose headers are late succeeded, want a timeout")
+	}
+	if err := get("/slow-body"); err != nil {
+		t.Errorf("Request with a slow body failed: %v", err)
+	}
+}
+
+func TestLoadClientContextNamespace(t *testing.T) {
+	path := writeKubeconfig(t, "https://127.0.0.1:6443", "dev", map[string]string{"dev": "team-a", "prod": "", "staging": "team-b"})
+	tests := []struct {
+		context string
+		want    string
+	}{
+		{"", "team-a"},
+		{"staging", "team-b"},
+		{"prod", ""},
+	}
+	for _, tt := range tests {
+		_, namespace, err := loadClient(path, tt.context, false, 0)
+		if err != nil {
+			t.Fatalf("Context %q: %v", tt.context, err)
+		}
+		if namespace != tt.want {
+			t.Errorf("Context %q: namespace %q, want %q", tt.context, namespace, tt.want)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ericchiang/k8s"
+	batchv1 "github.com/ericchiang/k8s/apis/batch/v1"
+)
+
+// cronJob is the subset of a CronJob needed to decide whether it's stale. The
+// generated client has no CronJob support, so they are fetched directly.
+type cronJob struct {
+	Metadata struct {
+		Name              string    `json:"name"`
+		Namespace         string    `json:"namespace"`
+		UID               string    `json:"uid"`
+		ResourceVersion   string    `json:"resourceVersion"`
+		CreationTimestamp time.Time `json:"creationTimestamp"`
+	} `json:"metadata"`
+	Status struct {
+		LastSuccessfulTime *time.Time `json:"lastSuccessfulTime"`
+	} `json:"status"`
+}
+
+type cronJobList struct {
+	Items []cronJob `json:"items"`
+}
+
+// staleDays returns the number of days since the CronJob last produced a
+// successful job, or since it was created if it never did.
+func (cj *cronJob) staleDays(now time.Time) int {
+	since := cj.Metadata.CreationTimestamp
+	if cj.Status.LastSuccessfulTime != nil {
+		since = *cj.Status.LastSuccessfulTime
+	}
+	return int(now.Sub(since).Hours() / 24)
+}
+
+// cronJobVersion is the batch API version CronJobs are read from. It starts
+// out as v1, available since Kubernetes 1.21, and falls back to v1beta1, which
+// was removed in 1.25, when the server doesn't serve v1.
+var cronJobVersion = "v1"
+
+// cronJobsPath returns the API path of the CronJobs in the namespace.
+func cronJobsPath(namespace string) string {
+	if namespace == k8s.AllNamespaces {
+		return fmt.Sprintf("/apis/batch/%s/cronjobs", cronJobVersion)
+	}
+	return fmt.Sprintf("/apis/batch/%s/namespaces/%s/cronjobs", cronJobVersion, namespace)
+}
+
+// listCronJobs lists the CronJobs in the namespace, falling back to
+// batch/v1beta1 if batch/v1 isn't served. A cached version that is no longer
+// served is probed again.
+func listCronJobs(client *k8s.Client, namespace string) (*cronJobList, error) {
+	countAPICall(apiList)
+	body, err := rawRequest(context.Background(), client, "GET", cronJobsPath(namespace), nil)
+	if isNotFound(err) && discovery.cached {
+		debugf("Cached batch/%s CronJobs aren't served, discovering again\n", cronJobVersion)
+		discovery.invalidate()
+		cronJobVersion = "v1"
+		countAPICall(apiList)
+		body, err = rawRequest(context.Background(), client, "GET", cronJobsPath(namespace), nil)
+	}
+	if isNotFound(err) && cronJobVersion == "v1" {
+		debugf("batch/v1 CronJobs aren't served, using batch/v1beta1\n")
+		cronJobVersion = "v1beta1"
+		countAPICall(apiList)
+		body, err = rawRequest(context.Background(), client, "GET", cronJobsPath(namespace), nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	discovery.store(time.Now())
+	list := new(cronJobList)
+	if err := json.Unmarshal(body, list); err != nil {
+		return nil, fmt.Errorf("Unable to decode CronJobs: %v", err)
+	}
+	return list, nil
+}
+
+// cleanupStaleCronJobs lists the CronJobs that haven't produced a successful
+// job in at least days days and, if cfg.deleteJobs is set, deletes them. Their
+// jobs are orphaned rather than deleted, leaving them to the age based job
+// cleanup.
+func cleanupStaleCronJobs(client *k8s.Client, namespaces []string, days int, cfg *runConfig, sum *runSummary) {
+	fmt.Fprintln(stdout, "==============================")
+	fmt.Fprintln(stdout, "Searching for stale CronJobs...")
+	fmt.Fprintln(stdout, "==============================")
+	now := time.Now()
+	stale := 0
+	warned := false
+	for _, ns := range namespaces {
+		tracer.startNamespace("list cronjobs", ns)
+		list, err := listCronJobs(client, ns)
+		tracer.endNamespace()
+		if isForbidden(err) || isNotFound(err) {
+			// Not an error of the run: jobs are grouped by their CronJob
+			// owner references without reading any CronJobs, only this
+			// pass needs them.
+			if !warned {
+				fmt.Fprintf(stdout, "Unable to list CronJobs, skipping them in the stale CronJob pass. Error: %s\n", err.Error())
+				warned = true
+			}
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(stdout, "Unable to list CronJobs. Error: %s\n", err.Error())
+			sum.Errors++
+			continue
+		}
+		for _, cj := range list.Items {
+			age := cj.staleDays(now)
+			if age < days {
+				continue
+			}
+			stale++
+			if !cfg.deleteJobs {
+				fmt.Fprintf(stdout, "CronJob: %s\tNamespace: %s\tLast success:%vd\n", cj.Metadata.Name, cj.Metadata.Namespace, age)
+				sum.CronJobsDeleted++
+				sum.deleted(reasonStaleCronJob)
+				continue
+			}
+			fmt.Fprintf(stdout, "Deleting CronJob: %s\tNamespace: %s\tLast success:%vd\n", cj.Metadata.Name, cj.Metadata.Namespace, age)
+			opts := deleteOptions{
+				Kind:              "DeleteOptions",
+				APIVersion:        "v1",
+				Preconditions:     &preconditions{UID: cj.Metadata.UID, ResourceVersion: cj.Metadata.ResourceVersion},
+				PropagationPolicy: "Orphan",
+			}
+			path := cronJobsPath(cj.Metadata.Namespace) + "/" + cj.Metadata.Name
+			if err := deleteWithOptions(context.Background(), client, path, opts); err != nil {
+				fmt.Fprintf(stdout, "\tUnable to delete CronJob %s. Error: %s\n", cj.Metadata.Name, err.Error())
+				sum.Errors++
+				if cfg.stopped(sum) {
+					return
+				}
+				continue
+			}
+			sum.CronJobsDeleted++
+			sum.deleted(reasonStaleCronJob)
+		}
+	}
+	fmt.Fprintf(stdout, "Total stale CronJobs: %v\n", stale)
+}
+
+// cronJobScheduledAnnotation is set by the CronJob controller since Kubernetes
+// 1.28 to the time the job was scheduled for.
+const cronJobScheduledAnnotation = "batch.kubernetes.io/cronjob-scheduled-timestamp"
+
+// scheduledTime returns the time the CronJob owner scheduled the job for. It's
+// read from the annotation, or else from the job name, which the controller
+// suffixes with the scheduled time in minutes (in seconds before Kubernetes
+// 1.21). Jobs named otherwise, e.g. created by hand from the CronJob, fall back
+// to their creation time.
+func scheduledTime(j *batchv1.Job, owner string) time.Time {
+	if t, err := time.Parse(time.RFC3339, j.Metadata.GetAnnotations()[cronJobScheduledAnnotation]); err == nil {
+		return t
+	}
+	if suffix := strings.TrimPrefix(j.Metadata.GetName(), owner+"-"); suffix != j.Metadata.GetName() {
+		if n, err := strconv.ParseInt(suffix, 10, 64); err == nil && n > 0 {
+			// Minutes since the epoch won't reach 1e9 for another
+			// two thousand years.
+			if n < 1e9 {
+				n *= 60
+			}
+			return time.Unix(n, 0)
+		}
+	}
+	return time.Unix(j.Metadata.GetCreationTimestamp().GetSeconds(), 0)
+}
+
+// duplicateCronJobJobs finds CronJob misfires: jobs of the same CronJob that
+// were scheduled within window of the previous one. Jobs catching up on missed
+// schedules are created together but scheduled apart, so they aren't
+// duplicates. Each returned group holds at least two jobs, oldest first.
+func duplicateCronJobJobs(jobs []*batchv1.Job, window time.Duration) [][]*batchv1.Job {
+	byOwner := make(map[string][]*batchv1.Job)
+	var owners []string
+	for _, j := range jobs {
+		owner, ok := cronJobOwner(j)
+		if !ok {
+			continue
+		}
+		key := j.Metadata.GetNamespace() + "/" + owner
+		if _, ok := byOwner[key]; !ok {
+			owners = append(owners, key)
+		}
+		byOwner[key] = append(byOwner[key], j)
+	}
+	sort.Strings(owners)
+	var groups [][]*batchv1.Job
+	for _, key := range owners {
+		owned := byOwner[key]
+		_, owner := splitJobKey(key)
+		scheduled := make(map[*batchv1.Job]time.Time, len(owned))
+		for _, j := range owned {
+			scheduled[j] = scheduledTime(j, owner)
+		}
+		sort.Slice(owned, func(a, b int) bool {
+			if !scheduled[owned[a]].Equal(scheduled[owned[b]]) {
+				return scheduled[owned[a]].Before(scheduled[owned[b]])
+			}
+			return owned[a].Metadata.GetCreationTimestamp().GetSeconds() < owned[b].Metadata.GetCreationTimestamp().GetSeconds()
+		})
+		group := owned[:1]
+		for i := 1; i < len(owned); i++ {
+			if scheduled[owned[i]].Sub(scheduled[owned[i-1]]) <= window {
+				group = append(group, owned[i])
+				continue
+			}
+			if len(group) > 1 {
+				groups = append(groups, group)
+			}
+			group = owned[i : i+1]
+		}
+		if len(group) > 1 {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	batchv1 "github.com/ericchiang/k8s/apis/batch/v1"
+)
+
+// scheduledRun returns a job of the CronJob named like the controller does for
+// the scheduled time, created createdAgo before testNow.
+func scheduledRun(cronJob string, scheduled time.Time, createdAgo time.Duration) *batchv1.Job {
+	name := fmt.Sprintf("%s-%d", cronJob, scheduled.Unix()/60)
+	j := cronJobRun("ns", cronJob, name, 0)
+	j.Metadata.CreationTimestamp = metaTime(testNow.Add(-createdAgo))
+	return j
+}
+
+func TestScheduledTime(t *testing.T) {
+	at := testNow.Add(-time.Hour)
+	byAnnotation := cronJobRun("ns", "nightly", "nightly-manual", 2)
+	byAnnotation.Metadata.Annotations = map[string]string{cronJobScheduledAnnotation: at.Format(time.RFC3339)}
+	bySeconds := cronJobRun("ns", "nightly", fmt.Sprintf("nightly-%d", at.Unix()), 2)
+	adhoc := cronJobRun("ns", "nightly", "nightly-manual", 2)
+	tests := []struct {
+		name string
+		job  *batchv1.Job
+		want time.Time
+	}{
+		{"annotation", byAnnotation, at},
+		{"name in minutes", scheduledRun("nightly", at, 2*time.Hour), at},
+		{"name in seconds", bySeconds, at},
+		{"created by hand", adhoc, testNow.Add(-2 * time.Hour)},
+	}
+	for _, tt := range tests {
+		if got := scheduledTime(tt.job, "nightly"); !got.Equal(tt.want) {
+			t.Errorf("%s: scheduled at %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDuplicateCronJobJobsByScheduledTime(t *testing.T) {
+	at := testNow.Add(-10 * time.Hour)
+	// The controller was down and caught up on three hourly runs at once.
+	catchUp := []*batchv1.Job{
+		scheduledRun("hourly", at, time.Hour),
+		scheduledRun("hourly", at.Add(time.Hour), time.Hour),
+		scheduledRun("hourly", at.Add(2*time.Hour), time.Hour),
+	}
+	// A misfire ran the same schedule twice, a while apart.
+	first := scheduledRun("nightly", at, 9*time.Hour)
+	second := cronJobRun("ns", "nightly", "nightly-rerun", 5)
+	second.Metadata.Annotations = map[string]string{cronJobScheduledAnnotation: at.Format(time.RFC3339)}
+	jobs := append(catchUp, second, first)
+
+	groups := duplicateCronJobJobs(jobs, 5*time.Minute)
+	if len(groups) != 1 {
+		t.Fatalf("%d duplicate groups, want only the nightly misfire", len(groups))
+	}
+	if len(groups[0]) != 2 || groups[0][0] != first || groups[0][1] != second {
+		t.Errorf("Group %v, want the first run then the rerun", jobNames(groups[0]))
+	}
+}
+
+func jobNames(jobs []*batchv1.Job) []string {
+	var names []string
+	for _, j := range jobs {
+		names = append(names, j.Metadata.GetName())
+	}
+	return names
+}
+
+func TestCleanupStaleCronJobsForbidden(t *testing.T) {
+	out := captureStdout(t)
+	api, client := newFakeAPI(t)
+	for _, ns := range []string{"secret", "private"} {
+		api.handle("GET", "/apis/batch/v1/namespaces/"+ns+"/cronjobs", func(w http.ResponseWriter, r *http.Request) {
+			writeStatus(w, r, http.StatusForbidden, "forbidden")
+		})
+	}
+	api.handle("GET", "/apis/batch/v1/namespaces/ns/cronjobs", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{"items": []interface{}{
+			map[string]interface{}{"metadata": map[string]interface{}{"name": "abandoned", "namespace": "ns", "creationTimestamp": "2020-01-01T00:00:00Z"}},
+			map[string]interface{}{
+				"metadata": map[string]interface{}{"name": "healthy", "namespace": "ns", "creationTimestamp": "2020-01-01T00:00:00Z"},
+				"status":   map[string]interface{}{"lastSuccessfulTime": time.Now().UTC().Format(time.RFC3339)},
+			},
+		}})
+	})
+	sum := &runSummary{}
+	cleanupStaleCronJobs(client, []string{"secret", "ns", "private"}, 30, testConfig(), sum)
+	if sum.Errors != 0 {
+		t.Errorf("%d errors, want forbidden namespaces skipped", sum.Errors)
+	}
+	if sum.CronJobsDeleted != 1 {
+		t.Errorf("%d stale CronJobs, want the one in the readable namespace", sum.CronJobsDeleted)
+	}
+	if n := strings.Count(out.String(), "Unable to list CronJobs, skipping them"); n != 1 {
+		t.Errorf("Forbidden namespaces reported %d times, want once", n)
+	}
+	if cronJobVersion != "v1" {
+		t.Errorf("Fell back to batch/%s on a 403", cronJobVersion)
+	}
+}
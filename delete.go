@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ericchiang/k8s"
+	metav1 "github.com/ericchiang/k8s/apis/meta/v1"
+)
+
+// deleteOptions is the body of a DELETE request. The generated client methods
+// don't take any options, so requests needing them are made directly.
+type deleteOptions struct {
+	Kind          string         `json:"kind"`
+	APIVersion    string         `json:"apiVersion"`
+	Preconditions *preconditions `json:"preconditions,omitempty"`
+	// PropagationPolicy is one of "Orphan", "Background" or "Foreground".
+	PropagationPolicy string `json:"propagationPolicy,omitempty"`
+	// GracePeriodSeconds overrides the pod's termination grace period.
+	GracePeriodSeconds *int `json:"gracePeriodSeconds,omitempty"`
+}
+
+// preconditions make a delete fail with a 409 Conflict unless the object
+// still has the given UID and resourceVersion.
+type preconditions struct {
+	UID             string `json:"uid,omitempty"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+// changed reports whether the object differs from the one that was selected
+// as dj.
+func changed(dj kubeJob, meta *metav1.ObjectMeta) bool {
+	return (dj.uid != "" && meta.GetUid() != dj.uid) ||
+		(dj.resourceVersion != "" && meta.GetResourceVersion() != dj.resourceVersion)
+}
+
+// isConflict reports whether err is a 409 response from the API server, as
+// returned when a delete precondition doesn't hold.
+func isConflict(err error) bool {
+	apiErr, ok := err.(*k8s.APIError)
+	return ok && apiErr.Code == 409
+}
+
+// deleteJob deletes the job, but only if it's still the same object that was
+// selected for deletion, with the propagation policy given. An empty
+// propagation uses the server's default.
+func deleteJob(client *k8s.Client, dj kubeJob, propagation string) error {
+	opts := deleteOptions{Kind: "DeleteOptions", APIVersion: "v1", PropagationPolicy: propagation}
+	if dj.uid != "" || dj.resourceVersion != "" {
+		opts.Preconditions = &preconditions{UID: dj.uid, ResourceVersion: dj.resourceVersion}
+	}
+	path := fmt.Sprintf("/apis/batch/v1/namespaces/%s/jobs/%s", dj.namespace, dj.name)
+	return deleteWithOptions(context.Background(), client, path, opts)
+}
+
+// deletePod deletes the pod. With cfg.forceTerminate, a pod still there after
+// cfg.forceTerminateAfter, typically stuck terminating on a node that is gone,
+// is deleted again without a grace period.
+func deletePod(client *k8s.Client, kp kubePod, cfg *runConfig, w io.Writer) error {
+	opts := deleteOptions{Kind: "DeleteOptions", APIVersion: "v1", PropagationPolicy: cfg.podPropagation}
+	if err := deleteWithOptions(context.Background(), client, podPath(kp), opts); err != nil {
+		return err
+	}
+	if !cfg.forceTerminate {
+		return nil
+	}
+	deadline := time.Now().Add(cfg.forceTerminateAfter)
+	for {
+		countAPICall(apiGet)
+		_, err := client.CoreV1().GetPod(context.Background(), kp.name, kp.namespace)
+		if isNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !time.Now().Before(deadline) {
+			break
+		}
+		time.Sleep(time.Second)
+	}
+	fmt.Fprintf(w, "\tPod %s still terminating after %v, deleting it without grace period.\n", kp.name, cfg.forceTerminateAfter)
+	opts = deleteOptions{Kind: "DeleteOptions", APIVersion: "v1", GracePeriodSeconds: k8s.Int(0)}
+	err := deleteWithOptions(context.Background(), client, podPath(kp), opts)
+	if isNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// podPath is the API path of the pod.
+func podPath(kp kubePod) string {
+	return fmt.Sprintf("/api/v1/namespaces/%s/pods/%s", kp.namespace, kp.name)
+}
+
+// validPropagation reports whether policy is a deletion propagation policy.
+func validPropagation(policy string) bool {
+	return policy == "Background" || policy == "Foreground" || policy == "Orphan"
+}
+
+// deleteWithOptions sends a DELETE request for the API path with opts as body.
+func deleteWithOptions(ctx context.Context, client *k8s.Client, path string, opts deleteOptions) error {
+	deleteLimiter.wait()
+	countAPICall(apiDelete)
+	_, err := rawRequest(ctx, client, "DELETE", path, opts)
+	return err
+}
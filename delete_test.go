@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// preconditionedDelete serves DELETEs of the job at path that fail with a 409
+// unless the preconditions match the uid and resourceVersion.
+func preconditionedDelete(t *testing.T, api *fakeAPI, path, uid, resourceVersion string) {
+	api.handle("DELETE", path, func(w http.ResponseWriter, r *http.Request) {
+		var opts deleteOptions
+		if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+			t.Errorf("decoding delete options: %v", err)
+		}
+		if opts.Preconditions == nil {
+			t.Error("delete sent without preconditions")
+			writeStatus(w, r, http.StatusBadRequest, "no preconditions")
+			return
+		}
+		if opts.Preconditions.UID != uid || opts.Preconditions.ResourceVersion != resourceVersion {
+			writeStatus(w, r, http.StatusConflict, "precondition failed")
+			return
+		}
+		writeJSON(w, map[string]string{"status": "Success"})
+	})
+}
+
+func TestDeleteJobChangedResourceVersion(t *testing.T) {
+	j := completedJob("ns", "backup", 5)
+	api, client := newFakeAPI(t)
+	path := "/apis/batch/v1/namespaces/ns/jobs/backup"
+	preconditionedDelete(t, api, path, j.Metadata.GetUid(), "2")
+
+	dj := newKubeJob(j, testNow)
+	if err := deleteJob(client, dj, "Background"); !isConflict(err) {
+		t.Fatalf("deleting a changed job: err = %v, want a conflict", err)
+	}
+	dj.resourceVersion = "2"
+	if err := deleteJob(client, dj, "Background"); err != nil {
+		t.Fatalf("deleting an unchanged job: %v", err)
+	}
+}
+
+func TestCleanupJobChangedSkipped(t *testing.T) {
+	j := completedJob("ns", "backup", 5)
+	api, client := newFakeAPI(t)
+	api.servePods("ns", testPod(j, "backup-1", "Succeeded"))
+	preconditionedDelete(t, api, "/apis/batch/v1/namespaces/ns/jobs/backup", j.Metadata.GetUid(), "2")
+	cfg := testConfig()
+	cfg.deleteJobs = true
+	sum := &runSummary{}
+	cleanupJob(client, newKubeJob(j, testNow), cfg, ioutil.Discard, sum)
+	if sum.JobsDeleted != 0 || sum.Skipped["changed"] != 1 || sum.Errors != 0 {
+		t.Errorf("deleted %d jobs, skipped %v, %d errors, want the job skipped as changed", sum.JobsDeleted, sum.Skipped, sum.Errors)
+	}
+}
+
+func TestDeletePodForceTerminate(t *testing.T) {
+	j := completedJob("ns", "backup", 5)
+	pod := testPod(j, "backup-1", "Running")
+	path := "/api/v1/namespaces/ns/pods/backup-1"
+	for _, stuck := range []bool{true, false} {
+		api, client := newFakeAPI(t)
+		var graceDeletes []*int
+		api.handle("DELETE", path, func(w http.ResponseWriter, r *http.Request) {
+			var opts deleteOptions
+			if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+				t.Errorf("decoding delete options: %v", err)
+			}
+			graceDeletes = append(graceDeletes, opts.GracePeriodSeconds)
+			writeJSON(w, map[string]string{"status": "Success"})
+		})
+		api.handle("GET", path, func(w http.ResponseWriter, r *http.Request) {
+			// A stuck pod keeps its deletion timestamp forever.
+			if stuck {
+				writeObject(w, r, pod)
+				return
+			}
+			writeStatus(w, r, http.StatusNotFound, "not found")
+		})
+		cfg := testConfig()
+		cfg.forceTerminate = true
+		var out bytes.Buffer
+		if err := deletePod(client, newKubePod(pod), cfg, &out); err != nil {
+			t.Fatalf("stuck %v: %v", stuck, err)
+		}
+		if !stuck {
+			if len(graceDeletes) != 1 {
+				t.Errorf("%d deletes of a pod that terminated, want 1", len(graceDeletes))
+			}
+			continue
+		}
+		if len(graceDeletes) != 2 || graceDeletes[0] != nil || graceDeletes[1] == nil || *graceDeletes[1] != 0 {
+			t.Fatalf("Deletes of a stuck pod sent grace periods %v, want the default then 0", graceDeletes)
+		}
+		if !strings.Contains(out.String(), "still terminating") {
+			t.Errorf("Force termination not reported, output: %q", out.String())
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// discovery caches the API versions found by probing the API server between
+// runs, see "-discovery-cache-ttl". The zero value doesn't cache.
+var discovery discoveryCache
+
+// discoveryCache keeps the discovered API versions of each API server in a file
+// for ttl.
+type discoveryCache struct {
+	path     string
+	endpoint string
+	ttl      time.Duration
+	// cached is set while cronJobVersion comes from the cache rather than
+	// from a probe in this run.
+	cached bool
+}
+
+// discoveryEntry is what is cached for one API server.
+type discoveryEntry struct {
+	CronJobVersion string    `json:"cronjob_version"`
+	DiscoveredAt   time.Time `json:"discovered_at"`
+}
+
+// discoveryCachePath is the default location of the cache file, in the user's
+// cache directory rather than a shared temporary directory where anyone could
+// create it first. It's empty if the user has no cache directory.
+func discoveryCachePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "jobliterator", "discovery.json")
+}
+
+// read returns the cached entries by API server. A missing or unreadable
+// cache is empty.
+func (d *discoveryCache) read() map[string]discoveryEntry {
+	entries := make(map[string]discoveryEntry)
+	data, err := ioutil.ReadFile(d.path)
+	if err != nil {
+		return entries
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		debugf("Ignoring unreadable discovery cache %s: %v\n", d.path, err)
+	}
+	return entries
+}
+
+// load sets cronJobVersion from the cache if the entry is younger than ttl.
+func (d *discoveryCache) load(now time.Time) {
+	if d.ttl <= 0 || d.path == "" {
+		return
+	}
+	e, ok := d.read()[d.endpoint]
+	if !ok || e.CronJobVersion == "" || now.Sub(e.DiscoveredAt) >= d.ttl {
+		return
+	}
+	debugf("Using cached batch/%s CronJobs, discovered at %s\n", e.CronJobVersion, e.DiscoveredAt.Format(time.RFC3339))
+	cronJobVersion = e.CronJobVersion
+	d.cached = true
+}
+
+// store caches cronJobVersion after it was probed. Failing to write the cache
+// only costs a probe on the next run.
+func (d *discoveryCache) store(now time.Time) {
+	if d.ttl <= 0 || d.cached || d.path == "" {
+		return
+	}
+	entries := d.read()
+	entries[d.endpoint] = discoveryEntry{CronJobVersion: cronJobVersion, DiscoveredAt: now}
+	if err := d.write(entries); err != nil {
+		debugf("Unable to write discovery cache %s: %v\n", d.path, err)
+		return
+	}
+	d.cached = true
+}
+
+// write replaces the cache file with entries. The file is only replaced once
+// it's complete, so concurrent runs never read a partial cache.
+func (d *discoveryCache) write(entries map[string]discoveryEntry) error {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(d.path), 0700); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(d.path), ".discovery-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), d.path)
+}
+
+// invalidate forgets the cached versions, e.g. after the cluster stopped
+// serving one of them.
+func (d *discoveryCache) invalidate() {
+	if !d.cached {
+		return
+	}
+	d.cached = false
+	entries := d.read()
+	delete(entries, d.endpoint)
+	if err := d.write(entries); err != nil {
+		debugf("Unable to write discovery cache %s: %v\n", d.path, err)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDiscoveryCachedWithinTTL(t *testing.T) {
+	api, client := newFakeAPI(t)
+	// An old cluster only serving batch/v1beta1 CronJobs.
+	api.handle("GET", "/apis/batch/v1beta1/namespaces/ns/cronjobs", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{"items": []interface{}{}})
+	})
+	path := filepath.Join(t.TempDir(), "cache", "discovery.json")
+	defer func() {
+		cronJobVersion = "v1"
+		discovery = discoveryCache{}
+	}()
+	// listCronJobs records the discovery at the current time.
+	start := time.Now()
+	for run, at := range []time.Time{start, start.Add(30 * time.Minute), start.Add(2 * time.Hour)} {
+		// Each run starts out like a new process.
+		cronJobVersion = "v1"
+		discovery = discoveryCache{path: path, endpoint: client.Endpoint, ttl: time.Hour}
+		discovery.load(at)
+		if _, err := listCronJobs(client, "ns"); err != nil {
+			t.Fatalf("Run %d: %v", run, err)
+		}
+	}
+	// Probed by the first run and again once the entry expired, but not by
+	// the run within the TTL.
+	if n := api.count("GET", "/apis/batch/v1/"); n != 2 {
+		t.Errorf("batch/v1 probed %d times, want 2", n)
+	}
+	if n := api.count("GET", "/apis/batch/v1beta1/"); n != 3 {
+		t.Errorf("batch/v1beta1 listed %d times, want 3", n)
+	}
+
+	fi, err := os.Stat(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0700 {
+		t.Errorf("Cache directory created with mode %v, want 0700", fi.Mode().Perm())
+	}
+	leftovers, _ := filepath.Glob(filepath.Join(filepath.Dir(path), ".discovery-*"))
+	if len(leftovers) != 0 {
+		t.Errorf("Temporary files left behind: %v", leftovers)
+	}
+}
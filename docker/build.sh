@@ -2,6 +2,8 @@
 
 if [ -z ${1+x} ]; then echo "Usage: ./build.sh \$tag"; exit; fi
 
-CGO_ENABLED=0 GOOS=linux go build -a -installsuffix cgo -o jobliterator ../.
+CGO_ENABLED=0 GOOS=linux go build -a -installsuffix cgo \
+  -ldflags "-X main.version=$1 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%FT%TZ)" \
+  -o jobliterator ../.
 
 sudo docker build -t jobliterator:$1 .
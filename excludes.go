@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// annotationExclude matches jobs whose annotation key has a value matching
+// pattern as a whole.
+type annotationExclude struct {
+	key     string
+	pattern string
+	value   *regexp.Regexp
+}
+
+// annotationExcludes is the repeatable "-exclude-annotation KEY=REGEX" flag.
+// Regular expressions may contain commas, so unlike most list flags it's
+// repeated rather than comma-separated.
+type annotationExcludes []annotationExclude
+
+func (e *annotationExcludes) String() string {
+	var parts []string
+	for _, ex := range *e {
+		parts = append(parts, ex.key+"="+ex.pattern)
+	}
+	return strings.Join(parts, " ")
+}
+
+func (e *annotationExcludes) Set(val string) error {
+	eq := strings.Index(val, "=")
+	if eq <= 0 {
+		return fmt.Errorf("expected KEY=REGEX, got %q", val)
+	}
+	// Anchored so that e.g. "keep-.*" has to match the whole value.
+	re, err := regexp.Compile("^(?:" + val[eq+1:] + ")$")
+	if err != nil {
+		return fmt.Errorf("invalid regular expression for %s: %v", val[:eq], err)
+	}
+	*e = append(*e, annotationExclude{key: val[:eq], pattern: val[eq+1:], value: re})
+	return nil
+}
+
+// excluded reports whether the annotations match all of the excludes. A job
+// without one of the annotations doesn't match, and no excludes match nothing.
+func (e annotationExcludes) excluded(annotations map[string]string) bool {
+	if len(e) == 0 {
+		return false
+	}
+	for _, ex := range e {
+		val, ok := annotations[ex.key]
+		if !ok {
+			return false
+		}
+		if !ex.value.MatchString(val) {
+			return false
+		}
+	}
+	return true
+}
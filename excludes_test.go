@@ -0,0 +1,55 @@
+package main
+
+import "testing"
+
+func TestAnnotationExcludes(t *testing.T) {
+	var e annotationExcludes
+	for _, val := range []string{"example.com/owner=team-(a|b)", "example.com/keep=true,yes"} {
+		if err := e.Set(val); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		excluded    bool
+	}{
+		{"both match", map[string]string{"example.com/owner": "team-a", "example.com/keep": "true,yes"}, true},
+		{"one matches", map[string]string{"example.com/owner": "team-b"}, false},
+		// The pattern has to match the whole value.
+		{"partial match", map[string]string{"example.com/owner": "team-abc", "example.com/keep": "true,yes"}, false},
+		{"other value", map[string]string{"example.com/owner": "team-c", "example.com/keep": "true,yes"}, false},
+		{"no annotations", nil, false},
+	}
+	for _, tt := range tests {
+		if got := e.excluded(tt.annotations); got != tt.excluded {
+			t.Errorf("%s: excluded = %v, want %v", tt.name, got, tt.excluded)
+		}
+	}
+	if annotationExcludes(nil).excluded(map[string]string{"example.com/owner": "team-a"}) {
+		t.Error("Excluded without any -exclude-annotation")
+	}
+}
+
+func TestAnnotationExcludesInvalid(t *testing.T) {
+	for _, val := range []string{"no-regex", "=value", "key=(unclosed"} {
+		var e annotationExcludes
+		if err := e.Set(val); err == nil {
+			t.Errorf("%q accepted", val)
+		}
+	}
+}
+
+func TestExcludedJobsNotEligible(t *testing.T) {
+	cfg := testConfig()
+	cfg.excludeAnnotations.Set("example.com/keep=true")
+	j := completedJob("ns", "kept", 5)
+	j.Metadata.Annotations = map[string]string{"example.com/keep": "true"}
+	if _, ok := eligibleJob(j, testNow, cfg, false); ok {
+		t.Error("Excluded job eligible")
+	}
+	j.Metadata.Annotations["example.com/keep"] = "false"
+	if _, ok := eligibleJob(j, testNow, cfg, false); !ok {
+		t.Error("Job not matching the exclusion isn't eligible")
+	}
+}
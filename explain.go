@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ericchiang/k8s"
+	apiv1 "github.com/ericchiang/k8s/api/v1"
+	batchv1 "github.com/ericchiang/k8s/apis/batch/v1"
+	metav1 "github.com/ericchiang/k8s/apis/meta/v1"
+)
+
+// explanation collects the checks made while deciding whether a job is
+// eligible for deletion, for the "-explain" output. All methods are no-ops
+// when it's disabled.
+type explanation struct {
+	enabled bool
+	job     string
+	steps   []string
+}
+
+func newExplanation(enabled bool, j *batchv1.Job) *explanation {
+	return &explanation{enabled: enabled, job: j.Metadata.GetNamespace() + "/" + j.Metadata.GetName()}
+}
+
+// check records a yes/no question and its answer.
+func (e *explanation) check(question string, answer bool) {
+	if answer {
+		e.note(question + " yes")
+	} else {
+		e.note(question + " no")
+	}
+}
+
+// note records a free-form step.
+func (e *explanation) note(step string) {
+	if !e.enabled {
+		return
+	}
+	e.steps = append(e.steps, step)
+}
+
+// verdict prints the decision trace for the job and passes kj and eligible
+// through, so it can wrap eligibleJob's return values.
+func (e *explanation) verdict(kj kubeJob, eligible bool) (kubeJob, bool) {
+	if e.enabled {
+		result := "SKIPPED"
+		if eligible {
+			result = "ELIGIBLE"
+		}
+		fmt.Fprintf(stdout, "Explain %s: %s → %s\n", e.job, strings.Join(e.steps, "; "), result)
+	}
+	return kj, eligible
+}
+
+// explainOrphan prints the job labels and the ownerReference chain of an
+// orphan candidate for "-explain-orphans", with which of the referenced
+// objects could be found. Owner references are followed from Jobs to their
+// CronJobs, other kinds are listed but not looked up.
+func explainOrphan(client *k8s.Client, p *apiv1.Pod, jobLabels []string) {
+	ns := p.Metadata.GetNamespace()
+	var steps []string
+	for _, key := range jobLabels {
+		if val, ok := p.Metadata.GetLabels()[key]; ok {
+			steps = append(steps, fmt.Sprintf("label %s=%s", key, val))
+		}
+	}
+	refs := p.Metadata.GetOwnerReferences()
+	if len(refs) == 0 {
+		steps = append(steps, "no ownerReferences")
+	}
+	for _, ref := range refs {
+		steps = append(steps, ownerChain(client, ns, ref)...)
+	}
+	fmt.Fprintf(stdout, "Explain orphan %s/%s: %s\n", ns, p.Metadata.GetName(), strings.Join(steps, "; "))
+}
+
+// ownerChain describes the owner reference and, for a found Job, the owners
+// of that job.
+func ownerChain(client *k8s.Client, namespace string, ref *metav1.OwnerReference) []string {
+	link := fmt.Sprintf("%s %s (uid %s)", ref.GetKind(), ref.GetName(), ref.GetUid())
+	switch ref.GetKind() {
+	case "Job":
+		countAPICall(apiGet)
+		j, err := client.BatchV1().GetJob(context.Background(), ref.GetName(), namespace)
+		if err != nil {
+			return []string{link + " " + lookupResult(err)}
+		}
+		if j.Metadata.GetUid() != ref.GetUid() {
+			return []string{fmt.Sprintf("%s replaced by uid %s", link, j.Metadata.GetUid())}
+		}
+		chain := []string{link + " found"}
+		for _, jobRef := range j.Metadata.GetOwnerReferences() {
+			chain = append(chain, "→ "+strings.Join(ownerChain(client, namespace, jobRef), " "))
+		}
+		return chain
+	case "CronJob":
+		countAPICall(apiGet)
+		_, err := rawRequest(context.Background(), client, "GET", cronJobsPath(namespace)+"/"+ref.GetName(), nil)
+		if err != nil {
+			return []string{link + " " + lookupResult(err)}
+		}
+		return []string{link + " found"}
+	}
+	return []string{link + " not checked"}
+}
+
+// lookupResult describes a failed owner lookup.
+func lookupResult(err error) string {
+	if isNotFound(err) {
+		return "missing"
+	}
+	return fmt.Sprintf("unknown (%v)", err)
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ericchiang/k8s"
+	"github.com/ericchiang/k8s/api/unversioned"
+	apiv1 "github.com/ericchiang/k8s/api/v1"
+	batchv1 "github.com/ericchiang/k8s/apis/batch/v1"
+	metav1 "github.com/ericchiang/k8s/apis/meta/v1"
+	"github.com/ericchiang/k8s/runtime"
+	"github.com/golang/protobuf/proto"
+)
+
+// fakeAPI is a minimal Kubernetes API server for tests. Handlers are looked up
+// by method and path, and every request is recorded.
+type fakeAPI struct {
+	mu       sync.Mutex
+	handlers map[string]http.HandlerFunc
+	requests []*http.Request
+}
+
+// newFakeAPI starts a fake API server and returns a client talking to it.
+func newFakeAPI(t testing.TB) (*fakeAPI, *k8s.Client) {
+	f := &fakeAPI{handlers: make(map[string]http.HandlerFunc)}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, &k8s.Client{Endpoint: srv.URL, Client: srv.Client()}
+}
+
+// handle registers the handler for requests with the method and path.
+func (f *fakeAPI) handle(method, path string, h http.HandlerFunc) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.handlers[method+" "+path] = h
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.requests = append(f.requests, r)
+	h, ok := f.handlers[r.Method+" "+r.URL.Path]
+	f.mu.Unlock()
+	if !ok {
+		writeStatus(w, r, http.StatusNotFound, "not found")
+		return
+	}
+	h(w, r)
+}
+
+// count returns the number of requests made with the method whose path starts
+// with prefix.
+func (f *fakeAPI) count(method, prefix string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, r := range f.requests {
+		if r.Method == method && strings.HasPrefix(r.URL.Path, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+// writeObject writes obj in the encoding the request accepts.
+func writeObject(w http.ResponseWriter, r *http.Request, obj proto.Message) {
+	if !strings.Contains(r.Header.Get("Accept"), protobufContentType) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(obj)
+		return
+	}
+	raw, err := proto.Marshal(obj)
+	if err != nil {
+		panic(err)
+	}
+	body, err := (&runtime.Unknown{Raw: raw}).Marshal()
+	if err != nil {
+		panic(err)
+	}
+	w.Header().Set("Content-Type", protobufContentType)
+	w.Write(append(append([]byte{}, protobufMagic...), body...))
+}
+
+// readObject decodes the protobuf encoded object the client sent with r.
+func readObject(t testing.TB, r *http.Request, obj proto.Message) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err := decodeProtobuf(body, obj); err != nil {
+		t.Error(err)
+	}
+}
+
+// writeJSON writes v as JSON, for the objects read with rawRequest.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeStatus writes an error status with the HTTP status code.
+func writeStatus(w http.ResponseWriter, r *http.Request, code int, message string) {
+	status := &unversioned.Status{Status: k8s.String("Failure"), Message: k8s.String(message)}
+	if strings.Contains(r.Header.Get("Accept"), protobufContentType) {
+		raw, _ := proto.Marshal(status)
+		body, _ := (&runtime.Unknown{Raw: raw}).Marshal()
+		w.Header().Set("Content-Type", protobufContentType)
+		w.WriteHeader(code)
+		w.Write(append(append([]byte{}, protobufMagic...), body...))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
+
+// apiError returns the *k8s.APIError the client returns for the HTTP status
+// code.
+func apiError(code int) error {
+	return &k8s.APIError{Status: &unversioned.Status{Message: k8s.String("test")}, Code: code}
+}
+
+// serveJobs serves GETs and DELETEs of the jobs by name. A deleted job is
+// gone for later GETs.
+func (f *fakeAPI) serveJobs(jobs ...*batchv1.Job) {
+	for _, j := range jobs {
+		j := j
+		path := "/apis/batch/v1/namespaces/" + j.Metadata.GetNamespace() + "/jobs/" + j.Metadata.GetName()
+		deleted := false
+		f.handle("GET", path, func(w http.ResponseWriter, r *http.Request) {
+			f.mu.Lock()
+			gone := deleted
+			f.mu.Unlock()
+			if gone {
+				writeStatus(w, r, http.StatusNotFound, "not found")
+				return
+			}
+			writeObject(w, r, j)
+		})
+		f.handle("DELETE", path, func(w http.ResponseWriter, r *http.Request) {
+			f.mu.Lock()
+			deleted = true
+			f.mu.Unlock()
+			writeJSON(w, &unversioned.Status{Status: k8s.String("Success")})
+		})
+	}
+}
+
+// servePods serves lists of the pods in their namespace, filtered by label
+// selectors of "key=value" requirements, and DELETEs of each pod.
+func (f *fakeAPI) servePods(namespace string, pods ...*apiv1.Pod) {
+	f.handle("GET", podsPath(namespace), func(w http.ResponseWriter, r *http.Request) {
+		list := &apiv1.PodList{Metadata: &metav1.ListMeta{}}
+		for _, p := range pods {
+			if matchesSelector(p.Metadata.GetLabels(), r.URL.Query().Get("labelSelector")) {
+				list.Items = append(list.Items, p)
+			}
+		}
+		writeObject(w, r, list)
+	})
+	for _, p := range pods {
+		f.handle("DELETE", podsPath(namespace)+"/"+p.Metadata.GetName(), func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, &unversioned.Status{Status: k8s.String("Success")})
+		})
+	}
+}
+
+// matchesSelector reports whether the labels match every "key=value"
+// requirement of the selector.
+func matchesSelector(labels map[string]string, selector string) bool {
+	for _, req := range splitSelector(selector) {
+		kv := strings.SplitN(strings.TrimSpace(req), "=", 2)
+		if len(kv) != 2 || labels[kv[0]] != kv[1] {
+			return false
+		}
+	}
+	return true
+}
+
+// testPod returns a pod of the job in the phase, labelled and owned like the
+// job controller does.
+func testPod(j *batchv1.Job, name, phase string) *apiv1.Pod {
+	return &apiv1.Pod{
+		Metadata: &metav1.ObjectMeta{
+			Name:      k8s.String(name),
+			Namespace: k8s.String(j.Metadata.GetNamespace()),
+			Labels:    map[string]string{"job-name": j.Metadata.GetName()},
+			OwnerReferences: []*metav1.OwnerReference{{
+				Kind: k8s.String("Job"),
+				Name: k8s.String(j.Metadata.GetName()),
+				Uid:  k8s.String(j.Metadata.GetUid()),
+			}},
+			CreationTimestamp: j.Metadata.GetCreationTimestamp(),
+		},
+		Spec:   &apiv1.PodSpec{},
+		Status: &apiv1.PodStatus{Phase: k8s.String(phase), StartTime: j.Metadata.GetCreationTimestamp()},
+	}
+}
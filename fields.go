@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// runID identifies this run in the summary line, audit log, webhook and
+// metrics and, with "-log-fields", on every output line. It's generated unless
+// given with "-run-id".
+var runID = newRunID()
+
+// stdout is where all output is written. With "-log-fields" it's replaced by
+// runWriter, so every line is a logfmt record carrying the run ID.
+var stdout io.Writer = os.Stdout
+
+// validRunID matches the "-run-id" values that are safe in logfmt fields and
+// metric labels.
+var validRunID = regexp.MustCompile(`^[A-Za-z0-9._:/-]{1,128}$`)
+
+// newRunID returns a random version 4 UUID.
+func newRunID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// scopeFields are the fields scopedWriter adds to a line, in order.
+var scopeFields = []string{"namespace", "job"}
+
+// fieldWriter turns every line written to it into a logfmt record, e.g.
+// run_id=... namespace=ns job=backup msg="Deleting job: backup". Fields added
+// by scopedWriter at the start of the line are kept as fields, the rest of the
+// line is quoted as msg. A line is written once it's complete, or on flush.
+// It's safe for concurrent use.
+type fieldWriter struct {
+	w      io.Writer
+	prefix []byte
+	mu     sync.Mutex
+	// pending is the start of a line that isn't complete yet.
+	pending []byte
+}
+
+func (fw *fieldWriter) Write(p []byte) (int, error) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	var buf bytes.Buffer
+	for _, line := range bytes.SplitAfter(p, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		fw.pending = append(fw.pending, line...)
+		if line[len(line)-1] == '\n' {
+			fw.writeRecord(&buf)
+		}
+	}
+	if _, err := fw.w.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// flush writes the pending part of a line as a record, e.g. a prompt waiting
+// for an answer.
+func (fw *fieldWriter) flush() {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if len(fw.pending) == 0 {
+		return
+	}
+	var buf bytes.Buffer
+	fw.writeRecord(&buf)
+	fw.w.Write(buf.Bytes())
+}
+
+// writeRecord writes the pending line to buf as a record and resets it.
+func (fw *fieldWriter) writeRecord(buf *bytes.Buffer) {
+	line := strings.TrimSuffix(string(fw.pending), "\n")
+	fw.pending = fw.pending[:0]
+	buf.Write(fw.prefix)
+	for _, field := range scopeFields {
+		if !strings.HasPrefix(line, field+"=") {
+			continue
+		}
+		end := strings.IndexByte(line, ' ')
+		if end < 0 {
+			break
+		}
+		buf.WriteString(line[:end+1])
+		line = line[end+1:]
+	}
+	fmt.Fprintf(buf, "msg=%s\n", strconv.Quote(line))
+}
+
+// flushOutput writes any incomplete line held back by "-log-fields".
+func flushOutput() {
+	if fw, ok := stdout.(*fieldWriter); ok {
+		fw.flush()
+	}
+}
+
+// runWriter returns w with each line written as a record carrying the run ID.
+func runWriter(w io.Writer) io.Writer {
+	return &fieldWriter{w: w, prefix: []byte("run_id=" + runID + " ")}
+}
+
+// scopedWriter returns w with the namespace and job fields added to each line
+// if cfg.logFields is set. The line becomes a record when it reaches stdout,
+// possibly after being buffered by a concurrent worker.
+func (c *runConfig) scopedWriter(w io.Writer, namespace, job string) io.Writer {
+	if !c.logFields {
+		return w
+	}
+	return &scopeWriter{w: w, prefix: []byte(fmt.Sprintf("namespace=%s job=%s ", namespace, job))}
+}
+
+// scopeWriter prefixes every line written to it, see scopedWriter. Each job
+// gets its own, so it isn't safe for concurrent use.
+type scopeWriter struct {
+	w      io.Writer
+	prefix []byte
+	// midLine is set while the last write didn't end with a newline.
+	midLine bool
+}
+
+func (sw *scopeWriter) Write(p []byte) (int, error) {
+	var buf bytes.Buffer
+	for _, line := range bytes.SplitAfter(p, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		if !sw.midLine {
+			buf.Write(sw.prefix)
+		}
+		buf.Write(line)
+		sw.midLine = line[len(line)-1] != '\n'
+	}
+	if _, err := sw.w.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
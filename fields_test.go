@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestLogFieldsOnEveryLine(t *testing.T) {
+	defer func(id string) { runID = id }(runID)
+	runID = "run-1"
+	var out bytes.Buffer
+	w := runWriter(&out)
+	cfg := &runConfig{logFields: true}
+	fmt.Fprintf(w, "Listing jobs\n")
+	jw := cfg.scopedWriter(w, "ns", "backup")
+	fmt.Fprintf(jw, "Deleting job: backup\n\tPod: backup-1")
+	fmt.Fprintf(jw, "\tPhase: Succeeded\n")
+	fmt.Fprintf(w, "Job %q: done\n", "backup")
+	fmt.Fprintf(w, "Total jobs: 1\n")
+	want := "run_id=run-1 msg=\"Listing jobs\"\n" +
+		"run_id=run-1 namespace=ns job=backup msg=\"Deleting job: backup\"\n" +
+		"run_id=run-1 namespace=ns job=backup msg=\"\\tPod: backup-1\\tPhase: Succeeded\"\n" +
+		"run_id=run-1 msg=\"Job \\\"backup\\\": done\"\n" +
+		"run_id=run-1 msg=\"Total jobs: 1\"\n"
+	if out.String() != want {
+		t.Errorf("output:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestLogFieldsBufferedScope(t *testing.T) {
+	defer func(id string) { runID = id }(runID)
+	runID = "run-1"
+	// Concurrent workers write scoped lines to a buffer copied to stdout
+	// afterwards.
+	var job, out bytes.Buffer
+	fmt.Fprintf((&runConfig{logFields: true}).scopedWriter(&job, "ns", "backup"), "Deleting job: backup\n")
+	runWriter(&out).Write(job.Bytes())
+	want := "run_id=run-1 namespace=ns job=backup msg=\"Deleting job: backup\"\n"
+	if out.String() != want {
+		t.Errorf("output %q, want %q", out.String(), want)
+	}
+}
+
+func TestLogFieldsFlush(t *testing.T) {
+	defer func(w io.Writer) { stdout = w }(stdout)
+	defer func(id string) { runID = id }(runID)
+	runID = "run-1"
+	var out bytes.Buffer
+	stdout = runWriter(&out)
+	fmt.Fprintf(stdout, "Continue? [y/N]: ")
+	if out.Len() != 0 {
+		t.Errorf("Incomplete line written before flush: %q", out.String())
+	}
+	flushOutput()
+	flushOutput()
+	if want := "run_id=run-1 msg=\"Continue? [y/N]: \"\n"; out.String() != want {
+		t.Errorf("output %q, want %q", out.String(), want)
+	}
+}
+
+func TestScopedWriterWithoutLogFields(t *testing.T) {
+	var out bytes.Buffer
+	if w := (&runConfig{}).scopedWriter(&out, "ns", "backup"); w != &out {
+		t.Error("scopedWriter wraps the writer without -log-fields")
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ericchiang/k8s"
+)
+
+// finalizerPollInterval is how often a deleted job is checked with
+// "-wait-finalizers".
+const finalizerPollInterval = 2 * time.Second
+
+// waitJobGone polls the deleted job until it no longer exists or timeout
+// passes. The job's finalizers are returned if it's still there, since they are
+// what usually keeps a deleted job around.
+func waitJobGone(client *k8s.Client, dj kubeJob, timeout time.Duration) ([]string, bool, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		countAPICall(apiGet)
+		j, err := client.BatchV1().GetJob(context.Background(), dj.name, dj.namespace)
+		if isNotFound(err) {
+			return nil, true, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		// A job recreated with the same name means the deleted one is gone.
+		if dj.uid != "" && j.Metadata.GetUid() != dj.uid {
+			return nil, true, nil
+		}
+		if !time.Now().Before(deadline) {
+			return j.Metadata.GetFinalizers(), false, nil
+		}
+		time.Sleep(finalizerPollInterval)
+	}
+}
+
+// reportJobGone waits for the deleted job to disappear if cfg.waitFinalizers
+// is set, reporting jobs stuck on finalizers.
+func reportJobGone(client *k8s.Client, dj kubeJob, cfg *runConfig, w io.Writer, sum *runSummary) {
+	if cfg.waitFinalizers <= 0 {
+		return
+	}
+	finalizers, gone, err := waitJobGone(client, dj, cfg.waitFinalizers)
+	if err != nil {
+		fmt.Fprintf(w, "\tUnable to check that job %s was deleted. Error: %s\n", dj.name, err.Error())
+		sum.Errors++
+		return
+	}
+	if !gone {
+		fmt.Fprintf(w, "\tJob %s in namespace %s still exists after %v, finalizers: %v\n", dj.name, dj.namespace, cfg.waitFinalizers, finalizers)
+		sum.JobsStuck++
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestReportJobGoneFinalizers(t *testing.T) {
+	stuck := completedJob("ns", "stuck", 5)
+	stuck.Metadata.Finalizers = []string{"example.com/cleanup"}
+	api, client := newFakeAPI(t)
+	api.handle("GET", "/apis/batch/v1/namespaces/ns/jobs/stuck", func(w http.ResponseWriter, r *http.Request) {
+		writeObject(w, r, stuck)
+	})
+	cfg := testConfig()
+	cfg.waitFinalizers = time.Nanosecond
+	sum := &runSummary{}
+	reportJobGone(client, newKubeJob(stuck, testNow), cfg, ioutil.Discard, sum)
+	if sum.JobsStuck != 1 {
+		t.Errorf("%d jobs stuck, want 1", sum.JobsStuck)
+	}
+	finalizers, gone, err := waitJobGone(client, newKubeJob(stuck, testNow), time.Nanosecond)
+	if err != nil || gone || !equalStrings(finalizers, []string{"example.com/cleanup"}) {
+		t.Errorf("waitJobGone = %v, %v, %v, want the finalizer", finalizers, gone, err)
+	}
+
+	// A job that is gone, or was recreated under the same name, isn't stuck.
+	recreated := completedJob("ns", "recreated", 0)
+	api.handle("GET", "/apis/batch/v1/namespaces/ns/jobs/recreated", func(w http.ResponseWriter, r *http.Request) {
+		writeObject(w, r, recreated)
+	})
+	for _, dj := range []kubeJob{{name: "deleted", namespace: "ns"}, {name: "recreated", namespace: "ns", uid: "old-uid"}} {
+		sum := &runSummary{}
+		reportJobGone(client, dj, cfg, ioutil.Discard, sum)
+		if sum.JobsStuck != 0 || sum.Errors != 0 {
+			t.Errorf("%s: %d stuck, %d errors, want neither", dj.name, sum.JobsStuck, sum.Errors)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// guardrails holds the thresholds used to warn about a likely misconfigured
+// run.
+type guardrails struct {
+	// daysBelow and daysAbove warn when "-days" is outside [daysBelow, daysAbove].
+	daysBelow int
+	daysAbove int
+	// eligiblePercent warns when more than this percentage of all jobs is
+	// eligible for deletion. 0 disables the check.
+	eligiblePercent int
+}
+
+// check returns a warning for each threshold crossed by the run.
+func (g guardrails) check(olderThanDays, eligible, total int) []string {
+	var warnings []string
+	if olderThanDays < g.daysBelow {
+		warnings = append(warnings, fmt.Sprintf("-days=%d is below %d, recently finished jobs will be deleted", olderThanDays, g.daysBelow))
+	}
+	if g.daysAbove > 0 && olderThanDays > g.daysAbove {
+		warnings = append(warnings, fmt.Sprintf("-days=%d is above %d, very few jobs are likely to be deleted", olderThanDays, g.daysAbove))
+	}
+	if g.eligiblePercent > 0 && total > 0 && eligible*100 > total*g.eligiblePercent {
+		warnings = append(warnings, fmt.Sprintf("%d of %d jobs (more than %d%%) are eligible for deletion", eligible, total, g.eligiblePercent))
+	}
+	return warnings
+}
+
+// isInteractive reports whether stdin is a terminal.
+func isInteractive() bool {
+	fi, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// printPlan writes the jobs that are about to be cleaned up to w.
+func printPlan(w io.Writer, jobs []kubeJob) {
+	fmt.Fprintln(w, "Jobs to be deleted along with their finished pods:")
+	for _, j := range jobs {
+		fmt.Fprintf(w, "\tJob: %s\tNamespace: %s\tAge:%vd\n", j.name, j.namespace, j.age)
+	}
+}
+
+// stdin reads the answers to confirmations. A run can ask more than once, and
+// a reader of its own per question could buffer and lose the next answer.
+var stdin = bufio.NewReader(os.Stdin)
+
+// confirm asks the user a yes/no question on stdin, defaulting to no.
+func confirm(question string) bool {
+	fmt.Fprintf(stdout, "%s [y/N]: ", question)
+	flushOutput()
+	answer, err := stdin.ReadString('\n')
+	if err != nil {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// confirmTyped asks the user to type word on stdin to confirm a dangerous
+// action. Anything else, including just pressing Enter, declines.
+func confirmTyped(action, word string) bool {
+	fmt.Fprintf(stdout, "%s Type %q to confirm: ", action, word)
+	flushOutput()
+	answer, err := stdin.ReadString('\n')
+	if err != nil {
+		return false
+	}
+	return strings.TrimSpace(answer) == word
+}
+
+// planHash returns a hash of the jobs to be cleaned up, regardless of their
+// order. A dry-run prints it for approval and "-require-plan-hash" checks that
+// a later run would clean up exactly the same jobs. The UIDs make a job
+// recreated under the same name a different plan.
+func planHash(jobs []kubeJob) string {
+	keys := make([]string, 0, len(jobs))
+	for _, j := range jobs {
+		keys = append(keys, j.namespace+"/"+j.name+"/"+j.uid)
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, k := range keys {
+		fmt.Fprintln(h, k)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+// answer makes the confirmations read input instead of stdin.
+func answer(t *testing.T, input string) {
+	old := stdin
+	stdin = bufio.NewReader(strings.NewReader(input))
+	t.Cleanup(func() { stdin = old })
+}
+
+func TestConfirmTyped(t *testing.T) {
+	captureStdout(t)
+	tests := []struct {
+		input string
+		want  bool
+	}{
+		{"all-namespaces\n", true},
+		{"  all-namespaces  \n", true},
+		{"\n", false},
+		{"y\n", false},
+		{"All-Namespaces\n", false},
+		// Without a newline stdin was closed before the answer was done.
+		{"all-namespaces", false},
+	}
+	for _, tt := range tests {
+		answer(t, tt.input)
+		if got := confirmTyped("Sure?", "all-namespaces"); got != tt.want {
+			t.Errorf("Answer %q: confirmed = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestConfirmationsShareStdin(t *testing.T) {
+	captureStdout(t)
+	// Both answers arrive at once, as when piped in.
+	answer(t, "all-namespaces\ny\n")
+	if !confirmTyped("Sure?", "all-namespaces") {
+		t.Fatal("First answer declined")
+	}
+	if !confirm("Continue?") {
+		t.Error("Second answer lost")
+	}
+}
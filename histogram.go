@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// ageBuckets are the lower bounds in days of the "-report-age-histogram"
+// buckets. The last bucket is open ended.
+var ageBuckets = []int{0, 7, 14, 30, 90, 365}
+
+// ageHistogram counts the jobs in each of ageBuckets.
+func ageHistogram(jobs []kubeJob) []int {
+	counts := make([]int, len(ageBuckets))
+	for _, j := range jobs {
+		for i := len(ageBuckets) - 1; i >= 0; i-- {
+			if j.age >= ageBuckets[i] {
+				counts[i]++
+				break
+			}
+		}
+	}
+	return counts
+}
+
+// ageBucketLabel returns the label of the i-th of ageBuckets, e.g. "7-14d".
+func ageBucketLabel(i int) string {
+	if i+1 < len(ageBuckets) {
+		return fmt.Sprintf("%d-%dd", ageBuckets[i], ageBuckets[i+1])
+	}
+	return fmt.Sprintf("%dd+", ageBuckets[i])
+}
+
+// ageHistogramByLabel counts the jobs in each of ageBuckets by bucket label,
+// for the summary line and JSON report.
+func ageHistogramByLabel(jobs []kubeJob) map[string]int {
+	counts := make(map[string]int, len(ageBuckets))
+	for i, count := range ageHistogram(jobs) {
+		counts[ageBucketLabel(i)] = count
+	}
+	return counts
+}
+
+// printAgeHistogram writes the age distribution of the jobs to w, one bucket
+// per line.
+func printAgeHistogram(w io.Writer, jobs []kubeJob) {
+	fmt.Fprintln(w, "Age distribution of eligible jobs:")
+	for i, count := range ageHistogram(jobs) {
+		fmt.Fprintf(w, "\t%s\t%d\n", ageBucketLabel(i), count)
+	}
+}
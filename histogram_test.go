@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAgeHistogramBoundaries(t *testing.T) {
+	var jobs []kubeJob
+	for _, age := range []int{0, 6, 7, 13, 14, 29, 30, 89, 90, 364, 365, 1000} {
+		jobs = append(jobs, kubeJob{age: age})
+	}
+	if got, want := ageHistogram(jobs), []int{2, 2, 2, 2, 2, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ageHistogram = %v, want %v", got, want)
+	}
+	jobs = []kubeJob{{age: 7}, {age: 30}, {age: 31}}
+	want := map[string]int{"0-7d": 0, "7-14d": 1, "14-30d": 0, "30-90d": 2, "90-365d": 0, "365d+": 0}
+	if got := ageHistogramByLabel(jobs); !reflect.DeepEqual(got, want) {
+		t.Errorf("ageHistogramByLabel = %v, want %v", got, want)
+	}
+}
+
+func TestAgeHistogramInSummaryLine(t *testing.T) {
+	histogram := ageHistogramByLabel([]kubeJob{{age: 3}})
+	for _, tc := range []struct {
+		histogram map[string]int
+		want      map[string]int
+	}{
+		{nil, nil},
+		{histogram, histogram},
+	} {
+		var out bytes.Buffer
+		printSummaryLine(&out, &runSummary{AgeHistogram: tc.histogram}, true, time.Second)
+		var line summaryLine
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(out.String(), summaryLinePrefix)), &line); err != nil {
+			t.Fatalf("Summary line %q: %v", out.String(), err)
+		}
+		if !reflect.DeepEqual(line.AgeHistogram, tc.want) {
+			t.Errorf("age_histogram = %v, want %v", line.AgeHistogram, tc.want)
+		}
+	}
+}
+
+func TestAgeHistogramInJSONReport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.json")
+	histogram := ageHistogramByLabel([]kubeJob{{age: 100}})
+	entry := newAuditEntry(testNow, testConfig(), &runSummary{AgeHistogram: histogram})
+	if err := writeJSONReport(path, entry, &itemRecorder{}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report struct {
+		AgeHistogram map[string]int `json:"age_histogram"`
+	}
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(report.AgeHistogram, histogram) {
+		t.Errorf("age_histogram = %v, want %v", report.AgeHistogram, histogram)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/ericchiang/k8s"
+)
+
+// impersonatingTransport makes every request on behalf of user and groups,
+// like kubectl's "--as" and "--as-group". The API server only accepts this if
+// the client's own identity is allowed to impersonate them.
+type impersonatingTransport struct {
+	user   string
+	groups []string
+	base   http.RoundTripper
+}
+
+func (t *impersonatingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A RoundTripper must not modify the request it was given.
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header)+2)
+	for name, values := range req.Header {
+		r.Header[name] = values
+	}
+	r.Header.Set("Impersonate-User", t.user)
+	for _, g := range t.groups {
+		r.Header.Add("Impersonate-Group", g)
+	}
+	return t.base.RoundTrip(r)
+}
+
+// impersonate makes the client act as user and groups.
+func impersonate(client *k8s.Client, user string, groups []string) {
+	base := client.Client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	client.Client.Transport = &impersonatingTransport{user: user, groups: groups, base: base}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestImpersonateHeaders(t *testing.T) {
+	api, client := newFakeAPI(t)
+	api.handle("GET", "/version", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]string{"gitVersion": "v1.29.0"})
+	})
+	impersonate(client, "jobliterator-ro", []string{"auditors", "ops"})
+	if err := checkConnection(client); err != nil {
+		t.Fatal(err)
+	}
+	if len(api.requests) != 1 {
+		t.Fatalf("%d requests, want 1", len(api.requests))
+	}
+	h := api.requests[0].Header
+	if got := h.Get("Impersonate-User"); got != "jobliterator-ro" {
+		t.Errorf("Impersonate-User %q, want jobliterator-ro", got)
+	}
+	if got := h["Impersonate-Group"]; !equalStrings(got, []string{"auditors", "ops"}) {
+		t.Errorf("Impersonate-Group %q, want auditors and ops", got)
+	}
+}
+
+func TestImpersonateForbidden(t *testing.T) {
+	api, client := newFakeAPI(t)
+	api.handle("GET", "/version", func(w http.ResponseWriter, r *http.Request) {
+		writeStatus(w, r, http.StatusForbidden, "cannot impersonate")
+	})
+	impersonate(client, "admin", nil)
+	err := checkConnection(client)
+	sErr, ok := err.(*setupError)
+	if !ok || sErr.reason != "impersonation" || sErr.code != exitAuth {
+		t.Errorf("Error %#v, want an impersonation setup error", err)
+	}
+	if h := api.requests[0].Header; len(h["Impersonate-Group"]) != 0 {
+		t.Errorf("Impersonate-Group %q sent without -as-group", h["Impersonate-Group"])
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/ericchiang/k8s"
+	batchv1 "github.com/ericchiang/k8s/apis/batch/v1"
+	"github.com/ghodss/yaml"
+)
+
+// readJobList parses a YAML or JSON list of "namespace/name" job references,
+// as accepted by the "-stdin" mode.
+func readJobList(r io.Reader) ([]kubeJob, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read job list: %v", err)
+	}
+	var refs []string
+	if err := yaml.Unmarshal(data, &refs); err != nil {
+		return nil, fmt.Errorf("Failed to parse job list: %v", err)
+	}
+	jobs := make([]kubeJob, 0, len(refs))
+	for _, ref := range refs {
+		parts := strings.SplitN(ref, "/", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("Invalid job %q in job list, expected \"namespace/name\"", ref)
+		}
+		jobs = append(jobs, kubeJob{namespace: parts[0], name: parts[1]})
+	}
+	return jobs, nil
+}
+
+// resolveJobList fetches each listed job and returns the ones that are still
+// eligible for deletion. If force is set eligibility isn't checked and every
+// job that still exists is returned, marked to skip the completion re-check.
+func resolveJobList(client *k8s.Client, refs []kubeJob, now time.Time, cfg *runConfig, force bool) ([]kubeJob, error) {
+	var fetched []*batchv1.Job
+	for _, ref := range refs {
+		countAPICall(apiGet)
+		j, err := client.BatchV1().GetJob(context.Background(), ref.name, ref.namespace)
+		if err != nil {
+			if isNotFound(err) {
+				fmt.Fprintf(stdout, "Job %s in namespace %s not found, skipping.\n", ref.name, ref.namespace)
+				continue
+			}
+			return nil, fmt.Errorf("Error getting job: %s", err.Error())
+		}
+		fetched = append(fetched, j)
+	}
+	// Whether the cluster sets conditions is only known once all the jobs
+	// are fetched.
+	cfg.noConditions = noJobConditions(fetched)
+	var jobs []kubeJob
+	for _, j := range fetched {
+		if force {
+			kj := newKubeJob(j, now)
+			kj.reason = reasonListed
+			kj.forced = true
+			jobs = append(jobs, kj)
+			continue
+		}
+		kj, ok := eligibleJob(j, now, cfg, false)
+		if !ok {
+			fmt.Fprintf(stdout, "Job %s in namespace %s is no longer eligible for deletion, skipping.\n", j.Metadata.GetName(), j.Metadata.GetNamespace())
+			continue
+		}
+		jobs = append(jobs, kj)
+	}
+	return jobs, nil
+}
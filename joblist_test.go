@@ -0,0 +1,104 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func TestReadJobList(t *testing.T) {
+	for _, input := range []string{
+		"- team-a/backup\n- team-b/report\n",
+		`["team-a/backup", "team-b/report"]`,
+	} {
+		jobs, err := readJobList(strings.NewReader(input))
+		if err != nil {
+			t.Fatalf("%q: %v", input, err)
+		}
+		if len(jobs) != 2 || jobs[0].namespace != "team-a" || jobs[0].name != "backup" || jobs[1].namespace != "team-b" || jobs[1].name != "report" {
+			t.Errorf("%q: jobs = %+v", input, jobs)
+		}
+	}
+	for _, input := range []string{"- backup\n", "- /backup\n", "- team-a/\n", "team-a/backup"} {
+		if _, err := readJobList(strings.NewReader(input)); err == nil {
+			t.Errorf("%q: expected an error", input)
+		}
+	}
+}
+
+func TestStdinDeleteFlow(t *testing.T) {
+	old := completedJob("ns", "old", 5)
+	young := completedJob("ns", "young", 0)
+	api, client := newFakeAPI(t)
+	api.serveJobs(old, young)
+	api.servePods("ns", testPod(old, "old-1", "Succeeded"), testPod(young, "young-1", "Succeeded"))
+	refs, err := readJobList(strings.NewReader("- ns/old\n- ns/young\n- ns/gone\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := testConfig()
+	cfg.deleteJobs = true
+	jobs, err := resolveJobList(client, refs, testNow, cfg, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0].name != "old" || jobs[0].uid != old.Metadata.GetUid() {
+		t.Fatalf("resolved jobs = %+v, want only old", jobs)
+	}
+	sum := &runSummary{}
+	cleanupJobs(client, jobs, cfg, sum, 1)
+	if sum.JobsDeleted != 1 || sum.PodsDeleted != 1 || sum.Errors != 0 {
+		t.Errorf("deleted %d jobs and %d pods with %d errors, want 1, 1, 0", sum.JobsDeleted, sum.PodsDeleted, sum.Errors)
+	}
+	if api.count("DELETE", "/apis/batch/v1/namespaces/ns/jobs/old") != 1 || api.count("DELETE", "/api/v1/namespaces/ns/pods/old-1") != 1 {
+		t.Error("old job or its pod wasn't deleted")
+	}
+	if api.count("DELETE", "/apis/batch/v1/namespaces/ns/jobs/young") != 0 || api.count("DELETE", "/api/v1/namespaces/ns/pods/young-1") != 0 {
+		t.Error("young job or its pod was deleted")
+	}
+
+	forced, err := resolveJobList(client, refs[1:], testNow, cfg, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(forced) != 1 || forced[0].name != "young" || forced[0].reason != reasonListed {
+		t.Errorf("forced jobs = %+v, want young", forced)
+	}
+}
+
+func TestStdinForceUnfinished(t *testing.T) {
+	running := newTestJob("ns", "running")
+	running.Status.StartTime = metaTime(testNow.Add(-time.Hour))
+	running.Status.Active = proto.Int32(1)
+	api, client := newFakeAPI(t)
+	api.serveJobs(running)
+	api.servePods("ns", testPod(running, "running-1", "Running"))
+	refs := []kubeJob{{namespace: "ns", name: "running"}}
+	cfg := testConfig()
+	cfg.deleteJobs = true
+	cfg.verifyCompletion = true
+	captureStdout(t)
+
+	jobs, err := resolveJobList(client, refs, testNow, cfg, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 0 {
+		t.Fatalf("resolved jobs = %+v, want none without -force", jobs)
+	}
+
+	jobs, err = resolveJobList(client, refs, testNow, cfg, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := &runSummary{}
+	cleanupJobs(client, jobs, cfg, sum, 1)
+	if sum.JobsDeleted != 1 || sum.Skipped["unfinished"] != 0 {
+		t.Errorf("deleted %d jobs, skipped %v, want the forced job deleted", sum.JobsDeleted, sum.Skipped)
+	}
+	if api.count("DELETE", "/apis/batch/v1/namespaces/ns/jobs/running") != 1 {
+		t.Error("forced job wasn't deleted")
+	}
+}
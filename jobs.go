@@ -0,0 +1,651 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ericchiang/k8s"
+	apiv1 "github.com/ericchiang/k8s/api/v1"
+	batchv1 "github.com/ericchiang/k8s/apis/batch/v1"
+	metav1 "github.com/ericchiang/k8s/apis/meta/v1"
+	"github.com/golang/protobuf/proto"
+)
+
+// markedForDeletionAnnotation holds the RFC3339 timestamp at which a job was
+// marked for deletion. Used by the "-sweep-marked" pass.
+const markedForDeletionAnnotation = "marked-for-deletion"
+
+// jobTrackingAnnotation marks jobs the job controller tracks with pod
+// finalizers, before that became the only mode in Kubernetes 1.26.
+const jobTrackingAnnotation = "batch.kubernetes.io/job-tracking"
+
+// jobTrackingFinalizer is kept on a job's pods until the job controller has
+// counted them.
+const jobTrackingFinalizer = "batch.kubernetes.io/job-tracking"
+
+// helmHookAnnotation marks jobs run as Helm hooks, whose lifecycle is managed
+// by Helm.
+const helmHookAnnotation = "helm.sh/hook"
+
+// The "-reversed-timestamps" modes for jobs that completed before they
+// started.
+const (
+	reversedSkip      = "skip"
+	reversedStartTime = "start-time"
+)
+
+// The "-completion-strategy" ways of telling whether a job is finished.
+const (
+	strategyConditions = "conditions"
+	strategyCounts     = "counts"
+)
+
+// conditionlessWarning is printed the first time a job without conditions is
+// judged by its pod counts.
+var conditionlessWarning sync.Once
+
+// eligibleJob reports whether the job should be cleaned up according to cfg,
+// returning it as a kubeJob if so. A staleActive job, one that is still
+// counted as active although none of its pods are, is treated as finished.
+func eligibleJob(j *batchv1.Job, now time.Time, cfg *runConfig, staleActive bool) (kubeJob, bool) {
+	ex := newExplanation(cfg.explain, j)
+	if emptyJobStatus(j) {
+		// Freshly created, the job controller hasn't looked at it yet.
+		ex.note("empty status, not yet evaluable")
+		return ex.verdict(kubeJob{}, false)
+	}
+	active := j.Status.GetActive() > 0
+	ex.check("active?", active)
+	if active && staleActive {
+		ex.note("no live pods, treating it as finished")
+	} else if active {
+		return ex.verdict(kubeJob{}, false)
+	}
+	if r := cfg.policy.rule(j.Metadata.GetNamespace()); r != nil && r.ProtectAnnotation != "" {
+		_, protected := j.Metadata.GetAnnotations()[r.ProtectAnnotation]
+		ex.check("has "+r.ProtectAnnotation+" annotation?", protected)
+		if protected {
+			return ex.verdict(kubeJob{}, false)
+		}
+	}
+	// With explicit -completion-conditions the job must have one of them,
+	// and its age counts from when it got it.
+	var doneAt *batchv1.JobCondition
+	if cfg.conditionAge {
+		doneAt = finishedCondition(j, cfg.completionConditions)
+		ex.check(strings.Join(cfg.completionConditions, " or ")+" condition?", doneAt != nil)
+		if doneAt == nil && !conditionlessDone(j, cfg) {
+			return ex.verdict(kubeJob{}, false)
+		}
+	}
+	if len(cfg.excludeAnnotations) > 0 {
+		excluded := cfg.excludeAnnotations.excluded(j.Metadata.GetAnnotations())
+		ex.check("matches -exclude-annotation "+cfg.excludeAnnotations.String()+"?", excluded)
+		if excluded {
+			return ex.verdict(kubeJob{}, false)
+		}
+	}
+	hasCompletion := j.Status.GetCompletionTime() != nil || doneAt != nil || conditionlessDone(j, cfg)
+	if cfg.completionStrategy == strategyCounts {
+		hasCompletion = finishedByCounts(j)
+		ex.check("finished by pod counts?", hasCompletion)
+	} else {
+		ex.check("completion time present?", hasCompletion)
+	}
+	if !hasCompletion {
+		if !cfg.ageFallback && !staleActive {
+			debugf("Job %s in namespace %s has no completion time, skipping\n", j.Metadata.GetName(), j.Metadata.GetNamespace())
+			return ex.verdict(kubeJob{}, false)
+		}
+		ex.note("using creation time")
+	}
+	if cfg.minCompletionsSucceeded {
+		succeeded := j.Status.GetSucceeded() >= requiredCompletions(j)
+		ex.check(fmt.Sprintf("succeeded %d >= completions %d?", j.Status.GetSucceeded(), requiredCompletions(j)), succeeded)
+		if !succeeded && cfg.includeFailed {
+			failed := finishedCondition(j, []string{"Failed"}) != nil
+			ex.check("failed (-include-failed)?", failed)
+			succeeded = failed
+		}
+		if !succeeded {
+			return ex.verdict(kubeJob{}, false)
+		}
+	}
+	reversed := reversedTimestamps(j)
+	ex.check("completed before it started?", reversed)
+	if reversed {
+		fmt.Fprintf(stdout, "Job %s in namespace %s completed at %s, before it started at %s.\n", j.Metadata.GetName(), j.Metadata.GetNamespace(),
+			time.Unix(j.Status.GetCompletionTime().GetSeconds(), 0).UTC().Format(time.RFC3339), time.Unix(j.Status.GetStartTime().GetSeconds(), 0).UTC().Format(time.RFC3339))
+		if cfg.reversedTimestamps != reversedStartTime {
+			ex.note("see -reversed-timestamps")
+			return ex.verdict(kubeJob{}, false)
+		}
+		ex.note("using start time")
+	}
+	// Judge the age as of a little earlier, so a job right at the threshold
+	// isn't deleted or kept depending on clock jitter.
+	kj := newKubeJob(j, now.Add(-cfg.clockSkew))
+	kj.staleActive = staleActive
+	if staleActive {
+		kj.reason = reasonStaleActive
+	}
+	agedFrom := jobAgedFrom(j)
+	if reversed {
+		agedFrom = time.Unix(j.Status.GetStartTime().GetSeconds(), 0)
+		kj.age = daysSince(agedFrom, now.Add(-cfg.clockSkew))
+	} else if doneAt != nil {
+		agedFrom = time.Unix(doneAt.GetLastTransitionTime().GetSeconds(), 0)
+		kj.age = daysSince(agedFrom, now.Add(-cfg.clockSkew))
+	}
+	if cfg.maxAgeDays > 0 {
+		// Very old jobs can be intentional landmarks that must be kept.
+		young := kj.age <= cfg.maxAgeDays
+		ex.check(fmt.Sprintf("age %dd <= -max-age %dd?", kj.age, cfg.maxAgeDays), young)
+		if !young {
+			return ex.verdict(kj, false)
+		}
+	}
+	if cfg.sweepMarked {
+		markedDays, marked, err := markedDaysAgo(j, now)
+		if err != nil {
+			fmt.Fprintf(stdout, "Job %s in namespace %s skipped. %s.\n", j.Metadata.GetName(), j.Metadata.GetNamespace(), err.Error())
+			ex.note("invalid " + markedForDeletionAnnotation + " annotation")
+			return ex.verdict(kubeJob{}, false)
+		}
+		ex.check("marked for deletion?", marked)
+		if !marked {
+			return ex.verdict(kj, false)
+		}
+		kj.reason = reasonMarked
+		graceOver := markedDays >= cfg.markGraceDays
+		ex.check(fmt.Sprintf("marked %dd ago >= %dd?", markedDays, cfg.markGraceDays), graceOver)
+		if graceOver && keptHelmHook(j, cfg, ex) {
+			return ex.verdict(kubeJob{}, false)
+		}
+		return ex.verdict(kj, graceOver)
+	}
+	days := cfg.daysFor(j.Metadata.GetNamespace(), j.Metadata.GetLabels())
+	oldEnough := kj.age >= days
+	ex.check(fmt.Sprintf("age %dd >= %dd?", kj.age, days), oldEnough)
+	if oldEnough && !cfg.lastRun.IsZero() {
+		// Jobs that were already old enough at the last successful run have
+		// been dealt with by it.
+		eligibleAt := agedFrom.Add(time.Duration(days)*24*time.Hour + cfg.clockSkew)
+		seen := !eligibleAt.After(cfg.lastRun)
+		ex.check("already eligible at last run?", seen)
+		if seen {
+			return ex.verdict(kj, false)
+		}
+	}
+	if oldEnough && keptHelmHook(j, cfg, ex) {
+		return ex.verdict(kubeJob{}, false)
+	}
+	return ex.verdict(kj, oldEnough)
+}
+
+// keptHelmHook reports whether the job is a Helm hook that is kept, printing
+// that it's skipped. It's only checked for jobs that are otherwise eligible,
+// so hooks that are kept anyway aren't reported on every run.
+func keptHelmHook(j *batchv1.Job, cfg *runConfig, ex *explanation) bool {
+	hook, ok := j.Metadata.GetAnnotations()[helmHookAnnotation]
+	if !ok || cfg.includeHelmHooks {
+		return false
+	}
+	// Deleting hooks out of band confuses "helm status".
+	fmt.Fprintf(stdout, "Job %s in namespace %s is a Helm %s hook, skipping.\n", j.Metadata.GetName(), j.Metadata.GetNamespace(), hook)
+	ex.note("Helm hook, see -include-helm-hooks")
+	return true
+}
+
+// emptyJobStatus reports whether the job has no status at all yet: no pod
+// counts, start or completion time, or conditions.
+func emptyJobStatus(j *batchv1.Job) bool {
+	s := j.Status
+	return s.GetActive() == 0 && s.GetSucceeded() == 0 && s.GetFailed() == 0 &&
+		s.GetStartTime() == nil && s.GetCompletionTime() == nil && len(s.GetConditions()) == 0
+}
+
+// staleActiveJob reports whether the job is counted as active although none of
+// its pods are pending or running, e.g. because its pods were deleted before
+// the job controller counted them. Such jobs never finish on their own. With
+// "-handle-dead-nodes", pods on nodes that no longer exist aren't live either.
+func staleActiveJob(client *k8s.Client, j *batchv1.Job, cfg *runConfig) (bool, error) {
+	if j.Status.GetActive() == 0 {
+		return false, nil
+	}
+	pods, err := listJobPods(client, kubeJob{name: j.Metadata.GetName(), namespace: j.Metadata.GetNamespace(), uid: j.Metadata.GetUid()}, cfg.jobLabels, stdout)
+	if err != nil {
+		return false, err
+	}
+	for _, p := range pods {
+		if podFinished(p.Status.GetPhase()) {
+			continue
+		}
+		if !cfg.handleDeadNodes {
+			return false, nil
+		}
+		dead, err := deadNodes.onDeadNode(client, p)
+		if err != nil || !dead {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// listJobs lists the jobs in each of the namespaces, only returning jobs
+// matching the namespace's label selector if it's set. Namespaces the client
+// isn't allowed to list jobs in are skipped and returned. Namespaces whose list
+// fails otherwise are deferred to up to passes retry passes after all others,
+// and returned as retried.
+func listJobs(client *k8s.Client, namespaces []string, selectorFor func(namespace string) string, passes int) (jobs []*batchv1.Job, skipped, retried []string, err error) {
+	pending := namespaces
+	for pass := 0; len(pending) > 0; pass++ {
+		var failed []string
+		for _, ns := range pending {
+			tracer.startNamespace("list jobs", ns)
+			list, err := listAllJobs(client, ns, selectorFor(ns))
+			tracer.endNamespace()
+			if err != nil {
+				if ns != k8s.AllNamespaces && isForbidden(err) {
+					fmt.Fprintf(stdout, "Insufficient permissions in namespace %s, skipping.\n", ns)
+					skipped = append(skipped, ns)
+					continue
+				}
+				if pass >= passes {
+					return nil, nil, nil, fmt.Errorf("Unable to list jobs in namespace %s: %v", ns, err)
+				}
+				fmt.Fprintf(stdout, "Unable to list jobs in namespace %s, retrying it after the other namespaces. Error: %s\n", ns, err.Error())
+				failed = append(failed, ns)
+				continue
+			}
+			jobs = append(jobs, list...)
+		}
+		if pass == 0 {
+			retried = failed
+		}
+		pending = failed
+	}
+	return jobs, skipped, retried, nil
+}
+
+// listChunkSize is the number of jobs asked for in each list request, so a
+// namespace with many jobs doesn't make a single huge response.
+const listChunkSize = 500
+
+// listAllJobs lists the jobs in the namespace matching selector, in chunks of
+// listChunkSize. The continue token is followed until all chunks are read, so
+// no jobs are silently missed.
+func listAllJobs(client *k8s.Client, namespace, selector string) ([]*batchv1.Job, error) {
+	var jobs []*batchv1.Job
+	token := ""
+	for {
+		query := selectorQuery(selector)
+		query.Set("limit", strconv.Itoa(listChunkSize))
+		if token != "" {
+			query.Set("continue", token)
+		}
+		list := new(batchv1.JobList)
+		err := apiRetry.do(func() error {
+			countAPICall(apiList)
+			return listRequest(context.Background(), client, jobsPath(namespace), query, list)
+		})
+		if err != nil {
+			if token != "" && isGone(err) {
+				// The list changed too much while it was read.
+				return nil, fmt.Errorf("Continuing the list of jobs in %q expired, retry the run: %v", namespace, err)
+			}
+			return nil, err
+		}
+		jobs = append(jobs, list.Items...)
+		if token = listContinue(list.GetMetadata()); token == "" {
+			return jobs, nil
+		}
+		debugf("Listed %d jobs in %q so far, continuing\n", len(jobs), namespace)
+	}
+}
+
+// listContinue returns the continue token of a list. The token is newer than
+// the ListMeta of the client library, so it's read from the fields the
+// protobuf decoding didn't recognize.
+func listContinue(meta *metav1.ListMeta) string {
+	if meta == nil {
+		return ""
+	}
+	_, token, _ := unrecognizedField(meta.XXX_unrecognized, listMetaContinueField)
+	return string(token)
+}
+
+// unrecognizedField returns the value of the protobuf field number in data,
+// the XXX_unrecognized fields of a message, as a number for varint fields or
+// as bytes for length-delimited ones. The last return value is false if the
+// field isn't there or data can't be decoded.
+func unrecognizedField(data []byte, field uint64) (uint64, []byte, bool) {
+	b := proto.NewBuffer(data)
+	for {
+		key, err := b.DecodeVarint()
+		if err != nil {
+			return 0, nil, false
+		}
+		var n uint64
+		var value []byte
+		switch key & 7 {
+		case proto.WireVarint:
+			n, err = b.DecodeVarint()
+		case proto.WireFixed64:
+			n, err = b.DecodeFixed64()
+		case proto.WireFixed32:
+			n, err = b.DecodeFixed32()
+		case proto.WireBytes:
+			value, err = b.DecodeRawBytes(false)
+		default:
+			return 0, nil, false
+		}
+		if err != nil {
+			return 0, nil, false
+		}
+		if key>>3 == field {
+			return n, value, true
+		}
+	}
+}
+
+// listMetaContinueField is the protobuf field number of ListMeta.continue.
+const listMetaContinueField = 3
+
+// listClusterJobs lists the jobs matching selector in all namespaces with a
+// single request. This is much cheaper than listing every namespace when few
+// jobs match. The second return value is false if the client isn't allowed to
+// list jobs cluster-wide.
+func listClusterJobs(client *k8s.Client, selector string) ([]*batchv1.Job, bool, error) {
+	jobs, err := listAllJobs(client, k8s.AllNamespaces, selector)
+	if isForbidden(err) {
+		debugf("Not allowed to list jobs cluster-wide, listing each namespace instead\n")
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return jobs, true, nil
+}
+
+// jobsPath returns the API path of the jobs in the namespace.
+func jobsPath(namespace string) string {
+	if namespace == k8s.AllNamespaces {
+		return "/apis/batch/v1/jobs"
+	}
+	return "/apis/batch/v1/namespaces/" + namespace + "/jobs"
+}
+
+// selectorQuery returns the list query parameters for the label selector.
+func selectorQuery(selector string) url.Values {
+	query := url.Values{}
+	if selector != "" {
+		query.Set("labelSelector", selector)
+	}
+	return query
+}
+
+// reapableJob reports whether the job is finished but too young to be deleted,
+// in which case its old pods can still be reaped with
+// "-reap-pods-under-threshold".
+func reapableJob(j *batchv1.Job, now time.Time, cfg *runConfig) (kubeJob, bool) {
+	if cfg.sweepMarked || j.Status.GetActive() > 0 || j.Status.GetCompletionTime() == nil {
+		return kubeJob{}, false
+	}
+	kj := newKubeJob(j, now)
+	return kj, kj.age < cfg.daysFor(j.Metadata.GetNamespace(), j.Metadata.GetLabels())
+}
+
+// reversedTimestamps reports whether the job's completion time is before its
+// start time, which makes its age meaningless.
+func reversedTimestamps(j *batchv1.Job) bool {
+	completed, started := j.Status.GetCompletionTime(), j.Status.GetStartTime()
+	if completed == nil || started == nil {
+		return false
+	}
+	return completed.GetSeconds() < started.GetSeconds()
+}
+
+// runningReapableJob reports whether the job is active, in which case its old
+// succeeded pods can be reaped with "-delete-succeeded-pods-of-running-jobs".
+func runningReapableJob(j *batchv1.Job, now time.Time) (kubeJob, bool) {
+	if j.Status.GetActive() == 0 || j.Status.GetCompletionTime() != nil {
+		return kubeJob{}, false
+	}
+	kj := newKubeJob(j, now)
+	_, kj.finalizerTracked = j.Metadata.GetAnnotations()[jobTrackingAnnotation]
+	return kj, true
+}
+
+// requiredCompletions returns the number of successful pods the job needs to
+// complete, which defaults to 1.
+func requiredCompletions(j *batchv1.Job) int32 {
+	if j.Spec == nil || j.Spec.Completions == nil {
+		return 1
+	}
+	return *j.Spec.Completions
+}
+
+// podDaysOld returns the age of the pod in days since it started, or since it
+// was created if it never started.
+func podDaysOld(p *apiv1.Pod, now time.Time) int {
+	return int(now.Sub(podStartTime(p)).Hours() / 24)
+}
+
+// podStartTime returns when the pod started, or when it was created if it
+// never started.
+func podStartTime(p *apiv1.Pod) time.Time {
+	start := p.Status.GetStartTime()
+	if start == nil {
+		start = p.Metadata.GetCreationTimestamp()
+	}
+	return time.Unix(start.GetSeconds(), 0)
+}
+
+// jobFinished reports whether the job has one of the "-completion-conditions",
+// by default "Complete" or "Failed", with status "True". Unlike a zero active
+// count, this also holds for jobs that never started.
+func jobFinished(j *batchv1.Job, conditions []string) bool {
+	return finishedCondition(j, conditions) != nil
+}
+
+// jobDone reports whether the job is finished according to
+// cfg.completionStrategy. With the conditions strategy, jobs of a run where
+// none have conditions, as on very old clusters, are judged by their pod
+// counts.
+func jobDone(j *batchv1.Job, cfg *runConfig) bool {
+	if cfg.completionStrategy == strategyCounts {
+		return finishedByCounts(j)
+	}
+	return jobFinished(j, cfg.completionConditions) || conditionlessDone(j, cfg)
+}
+
+// noJobConditions reports whether none of the jobs have any conditions. A
+// cluster that sets them can still show a job without conditions for a while,
+// such as between a failed pod and its retry, so such a job alone doesn't mean
+// its counts are all there is to go on.
+func noJobConditions(jobs []*batchv1.Job) bool {
+	for _, j := range jobs {
+		if len(j.Status.GetConditions()) > 0 {
+			return false
+		}
+	}
+	return len(jobs) > 0
+}
+
+// conditionlessDone reports whether the job has no conditions at all in a run
+// where no job has any, and is finished by its pod counts. It warns the first
+// time it happens in a run.
+func conditionlessDone(j *batchv1.Job, cfg *runConfig) bool {
+	if !cfg.noConditions || len(j.Status.GetConditions()) > 0 || !finishedByCounts(j) {
+		return false
+	}
+	conditionlessWarning.Do(func() {
+		fmt.Fprintf(stdout, "WARNING: No job has conditions, judging job %s in namespace %s and the others by their pod counts. Use -completion-strategy %s on clusters that never set them.\n", j.Metadata.GetName(), j.Metadata.GetNamespace(), strategyCounts)
+	})
+	return true
+}
+
+// finishedByCounts reports whether the job has no active pods and either
+// enough succeeded pods or more failed ones than its backoff limit allows. A
+// job with fewer failures is still being retried.
+func finishedByCounts(j *batchv1.Job) bool {
+	s := j.Status
+	return s.GetActive() == 0 && (s.GetSucceeded() >= requiredCompletions(j) || s.GetFailed() > backoffLimit(j))
+}
+
+// backoffLimit returns the number of retries of the job before it's failed.
+// The field is newer than the JobSpec of the client library, so it's read from
+// the fields the protobuf decoding didn't recognize.
+func backoffLimit(j *batchv1.Job) int32 {
+	if j.Spec == nil {
+		return defaultBackoffLimit
+	}
+	if limit, _, ok := unrecognizedField(j.Spec.XXX_unrecognized, jobSpecBackoffLimitField); ok {
+		return int32(limit)
+	}
+	return defaultBackoffLimit
+}
+
+// jobSpecBackoffLimitField is the protobuf field number of
+// JobSpec.backoffLimit, and defaultBackoffLimit the limit of jobs that don't
+// set it.
+const (
+	jobSpecBackoffLimitField = 7
+	defaultBackoffLimit      = 6
+)
+
+// finishedCondition returns the job's first condition of one of the types
+// that has status "True", or nil if there is none.
+func finishedCondition(j *batchv1.Job, conditions []string) *batchv1.JobCondition {
+	for _, c := range j.Status.GetConditions() {
+		if containsString(conditions, c.GetType()) && c.GetStatus() == "True" {
+			return c
+		}
+	}
+	return nil
+}
+
+// activeFor returns how long the job has been running, from its start time or
+// its creation if it hasn't started.
+func activeFor(j *batchv1.Job, now time.Time) time.Duration {
+	start := j.Status.GetStartTime()
+	if start == nil {
+		start = j.Metadata.GetCreationTimestamp()
+	}
+	return now.Sub(time.Unix(start.GetSeconds(), 0)).Truncate(time.Second)
+}
+
+// daysSince returns the whole days from t to now, or 0 if t is in the future.
+func daysSince(t, now time.Time) int {
+	if days := int(now.Sub(t).Hours() / 24); days > 0 {
+		return days
+	}
+	return 0
+}
+
+// jobAgedFrom returns the time the job's age counts from, its completion or
+// its creation if it has no completion time.
+func jobAgedFrom(j *batchv1.Job) time.Time {
+	ageFrom := j.Status.GetCompletionTime()
+	if ageFrom == nil {
+		ageFrom = j.Metadata.GetCreationTimestamp()
+	}
+	return time.Unix(ageFrom.GetSeconds(), 0)
+}
+
+// newKubeJob converts the job to a kubeJob, computing its age in days since
+// completion, or since creation if the job has no completion time.
+func newKubeJob(j *batchv1.Job, now time.Time) kubeJob {
+	completionTime := jobAgedFrom(j)
+	daysOld := int(now.Sub(completionTime).Hours() / 24)
+	if daysOld < 0 {
+		fmt.Fprintf(stdout, "Job %s in namespace %s finished %v in the future, check for clock skew. Using an age of 0.\n", j.Metadata.GetName(), j.Metadata.GetNamespace(), completionTime.Sub(now))
+		daysOld = 0
+	}
+	return kubeJob{
+		name:            *j.Metadata.Name,
+		namespace:       *j.Metadata.Namespace,
+		age:             daysOld,
+		uid:             j.Metadata.GetUid(),
+		resourceVersion: j.Metadata.GetResourceVersion(),
+		reason:          ageReason(j),
+	}
+}
+
+// markedDaysAgo returns how many days ago the job was marked for deletion.
+// The second return value is false if the job isn't marked, and an error is
+// returned if the annotation is present but isn't a valid RFC3339 timestamp.
+func markedDaysAgo(job *batchv1.Job, now time.Time) (int, bool, error) {
+	val, ok := job.Metadata.GetAnnotations()[markedForDeletionAnnotation]
+	if !ok {
+		return 0, false, nil
+	}
+	markedAt, err := time.Parse(time.RFC3339, val)
+	if err != nil {
+		return 0, false, fmt.Errorf("Invalid %s annotation %q: %v", markedForDeletionAnnotation, val, err)
+	}
+	return int(now.Sub(markedAt).Hours() / 24), true, nil
+}
+
+// cronJobOwner returns the name of the CronJob owning the job, if any.
+func cronJobOwner(j *batchv1.Job) (string, bool) {
+	for _, ref := range j.Metadata.GetOwnerReferences() {
+		if ref.GetKind() == "CronJob" {
+			return ref.GetName(), true
+		}
+	}
+	return "", false
+}
+
+// newestCronJobJobs returns the "namespace/name" keys of the most recently
+// created job of each CronJob owning any of the jobs.
+func newestCronJobJobs(jobs []*batchv1.Job) map[string]bool {
+	newest := make(map[string]*batchv1.Job)
+	for _, j := range jobs {
+		owner, ok := cronJobOwner(j)
+		if !ok {
+			continue
+		}
+		key := j.Metadata.GetNamespace() + "/" + owner
+		cur, ok := newest[key]
+		if !ok || j.Metadata.GetCreationTimestamp().GetSeconds() > cur.Metadata.GetCreationTimestamp().GetSeconds() {
+			newest[key] = j
+		}
+	}
+	keys := make(map[string]bool, len(newest))
+	for _, j := range newest {
+		keys[j.Metadata.GetNamespace()+"/"+j.Metadata.GetName()] = true
+	}
+	return keys
+}
+
+// The "-delete-order" values.
+const (
+	orderOldestFirst = "oldest-first"
+	orderNewestFirst = "newest-first"
+)
+
+// sortJobs sorts jobs by age, oldest first unless order is orderNewestFirst.
+// Jobs of the same age are ordered by namespace and name to keep runs
+// deterministic.
+func sortJobs(jobs []kubeJob, order string) {
+	sort.Slice(jobs, func(i, j int) bool {
+		if jobs[i].age != jobs[j].age {
+			if order == orderNewestFirst {
+				return jobs[i].age < jobs[j].age
+			}
+			return jobs[i].age > jobs[j].age
+		}
+		if jobs[i].namespace != jobs[j].namespace {
+			return jobs[i].namespace < jobs[j].namespace
+		}
+		return jobs[i].name < jobs[j].name
+	})
+}
@@ -0,0 +1,712 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ericchiang/k8s"
+	apiv1 "github.com/ericchiang/k8s/api/v1"
+	batchv1 "github.com/ericchiang/k8s/apis/batch/v1"
+	metav1 "github.com/ericchiang/k8s/apis/meta/v1"
+	"github.com/golang/protobuf/proto"
+)
+
+// testNow is the time tests judge job ages at.
+var testNow = time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+
+func metaTime(t time.Time) *metav1.Time {
+	return &metav1.Time{Seconds: proto.Int64(t.Unix())}
+}
+
+// newTestJob returns a job created ten days before testNow, with an empty
+// status.
+func newTestJob(namespace, name string) *batchv1.Job {
+	return &batchv1.Job{
+		Metadata: &metav1.ObjectMeta{
+			Name:              k8s.String(name),
+			Namespace:         k8s.String(namespace),
+			Uid:               k8s.String(namespace + "-" + name),
+			ResourceVersion:   k8s.String("1"),
+			CreationTimestamp: metaTime(testNow.AddDate(0, 0, -10)),
+		},
+		Spec:   &batchv1.JobSpec{},
+		Status: &batchv1.JobStatus{},
+	}
+}
+
+// completedJob returns a job that succeeded the given number of days before
+// testNow.
+func completedJob(namespace, name string, daysAgo int) *batchv1.Job {
+	j := newTestJob(namespace, name)
+	done := testNow.AddDate(0, 0, -daysAgo)
+	j.Status.StartTime = metaTime(done.Add(-time.Minute))
+	j.Status.CompletionTime = metaTime(done)
+	j.Status.Succeeded = proto.Int32(1)
+	j.Status.Conditions = []*batchv1.JobCondition{{
+		Type:               k8s.String("Complete"),
+		Status:             k8s.String("True"),
+		LastTransitionTime: metaTime(done),
+	}}
+	return j
+}
+
+// testConfig returns the configuration of a dry run with the flag defaults.
+func testConfig() *runConfig {
+	return &runConfig{
+		olderThanDays:        1,
+		completionConditions: []string{"Complete", "Failed"},
+		completionStrategy:   strategyConditions,
+		reversedTimestamps:   reversedSkip,
+		jobLabels:            []string{"job-name"},
+	}
+}
+
+// continueMeta returns list metadata carrying the continue token, which the
+// client library's ListMeta doesn't have a field for.
+func continueMeta(token string) *metav1.ListMeta {
+	b := proto.NewBuffer(nil)
+	b.EncodeVarint(listMetaContinueField<<3 | proto.WireBytes)
+	b.EncodeStringBytes(token)
+	return &metav1.ListMeta{XXX_unrecognized: b.Bytes()}
+}
+
+func TestListAllJobsFollowsContinue(t *testing.T) {
+	api, client := newFakeAPI(t)
+	api.handle("GET", "/apis/batch/v1/namespaces/ns/jobs", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("limit") != "500" {
+			t.Errorf("limit = %q, want 500", q.Get("limit"))
+		}
+		switch q.Get("continue") {
+		case "":
+			writeObject(w, r, &batchv1.JobList{Metadata: continueMeta("second"), Items: []*batchv1.Job{newTestJob("ns", "a"), newTestJob("ns", "b")}})
+		case "second":
+			writeObject(w, r, &batchv1.JobList{Metadata: continueMeta("third"), Items: []*batchv1.Job{newTestJob("ns", "c")}})
+		case "third":
+			writeObject(w, r, &batchv1.JobList{Metadata: &metav1.ListMeta{}, Items: []*batchv1.Job{newTestJob("ns", "d")}})
+		default:
+			t.Errorf("unexpected continue token %q", q.Get("continue"))
+		}
+	})
+	jobs, err := listAllJobs(client, "ns", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, j := range jobs {
+		names = append(names, j.Metadata.GetName())
+	}
+	if want := []string{"a", "b", "c", "d"}; !equalStrings(names, want) {
+		t.Errorf("jobs = %v, want %v", names, want)
+	}
+	if n := api.count("GET", "/apis/batch/v1/namespaces/ns/jobs"); n != 3 {
+		t.Errorf("%d list requests, want 3", n)
+	}
+}
+
+func TestListAllJobsExpiredContinue(t *testing.T) {
+	api, client := newFakeAPI(t)
+	api.handle("GET", "/apis/batch/v1/namespaces/ns/jobs", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("continue") == "" {
+			writeObject(w, r, &batchv1.JobList{Metadata: continueMeta("stale"), Items: []*batchv1.Job{newTestJob("ns", "a")}})
+			return
+		}
+		writeStatus(w, r, http.StatusGone, "continue token expired")
+	})
+	if _, err := listAllJobs(client, "ns", ""); err == nil {
+		t.Fatal("expected an error for an expired continue token")
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMarkedDaysAgo(t *testing.T) {
+	j := completedJob("ns", "a", 5)
+	if _, marked, err := markedDaysAgo(j, testNow); marked || err != nil {
+		t.Errorf("unmarked job: marked = %v, err = %v", marked, err)
+	}
+	j.Metadata.Annotations = map[string]string{markedForDeletionAnnotation: testNow.AddDate(0, 0, -3).Format(time.RFC3339)}
+	days, marked, err := markedDaysAgo(j, testNow)
+	if !marked || err != nil || days != 3 {
+		t.Errorf("marked job: days = %d, marked = %v, err = %v, want 3, true, nil", days, marked, err)
+	}
+	j.Metadata.Annotations[markedForDeletionAnnotation] = "yesterday"
+	if _, _, err := markedDaysAgo(j, testNow); err == nil {
+		t.Error("expected an error for an invalid annotation")
+	}
+}
+
+func TestSweepMarkedGrace(t *testing.T) {
+	cfg := testConfig()
+	cfg.sweepMarked = true
+	cfg.markGraceDays = 2
+	tests := []struct {
+		name   string
+		marked string
+		want   bool
+	}{
+		{"unmarked", "", false},
+		{"marked within grace", testNow.AddDate(0, 0, -1).Format(time.RFC3339), false},
+		{"marked at grace", testNow.AddDate(0, 0, -2).Format(time.RFC3339), true},
+		{"marked long ago", testNow.AddDate(0, 0, -30).Format(time.RFC3339), true},
+		{"invalid mark", "soon", false},
+	}
+	for _, tt := range tests {
+		// The job itself is too young for -days, which doesn't apply.
+		j := completedJob("ns", "a", 0)
+		if tt.marked != "" {
+			j.Metadata.Annotations = map[string]string{markedForDeletionAnnotation: tt.marked}
+		}
+		kj, ok := eligibleJob(j, testNow, cfg, false)
+		if ok != tt.want {
+			t.Errorf("%s: eligible = %v, want %v", tt.name, ok, tt.want)
+		}
+		if ok && kj.reason != reasonMarked {
+			t.Errorf("%s: reason = %q, want %q", tt.name, kj.reason, reasonMarked)
+		}
+	}
+}
+
+func TestListJobsForbiddenNamespace(t *testing.T) {
+	api, client := newFakeAPI(t)
+	api.handle("GET", "/apis/batch/v1/namespaces/open/jobs", func(w http.ResponseWriter, r *http.Request) {
+		writeObject(w, r, &batchv1.JobList{Metadata: &metav1.ListMeta{}, Items: []*batchv1.Job{newTestJob("open", "a")}})
+	})
+	api.handle("GET", "/apis/batch/v1/namespaces/locked/jobs", func(w http.ResponseWriter, r *http.Request) {
+		writeStatus(w, r, http.StatusForbidden, "forbidden")
+	})
+	noSelector := func(string) string { return "" }
+	jobs, skipped, retried, err := listJobs(client, []string{"locked", "open"}, noSelector, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0].Metadata.GetNamespace() != "open" {
+		t.Errorf("jobs = %v, want the job in open", jobs)
+	}
+	if !equalStrings(skipped, []string{"locked"}) || len(retried) != 0 {
+		t.Errorf("skipped = %v, retried = %v, want [locked] and none", skipped, retried)
+	}
+	if n := api.count("GET", "/apis/batch/v1/namespaces/locked/jobs"); n != 1 {
+		t.Errorf("forbidden namespace listed %d times, want 1", n)
+	}
+}
+
+// cronJobRun returns a job of the CronJob created the given number of hours
+// before testNow.
+func cronJobRun(namespace, cronJob, name string, hoursAgo int) *batchv1.Job {
+	j := completedJob(namespace, name, 5)
+	j.Metadata.CreationTimestamp = metaTime(testNow.Add(-time.Duration(hoursAgo) * time.Hour))
+	j.Metadata.OwnerReferences = []*metav1.OwnerReference{{Kind: k8s.String("CronJob"), Name: k8s.String(cronJob)}}
+	return j
+}
+
+func TestNewestCronJobJobs(t *testing.T) {
+	jobs := []*batchv1.Job{
+		cronJobRun("ns", "nightly", "nightly-1", 72),
+		cronJobRun("ns", "nightly", "nightly-3", 24),
+		cronJobRun("ns", "nightly", "nightly-2", 48),
+		cronJobRun("other", "nightly", "nightly-0", 96),
+		cronJobRun("ns", "hourly", "hourly-1", 1),
+		completedJob("ns", "adhoc", 5),
+	}
+	got := newestCronJobJobs(jobs)
+	want := map[string]bool{"ns/nightly-3": true, "other/nightly-0": true, "ns/hourly-1": true}
+	if len(got) != len(want) {
+		t.Errorf("newest = %v, want %v", got, want)
+	}
+	for key := range want {
+		if !got[key] {
+			t.Errorf("%s isn't protected as the newest job of its CronJob", key)
+		}
+	}
+}
+
+func TestIncrementalLastRun(t *testing.T) {
+	cfg := testConfig()
+	cfg.lastRun = testNow.Add(-time.Hour)
+	tests := []struct {
+		name    string
+		doneAgo time.Duration
+		want    bool
+	}{
+		// A day old since half an hour, after the last run.
+		{"eligible since the last run", 24*time.Hour + 30*time.Minute, true},
+		{"eligible just before the last run", 25*time.Hour + time.Minute, false},
+		{"eligible days before the last run", 72 * time.Hour, false},
+		{"too young", 23 * time.Hour, false},
+	}
+	for _, tt := range tests {
+		j := completedJob("ns", "a", 0)
+		j.Status.StartTime = metaTime(testNow.Add(-tt.doneAgo - time.Minute))
+		j.Status.CompletionTime = metaTime(testNow.Add(-tt.doneAgo))
+		if _, ok := eligibleJob(j, testNow, cfg, false); ok != tt.want {
+			t.Errorf("%s: eligible = %v, want %v", tt.name, ok, tt.want)
+		}
+	}
+}
+
+func TestClockSkew(t *testing.T) {
+	tests := []struct {
+		name    string
+		skew    time.Duration
+		doneAgo time.Duration
+		want    bool
+	}{
+		{"past the threshold", time.Minute, 24*time.Hour + 2*time.Minute, true},
+		{"within the skew of the threshold", time.Minute, 24*time.Hour + 30*time.Second, false},
+		{"within the skew without -clock-skew", 0, 24*time.Hour + 30*time.Second, true},
+		// The cluster's clock is ahead of ours.
+		{"completed in the future", time.Minute, -30 * time.Second, false},
+	}
+	for _, tt := range tests {
+		cfg := testConfig()
+		cfg.clockSkew = tt.skew
+		done := testNow.Add(-tt.doneAgo)
+		j := completedJob("ns", "a", 0)
+		j.Status.StartTime = metaTime(done.Add(-time.Minute))
+		j.Status.CompletionTime = metaTime(done)
+		j.Status.Conditions[0].LastTransitionTime = metaTime(done)
+		kj, ok := eligibleJob(j, testNow, cfg, false)
+		if ok != tt.want {
+			t.Errorf("%s: eligible = %v, want %v", tt.name, ok, tt.want)
+		}
+		if kj.age < 0 {
+			t.Errorf("%s: negative age %d", tt.name, kj.age)
+		}
+	}
+}
+
+func TestCompleteRun(t *testing.T) {
+	cfg := testConfig()
+	cfg.deleteJobs = true
+	if !completeRun(cfg, &runSummary{JobsDeleted: 3}) {
+		t.Error("run deleting every eligible job isn't complete")
+	}
+	deferred := &runSummary{JobsDeleted: 3}
+	deferred.skip("deferred", 2)
+	if completeRun(cfg, deferred) {
+		t.Error("run deferring jobs with -max-per-run is complete")
+	}
+	if completeRun(cfg, &runSummary{Errors: 1}) {
+		t.Error("run with errors is complete")
+	}
+	cfg.deleteJobs = false
+	if completeRun(cfg, &runSummary{}) {
+		t.Error("dry-run is complete")
+	}
+}
+
+// captureStdout makes output go to a buffer for the rest of the test.
+func captureStdout(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	old := stdout
+	stdout = &buf
+	t.Cleanup(func() { stdout = old })
+	return &buf
+}
+
+func TestHelmHook(t *testing.T) {
+	out := captureStdout(t)
+	hook := func(daysAgo int) *batchv1.Job {
+		j := completedJob("ns", "migrate", daysAgo)
+		j.Metadata.Annotations = map[string]string{helmHookAnnotation: "pre-upgrade"}
+		return j
+	}
+	cfg := testConfig()
+	cfg.olderThanDays = 3
+	if _, ok := eligibleJob(hook(1), testNow, cfg, false); ok || out.Len() != 0 {
+		t.Errorf("young hook: eligible = %v, output %q, want neither", ok, out.String())
+	}
+	if _, ok := eligibleJob(hook(5), testNow, cfg, false); ok {
+		t.Error("old hook is eligible without -include-helm-hooks")
+	}
+	if !strings.Contains(out.String(), "is a Helm pre-upgrade hook, skipping") {
+		t.Errorf("old hook skipped without saying so, output %q", out.String())
+	}
+	cfg.includeHelmHooks = true
+	if _, ok := eligibleJob(hook(5), testNow, cfg, false); !ok {
+		t.Error("old hook isn't eligible with -include-helm-hooks")
+	}
+}
+
+// failedJob returns a job that failed the given number of days before testNow.
+func failedJob(namespace, name string, daysAgo int) *batchv1.Job {
+	j := completedJob(namespace, name, daysAgo)
+	j.Status.CompletionTime = nil
+	j.Status.Succeeded = nil
+	j.Status.Failed = proto.Int32(1)
+	j.Status.Conditions[0].Type = k8s.String("Failed")
+	return j
+}
+
+func TestMinCompletionsSucceeded(t *testing.T) {
+	withCounts := func(j *batchv1.Job, completions, succeeded, failed int32) *batchv1.Job {
+		if completions > 0 {
+			j.Spec.Completions = proto.Int32(completions)
+		}
+		j.Status.Succeeded = proto.Int32(succeeded)
+		j.Status.Failed = proto.Int32(failed)
+		return j
+	}
+	tests := []struct {
+		name          string
+		job           *batchv1.Job
+		includeFailed bool
+		want          bool
+	}{
+		{"succeeded, default completions", withCounts(completedJob("ns", "a", 5), 0, 1, 0), false, true},
+		{"succeeded after retries", withCounts(completedJob("ns", "a", 5), 3, 3, 2), false, true},
+		{"short of completions", withCounts(completedJob("ns", "a", 5), 3, 2, 0), false, false},
+		{"failed", withCounts(failedJob("ns", "a", 5), 3, 1, 6), false, false},
+		{"failed, -include-failed", withCounts(failedJob("ns", "a", 5), 3, 1, 6), true, true},
+		{"short of completions, -include-failed", withCounts(completedJob("ns", "a", 5), 3, 2, 0), true, false},
+	}
+	for _, tt := range tests {
+		cfg := testConfig()
+		cfg.ageFallback = true
+		cfg.minCompletionsSucceeded = true
+		cfg.includeFailed = tt.includeFailed
+		if _, ok := eligibleJob(tt.job, testNow, cfg, false); ok != tt.want {
+			t.Errorf("%s: eligible = %v, want %v", tt.name, ok, tt.want)
+		}
+	}
+}
+
+func TestStaleActiveJob(t *testing.T) {
+	stuck := func() *batchv1.Job {
+		j := newTestJob("ns", "stuck")
+		j.Status.Active = proto.Int32(1)
+		j.Status.StartTime = j.Metadata.CreationTimestamp
+		return j
+	}
+	tests := []struct {
+		name   string
+		active int32
+		phases []string
+		stale  bool
+	}{
+		{"no live pods", 1, nil, true},
+		{"only finished pods", 1, []string{"Failed", "Succeeded"}, true},
+		{"running pod", 1, []string{"Failed", "Running"}, false},
+		{"not active", 0, nil, false},
+	}
+	for _, tt := range tests {
+		j := stuck()
+		j.Status.Active = proto.Int32(tt.active)
+		var pods []*apiv1.Pod
+		for i, phase := range tt.phases {
+			pods = append(pods, testPod(j, fmt.Sprintf("stuck-%d", i), phase))
+		}
+		api, client := newFakeAPI(t)
+		api.servePods("ns", pods...)
+		stale, err := staleActiveJob(client, j, testConfig())
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if stale != tt.stale {
+			t.Errorf("%s: stale = %v, want %v", tt.name, stale, tt.stale)
+		}
+	}
+
+	// A stale job is judged as finished, by its creation time.
+	kj, ok := eligibleJob(stuck(), testNow, testConfig(), true)
+	if !ok || kj.reason != reasonStaleActive || !kj.staleActive {
+		t.Errorf("Stale active job: eligible = %v, reason %q, want eligible as %q", ok, kj.reason, reasonStaleActive)
+	}
+	if _, ok := eligibleJob(stuck(), testNow, testConfig(), false); ok {
+		t.Error("Active job eligible without being stale")
+	}
+}
+
+func TestReversedTimestamps(t *testing.T) {
+	out := captureStdout(t)
+	reversedJob := func(startedAgo time.Duration) *batchv1.Job {
+		j := completedJob("ns", "skewed", 6)
+		j.Status.StartTime = metaTime(testNow.Add(-startedAgo))
+		return j
+	}
+	tests := []struct {
+		name    string
+		mode    string
+		started time.Duration
+		want    bool
+		age     int
+	}{
+		{"skipped", reversedSkip, 5 * 24 * time.Hour, false, 0},
+		{"aged from the start", reversedStartTime, 5 * 24 * time.Hour, true, 5},
+		// The completion time is old but the job started recently.
+		{"started recently", reversedStartTime, 12 * time.Hour, false, 0},
+	}
+	for _, tt := range tests {
+		cfg := testConfig()
+		cfg.reversedTimestamps = tt.mode
+		kj, ok := eligibleJob(reversedJob(tt.started), testNow, cfg, false)
+		if ok != tt.want || kj.age != tt.age {
+			t.Errorf("%s: eligible = %v, age %d, want %v and %d", tt.name, ok, kj.age, tt.want, tt.age)
+		}
+	}
+	if !strings.Contains(out.String(), "Job skewed in namespace ns completed at 2024-03-09T12:00:00Z, before it started at 2024-03-10T12:00:00Z.") {
+		t.Errorf("Reversed timestamps not reported, output: %q", out.String())
+	}
+	if _, ok := eligibleJob(completedJob("ns", "fine", 6), testNow, testConfig(), false); !ok {
+		t.Error("Job with ordered timestamps not eligible")
+	}
+}
+
+func TestSortJobs(t *testing.T) {
+	jobs := func() []kubeJob {
+		return []kubeJob{
+			{namespace: "b", name: "x", age: 3},
+			{namespace: "a", name: "y", age: 9},
+			{namespace: "b", name: "w", age: 3},
+			{namespace: "a", name: "z", age: 3},
+			{namespace: "c", name: "v", age: 1},
+		}
+	}
+	keys := func(jobs []kubeJob) []string {
+		var keys []string
+		for _, kj := range jobs {
+			keys = append(keys, kj.namespace+"/"+kj.name)
+		}
+		return keys
+	}
+	tests := []struct {
+		order string
+		want  []string
+	}{
+		{orderOldestFirst, []string{"a/y", "a/z", "b/w", "b/x", "c/v"}},
+		// Ties are still broken by namespace and name.
+		{orderNewestFirst, []string{"c/v", "a/z", "b/w", "b/x", "a/y"}},
+	}
+	for _, tt := range tests {
+		sorted := jobs()
+		sortJobs(sorted, tt.order)
+		if got := keys(sorted); !equalStrings(got, tt.want) {
+			t.Errorf("%s: %v, want %v", tt.order, got, tt.want)
+		}
+	}
+}
+
+func TestFinishedConditionCustomNames(t *testing.T) {
+	j := newTestJob("ns", "custom")
+	metAt := testNow.AddDate(0, 0, -3)
+	j.Status.Conditions = []*batchv1.JobCondition{
+		{Type: k8s.String("Suspended"), Status: k8s.String("True"), LastTransitionTime: metaTime(testNow.AddDate(0, 0, -5))},
+		{Type: k8s.String("SuccessCriteriaMet"), Status: k8s.String("False"), LastTransitionTime: metaTime(testNow.AddDate(0, 0, -4))},
+		{Type: k8s.String("SuccessCriteriaMet"), Status: k8s.String("True"), LastTransitionTime: metaTime(metAt)},
+	}
+	tests := []struct {
+		conditions []string
+		want       *time.Time
+	}{
+		{[]string{"Complete", "Failed"}, nil},
+		{[]string{"SuccessCriteriaMet"}, &metAt},
+		// Condition names are case sensitive, as in the API.
+		{[]string{"successcriteriamet"}, nil},
+	}
+	for _, tt := range tests {
+		c := finishedCondition(j, tt.conditions)
+		if (c == nil) != (tt.want == nil) || (c != nil && c.GetLastTransitionTime().GetSeconds() != tt.want.Unix()) {
+			t.Errorf("%v: condition %v, want one at %v", tt.conditions, c, tt.want)
+		}
+	}
+
+	// The age counts from the custom condition.
+	cfg := testConfig()
+	cfg.completionConditions = []string{"SuccessCriteriaMet"}
+	cfg.conditionAge = true
+	if kj, ok := eligibleJob(j, testNow, cfg, false); !ok || kj.age != 3 {
+		t.Errorf("eligible = %v, age %d, want eligible at 3 days", ok, kj.age)
+	}
+	cfg.completionConditions = []string{"Complete"}
+	if _, ok := eligibleJob(j, testNow, cfg, false); ok {
+		t.Error("Job eligible without any of the -completion-conditions")
+	}
+}
+
+func TestMaxAge(t *testing.T) {
+	cfg := testConfig()
+	cfg.olderThanDays = 2
+	cfg.maxAgeDays = 5
+	tests := []struct {
+		daysAgo int
+		want    bool
+	}{
+		{1, false},
+		{2, true},
+		{5, true},
+		{6, false},
+		{400, false},
+	}
+	for _, tt := range tests {
+		if _, ok := eligibleJob(completedJob("ns", "a", tt.daysAgo), testNow, cfg, false); ok != tt.want {
+			t.Errorf("%d days old: eligible = %v, want %v", tt.daysAgo, ok, tt.want)
+		}
+	}
+	// Marked jobs are kept as well when they're too old.
+	cfg.sweepMarked = true
+	j := completedJob("ns", "marked", 6)
+	j.Metadata.Annotations = map[string]string{markedForDeletionAnnotation: testNow.AddDate(0, 0, -6).Format(time.RFC3339)}
+	if _, ok := eligibleJob(j, testNow, cfg, false); ok {
+		t.Error("Marked job older than -max-age eligible")
+	}
+}
+
+func TestEmptyJobStatus(t *testing.T) {
+	fresh := newTestJob("ns", "fresh")
+	noStatus := newTestJob("ns", "no-status")
+	noStatus.Status = nil
+	started := newTestJob("ns", "started")
+	started.Status.StartTime = started.Metadata.CreationTimestamp
+	tests := []struct {
+		name  string
+		job   *batchv1.Job
+		empty bool
+	}{
+		{"fresh", fresh, true},
+		{"no status", noStatus, true},
+		{"started", started, false},
+		{"completed", completedJob("ns", "done", 1), false},
+	}
+	for _, tt := range tests {
+		if got := emptyJobStatus(tt.job); got != tt.empty {
+			t.Errorf("%s: empty = %v, want %v", tt.name, got, tt.empty)
+		}
+	}
+
+	// Even ten days after its creation, a job the controller hasn't looked
+	// at isn't aged from its creation time.
+	for _, strategy := range []string{strategyConditions, strategyCounts} {
+		cfg := testConfig()
+		cfg.ageFallback = true
+		cfg.completionStrategy = strategy
+		if _, ok := eligibleJob(fresh, testNow, cfg, false); ok {
+			t.Errorf("%s: job with an empty status eligible", strategy)
+		}
+	}
+	cfg := testConfig()
+	cfg.ageFallback = true
+	if _, ok := eligibleJob(started, testNow, cfg, false); !ok {
+		t.Error("Started job not eligible with -age-fallback")
+	}
+}
+
+// countedJob returns a job without conditions or a completion time, as very
+// old clusters leave them, with the given pod counts. A negative limit leaves
+// the backoff limit unset.
+func countedJob(name string, active, succeeded, failed int32, limit int) *batchv1.Job {
+	j := newTestJob("ns", name)
+	j.Status.StartTime = metaTime(testNow.AddDate(0, 0, -5))
+	j.Status.Active = proto.Int32(active)
+	j.Status.Succeeded = proto.Int32(succeeded)
+	j.Status.Failed = proto.Int32(failed)
+	if limit >= 0 {
+		b := proto.NewBuffer(nil)
+		b.EncodeVarint(jobSpecBackoffLimitField<<3 | proto.WireVarint)
+		b.EncodeVarint(uint64(limit))
+		j.Spec.XXX_unrecognized = b.Bytes()
+	}
+	return j
+}
+
+func TestBackoffLimit(t *testing.T) {
+	if got := backoffLimit(countedJob("a", 0, 0, 0, -1)); got != defaultBackoffLimit {
+		t.Errorf("unset limit = %d, want %d", got, defaultBackoffLimit)
+	}
+	// Newer fields around it are skipped.
+	b := proto.NewBuffer(nil)
+	b.EncodeVarint(9<<3 | proto.WireBytes)
+	b.EncodeStringBytes("NonIndexed")
+	b.EncodeVarint(jobSpecBackoffLimitField<<3 | proto.WireVarint)
+	b.EncodeVarint(0)
+	b.EncodeVarint(10<<3 | proto.WireVarint)
+	b.EncodeVarint(1)
+	j := newTestJob("ns", "a")
+	j.Spec.XXX_unrecognized = b.Bytes()
+	if got := backoffLimit(j); got != 0 {
+		t.Errorf("limit = %d, want 0", got)
+	}
+	j.Spec.XXX_unrecognized = []byte{jobSpecBackoffLimitField<<3 | proto.WireVarint}
+	if got := backoffLimit(j); got != defaultBackoffLimit {
+		t.Errorf("truncated limit = %d, want %d", got, defaultBackoffLimit)
+	}
+}
+
+func TestCompletionStrategies(t *testing.T) {
+	captureStdout(t)
+	tests := []struct {
+		name     string
+		job      *batchv1.Job
+		strategy string
+		// noConditions is whether no job of the run has conditions.
+		noConditions bool
+		want         bool
+	}{
+		{"succeeded", countedJob("a", 0, 1, 0, -1), strategyConditions, true, true},
+		{"succeeded on a cluster with conditions", countedJob("a", 0, 1, 0, -1), strategyConditions, false, false},
+		{"retrying", countedJob("a", 0, 0, 1, -1), strategyConditions, true, false},
+		{"past the default limit", countedJob("a", 0, 0, 7, -1), strategyConditions, true, true},
+		{"past its limit", countedJob("a", 0, 0, 3, 2), strategyConditions, true, true},
+		{"past its limit on a cluster with conditions", countedJob("a", 0, 0, 3, 2), strategyConditions, false, false},
+		{"active", countedJob("a", 1, 1, 0, -1), strategyConditions, true, false},
+		{"completed with conditions", completedJob("ns", "a", 5), strategyConditions, false, true},
+		{"counts succeeded", countedJob("a", 0, 1, 0, -1), strategyCounts, false, true},
+		{"counts retrying", countedJob("a", 0, 0, 1, -1), strategyCounts, false, false},
+		{"counts at its limit", countedJob("a", 0, 0, 2, 2), strategyCounts, false, false},
+		{"counts past its limit", countedJob("a", 0, 0, 3, 2), strategyCounts, false, true},
+		{"counts active", countedJob("a", 1, 1, 0, -1), strategyCounts, false, false},
+		{"counts completed with conditions", completedJob("ns", "a", 5), strategyCounts, false, true},
+	}
+	for _, tt := range tests {
+		cfg := testConfig()
+		cfg.completionStrategy = tt.strategy
+		cfg.noConditions = tt.noConditions
+		if _, ok := eligibleJob(tt.job, testNow, cfg, false); ok != tt.want {
+			t.Errorf("%s: eligible = %v, want %v", tt.name, ok, tt.want)
+		}
+		if got := jobDone(tt.job, cfg); got != tt.want {
+			t.Errorf("%s: done = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNoJobConditions(t *testing.T) {
+	conditionless := countedJob("a", 0, 1, 0, -1)
+	if noJobConditions(nil) {
+		t.Error("No jobs, but judged as a cluster without conditions")
+	}
+	if !noJobConditions([]*batchv1.Job{conditionless, countedJob("b", 0, 0, 1, -1)}) {
+		t.Error("Jobs without conditions, but judged as a cluster with them")
+	}
+	if noJobConditions([]*batchv1.Job{conditionless, completedJob("ns", "b", 5)}) {
+		t.Error("A job has conditions, but judged as a cluster without them")
+	}
+
+	// The backoff limit survives decoding, and -job-list judges the fetched
+	// jobs together.
+	api, client := newFakeAPI(t)
+	api.serveJobs(countedJob("failed", 0, 0, 3, 2), countedJob("retrying", 0, 0, 1, 2))
+	captureStdout(t)
+	cfg := testConfig()
+	jobs, err := resolveJobList(client, []kubeJob{{namespace: "ns", name: "failed"}, {namespace: "ns", name: "retrying"}}, testNow, cfg, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.noConditions || len(jobs) != 1 || jobs[0].name != "failed" {
+		t.Errorf("noConditions = %v, resolved jobs = %+v, want only failed", cfg.noConditions, jobs)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/ericchiang/k8s"
+)
+
+// savePodLogs writes the logs of the pod to cfg.saveLogsDir if its phase is one
+// of cfg.saveLogsPhases. Logs are written to "namespace/pod.log", or one
+// "namespace/pod.container.log" file per container for multi-container pods.
+func savePodLogs(client *k8s.Client, kp kubePod, cfg *runConfig) error {
+	if cfg.saveLogsDir == "" || !cfg.deleteJobs || !containsString(cfg.saveLogsPhases, kp.phase) {
+		return nil
+	}
+	dir := filepath.Join(cfg.saveLogsDir, kp.namespace)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, c := range kp.containers {
+		path := fmt.Sprintf("/api/v1/namespaces/%s/pods/%s/log?container=%s", kp.namespace, kp.name, url.QueryEscape(c))
+		countAPICall(apiGet)
+		logs, err := rawRequest(context.Background(), client, "GET", path, nil)
+		if err != nil {
+			return fmt.Errorf("Failed to get logs of container %s: %v", c, err)
+		}
+		file := kp.name + ".log"
+		if len(kp.containers) > 1 {
+			file = kp.name + "." + c + ".log"
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, file), logs, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ericchiang/k8s"
+	apiv1 "github.com/ericchiang/k8s/api/v1"
+)
+
+// serveLogs serves the logs of the pod's containers as "<container> logs".
+func (f *fakeAPI) serveLogs(namespace, pod string) {
+	f.handle("GET", podsPath(namespace)+"/"+pod+"/log", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Query().Get("container") + " logs"))
+	})
+}
+
+func TestSavePodLogs(t *testing.T) {
+	api, client := newFakeAPI(t)
+	api.serveLogs("ns", "single")
+	api.serveLogs("ns", "multi")
+	cfg := testConfig()
+	cfg.deleteJobs = true
+	cfg.saveLogsDir = t.TempDir()
+	cfg.saveLogsPhases = []string{"Failed"}
+
+	pods := []kubePod{
+		{name: "single", namespace: "ns", phase: "Failed", containers: []string{"main"}},
+		{name: "multi", namespace: "ns", phase: "Failed", containers: []string{"main", "sidecar"}},
+		{name: "succeeded", namespace: "ns", phase: "Succeeded", containers: []string{"main"}},
+	}
+	for _, kp := range pods {
+		if err := savePodLogs(client, kp, cfg); err != nil {
+			t.Fatalf("Pod %s: %v", kp.name, err)
+		}
+	}
+	for file, want := range map[string]string{
+		"single.log":         "main logs",
+		"multi.main.log":     "main logs",
+		"multi.sidecar.log":  "sidecar logs",
+		"succeeded.log":      "",
+		"succeeded.main.log": "",
+	} {
+		got, err := ioutil.ReadFile(filepath.Join(cfg.saveLogsDir, "ns", file))
+		if want == "" {
+			if !os.IsNotExist(err) {
+				t.Errorf("%s saved for a phase not in -save-logs-phases", file)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", file, err)
+		} else if string(got) != want {
+			t.Errorf("%s = %q, want %q", file, got, want)
+		}
+	}
+	if n := api.count("GET", podsPath("ns")+"/succeeded/log"); n != 0 {
+		t.Errorf("%d log requests for a pod whose logs aren't saved", n)
+	}
+
+	cfg.deleteJobs = false
+	if err := savePodLogs(client, kubePod{name: "dry", namespace: "ns", phase: "Failed", containers: []string{"main"}}, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if n := api.count("GET", podsPath("ns")+"/dry/log"); n != 0 {
+		t.Errorf("%d log requests in dry-run", n)
+	}
+}
+
+func TestSavePodLogsErrorKeepsPod(t *testing.T) {
+	j := completedJob("ns", "backup", 5)
+	p := testPod(j, "backup-1", "Failed")
+	p.Spec.Containers = []*apiv1.Container{{Name: k8s.String("main")}}
+	api, client := newFakeAPI(t)
+	api.serveJobs(j)
+	api.servePods("ns", p)
+	api.handle("GET", podsPath("ns")+"/backup-1/log", func(w http.ResponseWriter, r *http.Request) {
+		writeStatus(w, r, http.StatusInternalServerError, "logs unavailable")
+	})
+	cfg := testConfig()
+	cfg.deleteJobs = true
+	cfg.saveLogsDir = t.TempDir()
+	cfg.saveLogsPhases = []string{"Failed"}
+	out := captureStdout(t)
+	sum := &runSummary{}
+	cleanupJobs(client, []kubeJob{newKubeJob(j, testNow)}, cfg, sum, 1)
+	if api.count("DELETE", podsPath("ns")+"/backup-1") != 0 {
+		t.Error("Pod deleted although its logs couldn't be saved")
+	}
+	if sum.Errors == 0 || sum.PodsDeleted != 0 {
+		t.Errorf("%d errors and %d pods deleted, want an error and no pods deleted", sum.Errors, sum.PodsDeleted)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.saveLogsDir, "ns", "backup-1.log")); !os.IsNotExist(err) {
+		t.Errorf("Log file written for a failed log request: %v", err)
+	}
+	if !strings.Contains(out.String(), "Unable to save logs of pod backup-1, not deleting it.") {
+		t.Errorf("Output doesn't report the failed log request:\n%s", out.String())
+	}
+}
@@ -1,23 +1,76 @@
 package main
 
 import (
-	"context"
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
+	"strings"
+	"sync/atomic"
+	"text/template"
 	"time"
 
 	"github.com/ericchiang/k8s"
-	"github.com/ghodss/yaml"
+	apiv1 "github.com/ericchiang/k8s/api/v1"
+	batchv1 "github.com/ericchiang/k8s/apis/batch/v1"
+)
+
+var (
+	// debug enables the "-debug" output.
+	debug bool
+	// apiCalls counts the requests made to the Kubernetes API during the run
+	// by verb. Only access it atomically.
+	apiCalls [numAPIVerbs]int64
+)
+
+// apiVerb is the kind of a request made to the Kubernetes API.
+type apiVerb int
+
+const (
+	apiList apiVerb = iota
+	apiGet
+	apiDelete
+	apiCreate
+	apiUpdate
+	numAPIVerbs
 )
 
 type kubePod struct {
-	name      string
-	namespace string
-	phase     string
+	name        string
+	namespace   string
+	age         int
+	started     time.Time
+	phase       string
+	labels      map[string]string
+	annotations map[string]string
+	containers  []string
+	// restarts is the sum of the restart counts of all containers.
+	restarts int32
+	// deadNode is set for pods on a node that no longer exists.
+	deadNode bool
 }
 
+func newKubePod(p *apiv1.Pod) kubePod {
+	kp := kubePod{
+		name:        p.Metadata.GetName(),
+		namespace:   p.Metadata.GetNamespace(),
+		age:         podDaysOld(p, time.Now()),
+		started:     podStartTime(p),
+		phase:       p.Status.GetPhase(),
+		labels:      p.Metadata.GetLabels(),
+		annotations: p.Metadata.GetAnnotations(),
+	}
+	for _, c := range p.Spec.GetContainers() {
+		kp.containers = append(kp.containers, c.GetName())
+	}
+	for _, cs := range p.Status.GetContainerStatuses() {
+		kp.restarts += cs.GetRestartCount()
+	}
+	return kp
+}
+
+// kubeJobSet groups pods by the job they belong to, keyed by "namespace/name"
+// so jobs with the same name in different namespaces are kept apart.
 type kubeJobSet map[string][]kubePod
 
 type kubeJob struct {
@@ -25,249 +78,1009 @@ type kubeJob struct {
 	namespace string
 	age       int
 	pods      []kubePod
+	// uid and resourceVersion identify the exact object that was selected,
+	// so a job recreated with the same name isn't deleted by mistake.
+	uid             string
+	resourceVersion string
+	// staleActive is set for jobs counted as active without any live pods,
+	// which are cleaned up without a "Complete" or "Failed" condition.
+	staleActive bool
+	// forced is set for jobs listed with -stdin -force, which are cleaned up
+	// whether or not they finished.
+	forced bool
+	// finalizerTracked is set for jobs annotated as tracked with pod
+	// finalizers.
+	finalizerTracked bool
+	// reason is why the job is deleted.
+	reason deletionReason
+}
+
+// runConfig holds the flag values that control how eligible jobs and pods are
+// cleaned up.
+type runConfig struct {
+	// deleteJobs actually deletes jobs and pods instead of listing them.
+	deleteJobs bool
+	// olderThanDays is the age threshold for jobs to be eligible.
+	olderThanDays int
+	// clockSkew is subtracted from job ages before comparing them to the
+	// threshold, to tolerate clocks that aren't quite in sync.
+	clockSkew time.Duration
+	// labelThresholds override olderThanDays for jobs with matching labels.
+	labelThresholds []labelThreshold
+	// sweepMarked selects jobs marked for deletion at least markGraceDays ago
+	// instead of using olderThanDays.
+	sweepMarked   bool
+	markGraceDays int
+	// ageFallback computes the age of jobs without a completion time from
+	// their creation time instead of skipping them.
+	ageFallback bool
+	// lastRun is the time of the last successful run with "-incremental".
+	// Jobs that were already eligible then are skipped. Zero disables this.
+	lastRun time.Time
+	// minCompletionsSucceeded only considers jobs with at least as many
+	// succeeded pods as required completions, keeping failed jobs unless
+	// includeFailed is set.
+	minCompletionsSucceeded bool
+	includeFailed           bool
+	// includeHelmHooks also considers jobs run as Helm hooks.
+	includeHelmHooks bool
+	// verifyCompletion re-fetches each job before cleaning it up and skips it
+	// unless it has finished.
+	verifyCompletion bool
+	// deleteJobsFirst deletes jobs with background propagation instead of
+	// listing and deleting their pods, falling back to explicit pod deletion
+	// if that fails.
+	deleteJobsFirst bool
+	// waitFinalizers is how long to wait for deleted jobs to disappear,
+	// reporting those held by finalizers. Zero doesn't wait.
+	waitFinalizers time.Duration
+	// selector is a label selector restricting the jobs considered.
+	selector string
+	// policy holds per-namespace rules overriding the flags, if set.
+	policy *policy
+	// checkpoint records the deleted jobs, if "-checkpoint" is set.
+	checkpoint *checkpoint
+	// report collects the printed items, if "-report-json" is set.
+	report *itemRecorder
+	// orphanSelector restricts the pods considered by the orphan scan.
+	orphanSelector string
+	// jobLabels are the pod label keys whose value names the pod's job.
+	jobLabels []string
+	// reapPods deletes the finished pods of jobs younger than olderThanDays if
+	// the pods are at least podDays old.
+	reapPods bool
+	podDays  int
+	// reapRunning deletes the counted succeeded pods of active jobs if they
+	// are at least podDays old.
+	reapRunning bool
+	// deleteConfigMaps and deleteSecrets delete the ConfigMaps and Secrets
+	// labelled with the name of a deleted job.
+	deleteConfigMaps bool
+	deleteSecrets    bool
+	// reversedTimestamps is what to do with jobs that completed before they
+	// started, reversedSkip or reversedStartTime.
+	reversedTimestamps string
+	// explain prints the checks made for each job and their verdict.
+	explain bool
+	// handleDeadNodes force deletes the unfinished pods of cleaned up jobs
+	// that are on nodes that no longer exist.
+	handleDeadNodes bool
+	// reportRestarts reports the container restarts of each cleaned up job's
+	// pods.
+	reportRestarts bool
+	// noPodScan lists eligible jobs in dry-run without listing their pods.
+	noPodScan bool
+	// excludeAnnotations skips jobs matching all of them.
+	excludeAnnotations annotationExcludes
+	// progressEvery reports the deletion progress of jobs with many pods
+	// every this many pods, if not zero.
+	progressEvery int
+	// jobPropagation and podPropagation are the propagation policies of job
+	// and pod deletes. An empty jobPropagation leaves it to the API server,
+	// except with deleteJobsFirst, which needs Background.
+	jobPropagation string
+	podPropagation string
+	// maxAgeDays keeps jobs older than this many days, if not zero.
+	maxAgeDays int
+	// completionConditions are the job condition types that mark a job as
+	// done. With conditionAge, set if they were given explicitly, jobs also
+	// need one of them to be eligible and their age counts from it.
+	completionConditions []string
+	conditionAge         bool
+	// completionStrategy is how finished jobs are told apart, by their
+	// conditions or their pod counts.
+	completionStrategy string
+	// noConditions is set when none of the jobs of the run have any
+	// conditions, as on very old clusters. The conditions strategy then
+	// judges them by their pod counts.
+	noConditions bool
+	// orphanPhases are the phases of orphaned pods that are deleted.
+	orphanPhases map[string]bool
+	// explainOrphans prints the ownerReference chain of each orphaned pod.
+	explainOrphans bool
+	// owner checks whether the owner of a possibly orphaned pod exists.
+	owner ownerChecker
+	// ownerUIDVerify only counts an owner as existing if its UID matches the
+	// pod's owner reference.
+	ownerUIDVerify bool
+	// saveLogsDir is where logs of pods in one of saveLogsPhases are written
+	// before the pods are deleted. Empty disables saving logs.
+	saveLogsDir    string
+	saveLogsPhases []string
+	// orphanKeepAnnotation and orphanMinAge keep orphaned pods carrying the
+	// annotation or started less than orphanMinAge ago.
+	orphanKeepAnnotation string
+	orphanMinAge         time.Duration
+	// forceTerminate deletes pods still terminating forceTerminateAfter
+	// after they were deleted again without a grace period.
+	forceTerminate      bool
+	forceTerminateAfter time.Duration
+	// ignorePDB deletes pods that aren't finished even if that violates a
+	// PodDisruptionBudget.
+	ignorePDB bool
+	// template formats job and pod lines if set.
+	template *template.Template
+	// logFields writes every line as a logfmt record with the run ID and, for
+	// job and pod lines, the namespace and job as fields.
+	logFields bool
+	// printKubectl prints the kubectl command equivalent to each deletion.
+	printKubectl bool
+	// failFast stops the run at the first error instead of continuing with
+	// the remaining jobs and pods.
+	failFast bool
+	// onlyDeletable suppresses dry-run output for jobs and pods that won't be
+	// deleted.
+	onlyDeletable bool
+}
+
+// completeRun reports whether the run deleted every job it found eligible, so
+// "-incremental" may move its mark forward. Otherwise jobs that failed or were
+// deferred by "-max-per-run" would never be retried.
+func completeRun(c *runConfig, sum *runSummary) bool {
+	return c.deleteJobs && sum.Errors == 0 && sum.Skipped["deferred"] == 0 && !interrupted()
+}
+
+// stopped reports whether the run must stop because of an error with
+// "-fail-fast".
+func (c *runConfig) stopped(sum *runSummary) bool {
+	return interrupted() || c.failFast && sum.Errors > 0
+}
+
+// progress reports every c.progressEvery deletions how many of total pods
+// were deleted. Jobs with fewer pods than that aren't reported.
+func (c *runConfig) progress(w io.Writer, deleted, total int, job string) {
+	if c.progressEvery > 0 && total > c.progressEvery && deleted%c.progressEvery == 0 {
+		fmt.Fprintf(w, "\tDeleted %d/%d pods of job %s\n", deleted, total, job)
+	}
+}
+
+// quiet reports whether informational dry-run output should be suppressed.
+func (c *runConfig) quiet() bool {
+	return !c.deleteJobs && c.onlyDeletable
 }
 
 func (js kubeJobSet) Add(job string, pod kubePod) {
-	_, ok := js[job]
+	key := pod.namespace + "/" + job
+	_, ok := js[key]
 	if !ok {
-		js[job] = make([]kubePod, 0, 20)
+		js[key] = make([]kubePod, 0, 20)
 	}
-	js[job] = append(js[job], pod)
+	js[key] = append(js[key], pod)
 }
 
-func loadClient(kubeconfigPath, kubeContext string, inCluster bool) (*k8s.Client, error) {
-	if inCluster {
-		client, err := k8s.NewInClusterClient()
-		if err != nil {
-			return nil, fmt.Errorf("Failed to create in-cluster client: %v", err)
+// splitJobKey splits a "namespace/name" kubeJobSet key.
+func splitJobKey(key string) (namespace, name string) {
+	parts := strings.SplitN(key, "/", 2)
+	return parts[0], parts[1]
+}
+
+// flagSet reports whether the named flag was set on the command line.
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
 		}
-		return client, nil
-	}
-	data, err := ioutil.ReadFile(kubeconfigPath)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to read kubeconfig: %v", err)
-	}
+	})
+	return set
+}
 
-	// Unmarshal YAML into a Kubernetes config object.
-	var config k8s.Config
-	if err := yaml.Unmarshal(data, &config); err != nil {
-		return nil, fmt.Errorf("Failed to unmarshal kubeconfig: %v", err)
-	}
-	if kubeContext != "" {
-		config.CurrentContext = kubeContext
+// splitList splits a comma-separated flag value, dropping empty elements.
+func splitList(val string) []string {
+	var list []string
+	for _, v := range strings.Split(val, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
 	}
-	return k8s.NewClient(&config)
+	return list
 }
 
-func getOrphanedPods(client *k8s.Client, kubeNamespace string) ([]kubeJob, error) {
-	var opJobs []kubeJob
-	opJobSet := make(kubeJobSet)
-	pods, podErr := client.CoreV1().ListPods(context.Background(), kubeNamespace)
-	if podErr != nil {
-		return nil, fmt.Errorf("ERROR: %s.", podErr.Error())
-	} else {
-		if len(pods.Items) > 0 {
-			for _, p := range pods.Items {
-				pl := p.Metadata.GetLabels()
-				if val, ok := pl["job-name"]; ok {
-					jobCheck, err := client.BatchV1().GetJob(context.Background(), val, *p.Metadata.Namespace)
-					if err != nil {
-						if apiErr, ok := err.(*k8s.APIError); ok {
-							if apiErr.Code == 404 {
-								jobCheck = nil
-							}
-						} else {
-							return nil, fmt.Errorf("Error getting job: %s", err.Error())
-						}
-					}
-					if jobCheck == nil {
-						kp := kubePod{name: p.Metadata.GetName(), namespace: p.Metadata.GetNamespace(), phase: p.Status.GetPhase()}
-						opJobSet.Add(val, kp)
-					}
-				}
-			}
-		} else {
-			return nil, fmt.Errorf("Unable to find any pods.")
-		}
+// isNotFound reports whether err is a 404 response from the API server.
+func isNotFound(err error) bool {
+	apiErr, ok := err.(*k8s.APIError)
+	return ok && apiErr.Code == 404
+}
+
+// isForbidden reports whether err is a 403 response from the API server.
+func isForbidden(err error) bool {
+	apiErr, ok := err.(*k8s.APIError)
+	return ok && apiErr.Code == 403
+}
+
+// isGone reports whether err is a 410 response from the API server, as
+// returned for an expired continue token.
+func isGone(err error) bool {
+	apiErr, ok := err.(*k8s.APIError)
+	return ok && apiErr.Code == 410
+}
+
+// countAPICall records a request made to the Kubernetes API.
+func countAPICall(verb apiVerb) {
+	atomic.AddInt64(&apiCalls[verb], 1)
+}
+
+// apiCallCount returns the number of requests made with the verb.
+func apiCallCount(verb apiVerb) int {
+	return int(atomic.LoadInt64(&apiCalls[verb]))
+}
+
+// totalAPICalls returns the number of requests made to the Kubernetes API.
+func totalAPICalls() int {
+	total := 0
+	for v := apiVerb(0); v < numAPIVerbs; v++ {
+		total += apiCallCount(v)
 	}
-	for k, v := range opJobSet {
-		opJobs = append(opJobs, kubeJob{name: k, namespace: kubeNamespace, age: 0, pods: v})
+	return total
+}
+
+func debugf(format string, a ...interface{}) {
+	fdebugf(stdout, format, a...)
+}
+
+// fdebugf is like debugf but writes to w.
+func fdebugf(w io.Writer, format string, a ...interface{}) {
+	if debug {
+		fmt.Fprintf(w, "DEBUG: "+format, a...)
 	}
-	return opJobs, nil
 }
 
 func main() {
+	start := time.Now()
 	kubeconfigPath := flag.String("kubeconfig", "./config", "path to the kubeconfig file")
 	inCluster := flag.Bool("in-cluster", false, "Use in-cluster credentials")
 	kubeContext := flag.String("context", "", "override current-context (default 'current-context' in kubeconfig)")
-	kubeNamespace := flag.String("namespace", "", "specific namespace (default all namespaces)")
+	kubeNamespace := flag.String("namespace", "", "specific namespace or shell-style pattern such as 'ci-*' (required unless -all-namespaces)")
+	allNamespaces := flag.Bool("all-namespaces", false, "Operate on all namespaces")
 	deleteJobs := flag.Bool("f", false, "Delete the jobs/pods (default simulate without deleting)")
 	orphanedPods := flag.Bool("o", false, "Search for orphaned job pods. Deletes them if \"-f\" is set.")
+	scanAllNamespaces := flag.Bool("scan-all-namespaces", false, "scan every namespace for orphaned pods, not only those with eligible jobs, when namespaces are scanned one by one")
 	olderThanDays := flag.Int("days", 7, "set delete threshold in days")
+	sweepMarked := flag.Bool("sweep-marked", false, "Only select jobs annotated \""+markedForDeletionAnnotation+"\" at least \"-mark-grace-days\" ago")
+	markGraceDays := flag.Int("mark-grace-days", 1, "grace period in days between marking a job and sweeping it (used with -sweep-marked)")
+	maxPerRun := flag.Int("max-per-run", 0, "maximum number of jobs to delete per run, in -delete-order (default no limit)")
+	deleteOrder := flag.String("delete-order", orderOldestFirst, "order in which eligible jobs are cleaned up, "+orderOldestFirst+" or "+orderNewestFirst)
+	fromStdin := flag.Bool("stdin", false, "Read a YAML/JSON list of \"namespace/name\" jobs to delete from stdin instead of listing jobs")
+	force := flag.Bool("force", false, "Skip the eligibility and completion checks for jobs read with -stdin")
+	ageFallback := flag.Bool("age-fallback", false, "Use the creation time for the age of jobs without a completion time (default skip them)")
+	skipCompletionVerify := flag.Bool("skip-completion-verify", false, "Don't re-fetch jobs to verify they have a \"Complete\" or \"Failed\" condition before cleaning them up")
+	auditConfigMap := flag.String("audit-configmap", "", "append a summary of each run to this ConfigMap (default disabled)")
+	auditNamespace := flag.String("audit-namespace", "default", "namespace of the -audit-configmap ConfigMap")
+	auditEntries := flag.Int("audit-entries", 50, "number of runs to keep in the -audit-configmap ConfigMap")
+	webhookURL := flag.String("webhook", "", "POST a JSON summary of the run to this URL (default disabled)")
+	webhookHeader := flag.String("webhook-header", "", "comma-separated NAME:VALUE headers sent with -webhook, e.g. \"Authorization:Bearer TOKEN\"")
+	otlpEndpoint := flag.String("otlp-endpoint", "", "export the run's trace to this OTLP/HTTP endpoint at the end of the run, e.g. http://otel-collector:4318 (default disabled)")
+	warnDaysBelow := flag.Int("warn-days-below", 1, "warn when -days is below this value")
+	warnDaysAbove := flag.Int("warn-days-above", 365, "warn when -days is above this value (0 disables)")
+	warnEligiblePercent := flag.Int("warn-eligible-percent", 50, "warn when more than this percentage of jobs is eligible for deletion (0 disables)")
+	deleteJobsFirst := flag.Bool("delete-jobs-first", false, "Delete jobs with background propagation and let the garbage collector delete their pods")
+	clockSkew := flag.Duration("clock-skew", time.Minute, "tolerated clock skew between jobliterator and the cluster, subtracted from job ages")
+	detectStaleActive := flag.Bool("detect-stale-active", false, "List the pods of active jobs and treat jobs without pending or running pods as finished")
+	policyFile := flag.String("policy", "", "YAML or JSON file with per-namespace rules for days, selector, keepLast and protectAnnotation")
+	labelThresholds := flag.String("label-threshold", "", "comma-separated KEY=VALUE:DAYS overrides of -days for jobs with matching labels, e.g. \"env=dev:1,env=prod:30\"")
+	namespacesFile := flag.String("namespaces-file", "", "sweep exactly the namespaces listed in this file, one per line, \"#\" starts a comment")
+	selector := flag.String("selector", "", "only consider jobs matching this label selector, e.g. \"team=data,tier!=critical\"")
+	orphanSelector := flag.String("orphan-selector", "", "only consider pods matching this label selector in the orphan scan, independent of -selector")
+	scopeOrphans := flag.Bool("scope-orphans", false, "apply -selector to the pods considered by the orphan scan as well")
+	deleteStaleCronJobs := flag.Bool("delete-stale-cronjobs", false, "Search for CronJobs without a successful job in -stale-cronjob-days days. Deletes them if \"-f\" is set.")
+	staleCronJobDays := flag.Int("stale-cronjob-days", 30, "days without a successful job after which a CronJob is stale")
+	duplicateWindow := flag.Duration("duplicate-window", 0, "report jobs of the same CronJob created within this long of each other, e.g. \"5m\" (default disabled)")
+	deleteDuplicates := flag.Bool("delete-duplicates", false, "count the eligible jobs found with -duplicate-window as duplicates in the deletion reasons, except the newest job of each group")
+	reportAgeHistogram := flag.Bool("report-age-histogram", false, "Print the age distribution of the jobs eligible for deletion, and include it in the summary line and reports")
+	waitFinalizers := flag.Duration("wait-finalizers", 0, "after deleting a job, wait up to this long for it to disappear and report jobs stuck on finalizers (default disabled)")
+	jobLabels := flag.String("job-labels", "job-name", "comma-separated pod label keys naming the pod's job, e.g. \"job-name,openshift.io/build.name\"")
+	ownerPresetName := flag.String("owner-preset", "", "check orphaned pods against a known job system instead of Jobs: \"argo\"")
+	ownerUIDVerify := flag.Bool("owner-uid-verify", true, "Treat pods whose owner reference UID differs from the existing job of the same name as orphaned")
+	ownerResource := flag.String("owner-resource", "", "check orphaned pods against this \"group/version/resource\" instead of Jobs, e.g. \"argoproj.io/v1alpha1/workflows\"")
+	saveLogs := flag.String("save-logs", "", "before deleting pods, save their logs to DIR/namespace/pod.log (default disabled)")
+	saveLogsPhases := flag.String("save-logs-phases", "Failed", "comma-separated pod phases whose logs are saved with -save-logs")
+	orphanKeepAnnotation := flag.String("orphan-keep-annotation", "jobliterator/keep", "never delete orphaned pods carrying this annotation (empty disables)")
+	orphanMinAge := flag.Duration("orphan-min-age", time.Hour, "never delete orphaned pods started less than this long ago (0 disables)")
+	forceTerminate := flag.Bool("force-terminate", false, "Force delete pods still terminating -force-terminate-after after being deleted, e.g. on nodes that are gone")
+	forceTerminateAfter := flag.Duration("force-terminate-after", time.Minute, "how long to wait for a deleted pod to go away before -force-terminate deletes it without grace period")
+	ignorePDB := flag.Bool("ignore-pdb", false, "Delete orphaned pods that aren't finished even if that violates a PodDisruptionBudget")
+	outputTemplate := flag.String("template", "", "Go text/template for job and pod lines, with .Kind, .Name, .Namespace, .Age, .Phase and .Action")
+	incremental := flag.Bool("incremental", false, "Only consider jobs that became eligible since the last successful run, see -state-file and -state-configmap")
+	stateFile := flag.String("state-file", "", "file storing the last successful run for -incremental")
+	stateConfigMap := flag.String("state-configmap", "", "ConfigMap in -state-namespace storing the last successful run for -incremental")
+	stateNamespace := flag.String("state-namespace", "default", "namespace of the -state-configmap ConfigMap")
+	checkpointFile := flag.String("checkpoint", "", "file recording the jobs deleted so far, a restarted run with the same file skips them")
+	handleDeadNodes := flag.Bool("handle-dead-nodes", false, "Force delete unfinished pods of jobs on nodes that no longer exist, and count them as finished for -detect-stale-active")
+	reportRestarts := flag.Bool("report-restarts", false, "Report the total container restarts of the pods of each cleaned up job")
+	noPodScan := flag.Bool("no-pod-scan", false, "In dry-run, list the eligible jobs without listing their pods, for a fast inventory")
+	var excludeAnnotations annotationExcludes
+	flag.Var(&excludeAnnotations, "exclude-annotation", "skip jobs whose annotation KEY has a value matching REGEX, given as KEY=REGEX; repeat to skip only jobs matching all of them")
+	progressEvery := flag.Int("progress-every", 100, "report the progress of deleting a job's pods every this many pods (0 disables)")
+	jobPropagation := flag.String("job-propagation", "", "propagation policy of job deletes: Background, Foreground or Orphan (default the API server's, Background with -delete-jobs-first)")
+	podPropagation := flag.String("pod-propagation", "Background", "propagation policy of pod deletes: Background, Foreground or Orphan")
+	maxAgeDays := flag.Int("max-age", 0, "never delete jobs older than this many days, they are kept as intentional landmarks (default disabled)")
+	completionStrategy := flag.String("completion-strategy", strategyConditions, "how finished jobs are recognised: \""+strategyConditions+"\", or \""+strategyCounts+"\" from the active, succeeded and failed counts for clusters without job conditions")
+	completionConditions := flag.String("completion-conditions", "Complete,Failed", "comma-separated job condition types that mark a job as done; if set, jobs need one of them and are aged from it")
+	orphanPhases := flag.String("orphan-phases", "Succeeded,Failed", "comma-separated phases of orphaned pods to delete, add Pending to delete pods that were never scheduled")
+	explainOrphans := flag.Bool("explain-orphans", false, "Print the ownerReferences of each orphaned pod and which of the referenced objects are missing")
+	explain := flag.Bool("explain", false, "Print the checks made for each job and whether it's eligible for deletion")
+	reapPods := flag.Bool("reap-pods-under-threshold", false, "Delete finished pods older than -pod-days of jobs younger than -days, keeping the jobs")
+	podDays := flag.Int("pod-days", 1, "age threshold in days for pods reaped with -reap-pods-under-threshold or -delete-succeeded-pods-of-running-jobs")
+	reversedTimestamps := flag.String("reversed-timestamps", reversedSkip, "what to do with jobs that completed before they started: skip, or start-time to age them from their start time")
+	deleteConfigMaps := flag.Bool("delete-associated-configmaps", false, "Delete the ConfigMaps labelled with the name of a deleted job, using the -job-labels keys")
+	deleteSecrets := flag.Bool("delete-associated-secrets", false, "Delete the Secrets labelled with the name of a deleted job, using the -job-labels keys")
+	reapRunning := flag.Bool("delete-succeeded-pods-of-running-jobs", false, "Delete succeeded pods older than -pod-days of active jobs, keeping the jobs and their other pods")
+	protectCronJobLatest := flag.Bool("protect-cronjob-latest", false, "Never delete the most recent job of each CronJob")
+	summaryLine := flag.Bool("summary-line", true, "Print a final \""+summaryLinePrefix+"{...}\" JSON line with the run's counts (use -summary-line=false to suppress)")
+	jobConcurrency := flag.Int("job-concurrency", 1, "number of jobs to clean up concurrently")
+	onlyDeletable := flag.Bool("only-deletable", false, "In dry-run, only print jobs and pods that would be deleted")
+	minCompletionsSucceeded := flag.Bool("min-completions-succeeded", false, "Only delete jobs that succeeded their required completions, keeping failed jobs")
+	includeFailed := flag.Bool("include-failed", false, "With -min-completions-succeeded, also delete jobs that failed")
+	includeHelmHooks := flag.Bool("include-helm-hooks", false, "Also delete jobs run as Helm hooks, which are skipped by default")
+	output := flag.String("output", outputText, "\""+outputText+"\", or \""+outputPrometheusTextfile+"\" to also write the run's counters to -output-file for node_exporter")
+	outputFile := flag.String("output-file", "", "file written with -output "+outputPrometheusTextfile+", e.g. /var/lib/node_exporter/jobliterator.prom")
+	reportJSON := flag.String("report-json", "", "also write a JSON report of the run's jobs, pods and summary to this file, alongside the -output on the console")
+	plan := flag.Bool("plan", false, "With -f, print the jobs that will be cleaned up and ask for confirmation before applying the plan")
+	noDeleteWindow := flag.String("no-delete-window", "", "recurring window during which -f refuses to delete, e.g. \"Mon-Fri 09:00-17:00\", \"Sat,Sun\" or \"22:00-06:00\"")
+	noDeleteWindowTZ := flag.String("no-delete-window-tz", "Local", "time zone of -no-delete-window, e.g. Europe/Stockholm")
+	requirePlanHash := flag.String("require-plan-hash", "", "only clean up if the jobs to clean up hash to this \"Plan hash\" of an approved dry-run")
+	yes := flag.Bool("yes", false, "Don't ask for confirmation, neither for -plan nor after warnings")
+	errorExitThreshold := flag.Int("error-exit-threshold", -1, "exit with status 2 if the run had more than this many errors (default disabled)")
+	deleteWarnThreshold := flag.Int("delete-warn-threshold", -1, "warn and exit with status 2 if the run deleted more than this many objects (default disabled)")
+	failFast := flag.Bool("fail-fast", false, "Stop at the first error and exit non-zero instead of continuing with the remaining jobs and pods")
+	printKubectl := flag.Bool("print-kubectl", false, "Print the equivalent \"kubectl delete\" command after each job and pod that is or would be deleted")
+	logFields := flag.Bool("log-fields", false, "Write every line as a logfmt record with run_id and, for job and pod lines, namespace and job fields for log aggregation")
+	deleteRate := flag.Float64("delete-rate", 0, "maximum job, pod and CronJob deletions per second across all workers (default unlimited)")
+	apiRetries := flag.Int("api-retries", 2, "number of times a failed list of jobs, pods or namespaces is retried")
+	retryCodes := flag.String("retry-codes", "429,500,502,503,504", "comma-separated API status codes retried with -api-retries, transport errors are always retried")
+	namespaceRetries := flag.Int("namespace-retries", 1, "number of passes retrying the namespaces whose jobs couldn't be listed, after all other namespaces")
+	discoveryCacheTTL := flag.Duration("discovery-cache-ttl", 0, "cache the discovered CronJob API version between runs for this long (0 disables)")
+	discoveryCacheFile := flag.String("discovery-cache-file", discoveryCachePath(), "file caching the discovered API versions for -discovery-cache-ttl")
+	asUser := flag.String("as", "", "user or service account (system:serviceaccount:NAMESPACE:NAME) to impersonate, like kubectl --as")
+	asGroups := flag.String("as-group", "", "comma-separated groups to impersonate, requires -as")
+	kubeHTTPTimeout := flag.Duration("kube-http-timeout", 30*time.Second, "how long to wait for the Kubernetes API to respond to each request (0 disables)")
+	flag.StringVar(&runID, "run-id", runID, "identifier of this run in logs, the summary, audit log, webhook and metrics, e.g. from an external scheduler (default a random UUID)")
+	showActive := flag.Bool("show-active", false, "List the jobs skipped because they're still active, with how long they've been running")
+	listNamespacesOnly := flag.Bool("list-namespaces", false, "Print the namespaces the namespace flags select and exit without listing jobs")
+	showVersion := flag.Bool("version", false, "Print the jobliterator version and, if the cluster can be reached, the Kubernetes server version, then exit")
+	flag.BoolVar(&debug, "debug", false, "Print debug output such as per-job API timings")
 	flag.Parse()
+	if *showVersion {
+		// A client that can't be built only means the server version is
+		// unknown, -version never fails.
+		client, _, err := loadClient(*kubeconfigPath, *kubeContext, *inCluster, *kubeHTTPTimeout)
+		if err != nil {
+			client = nil
+		}
+		printVersion(stdout, client)
+		os.Exit(0)
+	}
+	switch *output {
+	case outputText:
+	case outputPrometheusTextfile:
+		if *outputFile == "" {
+			fmt.Fprintln(stdout, "-output "+outputPrometheusTextfile+" requires -output-file.")
+			os.Exit(1)
+		}
+	default:
+		fmt.Fprintf(stdout, "Unknown -output %q.\n", *output)
+		os.Exit(1)
+	}
+	if !validRunID.MatchString(runID) {
+		fmt.Fprintln(stdout, "-run-id must be 1 to 128 letters, digits or any of \"._:/-\".")
+		os.Exit(1)
+	}
+	if *logFields {
+		stdout = runWriter(os.Stdout)
+	}
+	if *deleteOrder != orderOldestFirst && *deleteOrder != orderNewestFirst {
+		fmt.Fprintf(stdout, "Unknown -delete-order %q.\n", *deleteOrder)
+		os.Exit(1)
+	}
+	if *noPodScan && *deleteJobs {
+		fmt.Fprintln(stdout, "-no-pod-scan is only for dry-runs, it can't be combined with -f.")
+		os.Exit(1)
+	}
+	if *noPodScan && *orphanedPods {
+		// The orphan scan is nothing but pod lists.
+		fmt.Fprintln(stdout, "-no-pod-scan doesn't list pods and can't be combined with -o.")
+		os.Exit(1)
+	}
+	if (*jobPropagation != "" && !validPropagation(*jobPropagation)) || !validPropagation(*podPropagation) {
+		fmt.Fprintln(stdout, "-job-propagation and -pod-propagation must be Background, Foreground or Orphan.")
+		os.Exit(1)
+	}
+	if *deleteJobsFirst && *jobPropagation == "Orphan" {
+		fmt.Fprintln(stdout, "-delete-jobs-first relies on the garbage collector and can't be used with -job-propagation Orphan.")
+		os.Exit(1)
+	}
+	if *deleteJobsFirst && (*saveLogs != "" || *reportRestarts || *handleDeadNodes) {
+		// These need the pods, which aren't listed when the job delete works.
+		fmt.Fprintln(stdout, "-delete-jobs-first doesn't list the pods of deleted jobs and can't be combined with -save-logs, -report-restarts or -handle-dead-nodes.")
+		os.Exit(1)
+	}
+	if !*deleteJobs && *jobPropagation == "Orphan" {
+		fmt.Fprintln(stdout, "WARNING: with -job-propagation Orphan, pods not deleted along with their job are left behind as orphans.")
+	}
+	if len(splitList(*completionConditions)) == 0 {
+		fmt.Fprintln(stdout, "-completion-conditions must name at least one condition type.")
+		os.Exit(1)
+	}
+	if *completionStrategy != strategyConditions && *completionStrategy != strategyCounts {
+		fmt.Fprintf(stdout, "Unknown -completion-strategy %q.\n", *completionStrategy)
+		os.Exit(1)
+	}
+	if *completionStrategy == strategyCounts && flagSet("completion-conditions") {
+		fmt.Fprintln(stdout, "-completion-conditions can't be used with -completion-strategy "+strategyCounts+".")
+		os.Exit(1)
+	}
+	if *reversedTimestamps != reversedSkip && *reversedTimestamps != reversedStartTime {
+		fmt.Fprintf(stdout, "Unknown -reversed-timestamps %q.\n", *reversedTimestamps)
+		os.Exit(1)
+	}
+	if *asGroups != "" && *asUser == "" {
+		fmt.Fprintln(stdout, "-as-group requires -as.")
+		os.Exit(1)
+	}
+	if *requirePlanHash != "" && (*orphanedPods || *deleteStaleCronJobs || *reapPods || *reapRunning || *deleteConfigMaps || *deleteSecrets) {
+		// The hash only covers the selected jobs, so approving it says
+		// nothing about what these passes delete.
+		fmt.Fprintln(stdout, "-require-plan-hash only covers jobs and can't be combined with -o, -delete-stale-cronjobs, -reap-pods-under-threshold, -delete-succeeded-pods-of-running-jobs, -delete-associated-configmaps or -delete-associated-secrets.")
+		os.Exit(1)
+	}
+	if *discoveryCacheTTL > 0 && *discoveryCacheFile == "" {
+		fmt.Fprintln(stdout, "-discovery-cache-ttl needs -discovery-cache-file, there is no user cache directory to keep it in.")
+		os.Exit(1)
+	}
+	if *maxAgeDays > 0 && *maxAgeDays < *olderThanDays {
+		// No job could be old enough and young enough at once.
+		fmt.Fprintf(stdout, "-max-age %d is less than -days %d.\n", *maxAgeDays, *olderThanDays)
+		os.Exit(1)
+	}
+	if *includeFailed && !*minCompletionsSucceeded {
+		fmt.Fprintln(stdout, "-include-failed requires -min-completions-succeeded.")
+		os.Exit(1)
+	}
+	if *noDeleteWindow != "" {
+		window, err := parseDeleteWindow(*noDeleteWindow)
+		if err != nil {
+			fmt.Fprintln(stdout, err.Error())
+			os.Exit(1)
+		}
+		loc, err := time.LoadLocation(*noDeleteWindowTZ)
+		if err != nil {
+			fmt.Fprintf(stdout, "Invalid -no-delete-window-tz %q: %v\n", *noDeleteWindowTZ, err)
+			os.Exit(1)
+		}
+		// Dry-runs are still allowed, they don't change the cluster.
+		if *deleteJobs && window.contains(time.Now().In(loc)) {
+			fmt.Fprintf(stdout, "Within no-delete window %q (%s), skipping deletions.\n", *noDeleteWindow, loc)
+			os.Exit(0)
+		}
+	}
+	webhookHeaders, err := parseWebhookHeaders(*webhookHeader)
+	if err != nil {
+		fmt.Fprintln(stdout, err.Error())
+		os.Exit(1)
+	}
+	tmpl, err := parseTemplate(*outputTemplate)
+	if err != nil {
+		fmt.Fprintln(stdout, err.Error())
+		os.Exit(1)
+	}
 	//uses the current context in kubeconfig unless overriden using '-context'
-	client, err := loadClient(*kubeconfigPath, *kubeContext, *inCluster)
+	client, contextNamespace, err := loadClient(*kubeconfigPath, *kubeContext, *inCluster, *kubeHTTPTimeout)
 	if err != nil {
-		fmt.Println(err.Error())
+		exitSetupError(err)
+	}
+	if *asUser != "" {
+		impersonate(client, *asUser, splitList(*asGroups))
+	}
+	if *otlpEndpoint != "" {
+		tracer = newTracer(start)
+		traceClient(client)
+	}
+	// Like kubectl, default to the namespace of the kubeconfig context. An
+	// explicitly empty -namespace is still an error.
+	if !flagSet("namespace") && !*allNamespaces && *namespacesFile == "" && contextNamespace != "" {
+		*kubeNamespace = contextNamespace
+		debugf("Using namespace %s from the kubeconfig context\n", contextNamespace)
+	}
+	// Jobs read with -stdin carry their own namespace, only the orphan and
+	// CronJob scans need one.
+	if err := checkNamespaceFlags(*kubeNamespace, *allNamespaces, *namespacesFile, !*fromStdin || *orphanedPods || *deleteStaleCronJobs); err != nil {
+		fmt.Fprintln(stdout, err.Error())
 		os.Exit(1)
 	}
+	if err := checkConnection(client); err != nil {
+		exitSetupError(err)
+	}
+	discovery = discoveryCache{path: *discoveryCacheFile, endpoint: client.Endpoint, ttl: *discoveryCacheTTL}
+	discovery.load(time.Now())
+	// Scheduled runs aren't interactive and are left alone, the check is
+	// against a mistyped command.
+	if *allNamespaces && *deleteJobs && !*yes && !*fromStdin && isInteractive() {
+		if !confirmTyped("This deletes jobs in all namespaces.", "all-namespaces") {
+			fmt.Fprintln(stdout, "Aborted.")
+			os.Exit(0)
+		}
+	}
+
+	cfg := &runConfig{
+		deleteJobs:              *deleteJobs,
+		olderThanDays:           *olderThanDays,
+		sweepMarked:             *sweepMarked,
+		markGraceDays:           *markGraceDays,
+		ageFallback:             *ageFallback,
+		verifyCompletion:        !*skipCompletionVerify,
+		deleteJobsFirst:         *deleteJobsFirst,
+		waitFinalizers:          *waitFinalizers,
+		selector:                *selector,
+		jobLabels:               splitList(*jobLabels),
+		owner:                   jobOwner{},
+		ownerUIDVerify:          *ownerUIDVerify,
+		saveLogsDir:             *saveLogs,
+		saveLogsPhases:          splitList(*saveLogsPhases),
+		ignorePDB:               *ignorePDB,
+		orphanKeepAnnotation:    *orphanKeepAnnotation,
+		orphanMinAge:            *orphanMinAge,
+		template:                tmpl,
+		reapPods:                *reapPods,
+		reapRunning:             *reapRunning,
+		deleteConfigMaps:        *deleteConfigMaps,
+		deleteSecrets:           *deleteSecrets,
+		reversedTimestamps:      *reversedTimestamps,
+		podDays:                 *podDays,
+		explain:                 *explain,
+		explainOrphans:          *explainOrphans,
+		reportRestarts:          *reportRestarts,
+		noPodScan:               *noPodScan,
+		excludeAnnotations:      excludeAnnotations,
+		progressEvery:           *progressEvery,
+		jobPropagation:          *jobPropagation,
+		podPropagation:          *podPropagation,
+		maxAgeDays:              *maxAgeDays,
+		completionConditions:    splitList(*completionConditions),
+		conditionAge:            flagSet("completion-conditions"),
+		completionStrategy:      *completionStrategy,
+		handleDeadNodes:         *handleDeadNodes,
+		onlyDeletable:           *onlyDeletable,
+		forceTerminate:          *forceTerminate,
+		forceTerminateAfter:     *forceTerminateAfter,
+		clockSkew:               *clockSkew,
+		logFields:               *logFields,
+		printKubectl:            *printKubectl,
+		failFast:                *failFast,
+		includeHelmHooks:        *includeHelmHooks,
+		minCompletionsSucceeded: *minCompletionsSucceeded,
+		includeFailed:           *includeFailed,
+	}
 
-	// Retrive a list of all jobs in the current context and namespace
-	jobs, err := client.BatchV1().ListJobs(context.Background(), *kubeNamespace)
+	deleteLimiter.setRate(*deleteRate)
+	apiRetry = retryPolicy{retries: *apiRetries, backoff: time.Second}
+	apiRetry.codes, err = parseRetryCodes(*retryCodes)
 	if err != nil {
-		panic(err.Error())
+		fmt.Fprintln(stdout, err.Error())
+		os.Exit(1)
+	}
+	if *reportJSON != "" {
+		cfg.report = new(itemRecorder)
+	}
+	if *checkpointFile != "" {
+		cfg.checkpoint, err = openCheckpoint(*checkpointFile)
+		if err != nil {
+			fmt.Fprintln(stdout, err.Error())
+			os.Exit(1)
+		}
+	}
+	if *policyFile != "" {
+		cfg.policy, err = readPolicy(*policyFile)
+		if err != nil {
+			fmt.Fprintln(stdout, err.Error())
+			os.Exit(1)
+		}
+	}
+	cfg.labelThresholds, err = parseLabelThresholds(*labelThresholds)
+	if err != nil {
+		fmt.Fprintln(stdout, err.Error())
+		os.Exit(1)
+	}
+	cfg.orphanPhases, err = parseOrphanPhases(*orphanPhases)
+	if err != nil {
+		fmt.Fprintln(stdout, err.Error())
+		os.Exit(1)
+	}
+	if err := validateSelector("selector", *selector); err != nil {
+		fmt.Fprintln(stdout, err.Error())
+		os.Exit(1)
+	}
+	if err := validateSelector("orphan-selector", *orphanSelector); err != nil {
+		fmt.Fprintln(stdout, err.Error())
+		os.Exit(1)
+	}
+	cfg.orphanSelector, err = orphanScanSelector(cfg.selector, *orphanSelector, *scopeOrphans)
+	if err != nil {
+		fmt.Fprintln(stdout, err.Error())
+		os.Exit(1)
+	}
+
+	if *ownerPresetName != "" {
+		preset, ok := ownerPresets[*ownerPresetName]
+		if !ok {
+			fmt.Fprintf(stdout, "Unknown owner preset %q.\n", *ownerPresetName)
+			os.Exit(1)
+		}
+		cfg.owner = preset.owner
+		if !flagSet("job-labels") {
+			cfg.jobLabels = []string{preset.jobLabel}
+		}
+	}
+	if *ownerResource != "" {
+		owner, err := parseOwnerResource(*ownerResource)
+		if err != nil {
+			fmt.Fprintln(stdout, err.Error())
+			os.Exit(1)
+		}
+		cfg.owner = owner
 	}
 
 	now := time.Now()
+	var state runState
+	if *incremental {
+		switch {
+		case *stateFile != "":
+			state = fileState{path: *stateFile}
+		case *stateConfigMap != "":
+			state = configMapState{client: client, namespace: *stateNamespace, name: *stateConfigMap}
+		default:
+			fmt.Fprintln(stdout, "-incremental requires -state-file or -state-configmap.")
+			os.Exit(1)
+		}
+		lastRun, ok, err := state.load()
+		if err != nil {
+			fmt.Fprintln(stdout, err.Error())
+			os.Exit(1)
+		}
+		if ok {
+			cfg.lastRun = lastRun
+		} else {
+			fmt.Fprintln(stdout, "No previous run found, considering all jobs.")
+		}
+	}
+
 	var eligibleJobs []kubeJob
-	for _, j := range jobs.Items {
-		if *j.Status.Active == 1 {
-			continue
-		}
-		completionTime := time.Unix(j.Status.GetCompletionTime().GetSeconds(), 0)
-		daysOld := int(now.Sub(completionTime).Hours() / 24)
-		if daysOld >= *olderThanDays {
-			eligibleJobs = append(eligibleJobs, kubeJob{name: *j.Metadata.Name, namespace: *j.Metadata.Namespace, age: daysOld})
-		}
-	}
-
-	if *deleteJobs {
-		for _, dj := range eligibleJobs {
-			fmt.Printf("Deleting job: %s\tNamespace:%s\tAge:%vd\n", dj.name, dj.namespace, dj.age)
-			// First use the job label to find the corresponding pods to delete
-			podLS := new(k8s.LabelSelector)
-			podLS.Eq("job-name", dj.name)
-			pods, podErr := client.CoreV1().ListPods(context.Background(), *kubeNamespace, podLS.Selector())
-			if podErr != nil {
-				fmt.Printf("Unable to list jobs with label job-name=%s. Skipping this job.", dj.name)
-				fmt.Printf("ERROR: Job %s skipped. %s.", dj.name, podErr.Error())
-				continue
+	// reapJobs are finished jobs kept for being too young whose pods may
+	// still be reaped.
+	var reapJobs []kubeJob
+	// runningJobs are active jobs whose succeeded pods may be reaped.
+	var runningJobs []kubeJob
+	var skippedNamespaces []string
+	var retriedNamespaces []string
+	totalJobs := 0
+	protectedJobs := 0
+	// activeJobs are skipped for still running.
+	activeJobs := 0
+	// A selective run across all namespaces lists its jobs with a single
+	// request, namespaces are only listed if that isn't allowed.
+	var clusterJobs []*batchv1.Job
+	clusterWide := false
+	if !*fromStdin && !*listNamespacesOnly && *allNamespaces && cfg.selector != "" && cfg.policy == nil {
+		clusterJobs, clusterWide, err = listClusterJobs(client, cfg.selector)
+		if err != nil {
+			panic(err.Error())
+		}
+	}
+	namespaces := []string{*kubeNamespace}
+	if *namespacesFile != "" {
+		namespaces, err = readNamespacesFile(*namespacesFile)
+		if err != nil {
+			fmt.Fprintln(stdout, err.Error())
+			os.Exit(1)
+		}
+	} else if (!*fromStdin && !clusterWide) || isNamespacePattern(*kubeNamespace) {
+		namespaces, err = listNamespaces(client, *kubeNamespace, *allNamespaces)
+		if err != nil {
+			fmt.Fprintln(stdout, err.Error())
+			os.Exit(1)
+		}
+	}
+	if *listNamespacesOnly {
+		printNamespaces(stdout, namespaces)
+		os.Exit(0)
+	}
+	// jobs are all jobs listed, which isn't done with -stdin.
+	var jobs []*batchv1.Job
+	if *fromStdin {
+		refs, err := readJobList(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(stdout, err.Error())
+			os.Exit(1)
+		}
+		totalJobs = len(refs)
+		eligibleJobs, err = resolveJobList(client, refs, now, cfg, *force)
+		if err != nil {
+			fmt.Fprintln(stdout, err.Error())
+			os.Exit(1)
+		}
+	} else {
+		// Retrive a list of all jobs in the current context and namespaces
+		jobs = clusterJobs
+		if !clusterWide {
+			jobs, skippedNamespaces, retriedNamespaces, err = listJobs(client, namespaces, cfg.selectorFor, *namespaceRetries)
+			if err != nil {
+				panic(err.Error())
+			}
+		}
+		totalJobs = len(jobs)
+		cfg.noConditions = noJobConditions(jobs)
+		// The newest job of each CronJob is protected even if it's old,
+		// it might be the last successful run before the schedule broke.
+		protected := make(map[string]bool)
+		if *protectCronJobLatest {
+			protected = newestCronJobJobs(jobs)
+		}
+		keepLast := keepLastJobs(jobs, cfg.policy)
+		for _, j := range jobs {
+			stale := false
+			if *detectStaleActive && !cfg.noPodScan {
+				stale, err = staleActiveJob(client, j, cfg)
+				if err != nil {
+					fmt.Fprintf(stdout, "Unable to list pods of active job %s in namespace %s. Error: %s\n", j.Metadata.GetName(), j.Metadata.GetNamespace(), err.Error())
+				} else if stale {
+					fmt.Fprintf(stdout, "Job %s in namespace %s is counted as active but has no live pods.\n", j.Metadata.GetName(), j.Metadata.GetNamespace())
+				}
+			}
+			if j.Status.GetActive() > 0 && !stale {
+				activeJobs++
+				if *showActive {
+					fmt.Fprintf(stdout, "Active job: %s\tNamespace: %s\tRunning for: %v\n", j.Metadata.GetName(), j.Metadata.GetNamespace(), activeFor(j, now))
+				}
 			}
-			var eligiblePods []kubePod
-			if len(pods.Items) > 0 {
-				for _, p := range pods.Items {
-					// Build a slice of eligible jobs to avoid calling the API more than needed
-					if *p.Status.Phase == "Succeeded" || *p.Status.Phase == "Failed" {
-						eligiblePods = append(eligiblePods, kubePod{name: p.Metadata.GetName(), namespace: p.Metadata.GetNamespace(), phase: p.Status.GetPhase()})
-					} else {
-						fmt.Printf("\tPod associated with %s is not in \"Succeeded\" or \"Failed\" phase but job is complete.", dj.name)
-						fmt.Printf("\tPod %s is in phase %s, skipping.\n", p.Metadata.GetName(), p.Status.GetPhase())
-					}
+			if kj, ok := eligibleJob(j, now, cfg, stale); ok {
+				if protected[kj.namespace+"/"+kj.name] {
+					fmt.Fprintf(stdout, "Job %s in namespace %s is the most recent job of its CronJob, skipping.\n", kj.name, kj.namespace)
+					protectedJobs++
+					continue
 				}
-				if len(eligiblePods) > 0 {
-					for _, dp := range eligiblePods {
-						fmt.Printf("\tDeleting pod: %s\tPhase: %s\n", dp.name, dp.phase)
-						podErr = client.CoreV1().DeletePod(context.Background(), dp.name, dp.namespace)
-						if podErr != nil {
-							fmt.Printf("\tUnable to delete pod %s. Error: %s\n", dp.name, podErr.Error())
-							continue
-						}
-					}
-				} else {
-					fmt.Printf("\tNo pods eligible for deletion associated with job %s.\n", dj.name)
+				if keepLast[kj.namespace+"/"+kj.name] {
+					debugf("Job %s in namespace %s is kept by the keepLast policy\n", kj.name, kj.namespace)
+					continue
+				}
+				eligibleJobs = append(eligibleJobs, kj)
+			} else if cfg.reapPods {
+				if kj, ok := reapableJob(j, now, cfg); ok {
+					reapJobs = append(reapJobs, kj)
 				}
-			} else {
-				fmt.Printf("\tNo pods associated with job %s.\n", dj.name)
 			}
-
-			err2 := client.BatchV1().DeleteJob(context.Background(), dj.name, dj.namespace)
-			if err2 != nil {
-				fmt.Println("Unable to delete job %s.\n Error: %v\n", dj.name, err2.Error())
-				continue
+			if cfg.reapRunning && !stale {
+				if kj, ok := runningReapableJob(j, now); ok {
+					runningJobs = append(runningJobs, kj)
+				}
 			}
 		}
-		if *orphanedPods {
-			opCount := 0
-			fmt.Println("==============================")
-			fmt.Println("Searching for orphaned pods...")
-			fmt.Println("==============================")
-			opJobs, err := getOrphanedPods(client, *kubeNamespace)
-			if err != nil {
-				fmt.Printf("Error fetching orphaned pods: %s", err.Error())
-			} else {
-				for _, j := range opJobs {
-					fmt.Printf("Job: %s\tNamespace:%s\n", j.name, j.namespace)
-					if len(j.pods) < 1 {
-						fmt.Printf("Unable to find any pods associated with job %s.\n", j.name)
-						continue
-					}
-					for _, op := range j.pods {
-						if op.phase == "Succeeded" || op.phase == "Failed" {
-							opCount++
-							fmt.Printf("\tDeleting pod: %s\tNamespace: %s\tPhase: %s\n", op.name, op.namespace, op.phase)
-							podErr := client.CoreV1().DeletePod(context.Background(), op.name, op.namespace)
-							if podErr != nil {
-								fmt.Printf("\tUnable to delete pod %s. Error: %s\n", op.name, podErr.Error())
-								continue
-							}
-						} else {
-							fmt.Printf("\tPod %s is not in \"Succeeded\" or \"Failed\" phase but appears oprhaned.\n", op.name)
-							fmt.Printf("\tPod %s is in phase %s, skipping.\n", op.name, op.phase)
-						}
-
-					}
+	}
 
-				}
-			}
-			fmt.Printf("Total orphaned Pods: %v beloning to %v jobs.\n", opCount, len(opJobs))
+	if *duplicateWindow > 0 {
+		// Misfires are reported even without -delete-duplicates, the newest
+		// job of each group is the one the schedule meant to run.
+		eligible := make(map[string]int, len(eligibleJobs))
+		for i, kj := range eligibleJobs {
+			eligible[kj.namespace+"/"+kj.name] = i
 		}
-	} else {
-		fmt.Println("Jobs eligible for deletion with -f flag:")
-		for _, dj := range eligibleJobs {
-			fmt.Printf("Name: %s\tNamespace: %s\tAge:%vd\n", dj.name, dj.namespace, dj.age)
-			podLS := new(k8s.LabelSelector)
-			podLS.Eq("job-name", dj.name)
-			pods, podErr := client.CoreV1().ListPods(context.Background(), *kubeNamespace, podLS.Selector())
-			if podErr != nil {
-				fmt.Printf("Unable to list jobs with label job-name=%s. Skipping this job.", dj.name)
-				fmt.Printf("ERROR: Job %s skipped. %s.", dj.name, podErr.Error())
+		for _, group := range duplicateCronJobJobs(jobs, *duplicateWindow) {
+			owner, _ := cronJobOwner(group[0])
+			fmt.Fprintf(stdout, "CronJob %s in namespace %s created %d jobs scheduled within %v of each other.\n", owner, group[0].Metadata.GetNamespace(), len(group), *duplicateWindow)
+			if !*deleteDuplicates {
 				continue
 			}
-			var eligiblePods []kubePod
-			if len(pods.Items) > 0 {
-				for _, p := range pods.Items {
-					if *p.Status.Phase == "Succeeded" || *p.Status.Phase == "Failed" {
-						eligiblePods = append(eligiblePods, kubePod{name: p.Metadata.GetName(), namespace: p.Metadata.GetNamespace(), phase: p.Status.GetPhase()})
-					} else {
-						fmt.Printf("\tPod associated with %s is not in \"Succeeded\" or \"Failed\" phase but job is complete.", dj.name)
-						fmt.Printf("\tPod %s is in phase %s, skipping.", p.Metadata.GetName(), p.Status.GetPhase())
-					}
-				}
-				if len(eligiblePods) > 0 {
-					for _, dp := range eligiblePods {
-						fmt.Printf("\tPod: %s\tNamespace: %s\tPhase: %s\n", dp.name, dp.namespace, dp.phase)
-					}
-				} else {
-					fmt.Printf("\tNo pods eligible for deletion associated with job %s.\n", dj.name)
+			for _, j := range group[:len(group)-1] {
+				// Duplicates still go through every check, so only
+				// jobs that are deleted anyway are counted.
+				if i, ok := eligible[j.Metadata.GetNamespace()+"/"+j.Metadata.GetName()]; ok {
+					eligibleJobs[i].reason = reasonDuplicate
 				}
-			} else {
-				fmt.Printf("\tNo pods associated with job %s.\n", dj.name)
 			}
 		}
-		fmt.Printf("Total Jobs: %v\n", len(eligibleJobs))
-		if *orphanedPods {
-			opCount := 0
-			fmt.Println("==============================")
-			fmt.Println("Searching for orphaned pods...")
-			fmt.Println("==============================")
-			opJobs, err := getOrphanedPods(client, *kubeNamespace)
-			if err != nil {
-				fmt.Printf("Error fetching orphaned pods: %s", err.Error())
-			} else {
-				for _, j := range opJobs {
-					fmt.Printf("Job: %s\tNamespace: %s\n", j.name, j.namespace)
-					for _, op := range j.pods {
-						if op.phase == "Succeeded" || op.phase == "Failed" {
-							opCount++
-							fmt.Printf("\tPod: %s\tNamespace: %s\tPhase: %s\n", op.name, op.namespace, op.phase)
-						} else {
-							fmt.Printf("\tPod %s is not in \"Succeeded\" or \"Failed\" phase but appears oprhaned.\n", op.name)
-							fmt.Printf("\tPod %s is in phase %s, skipping.\n", op.name, op.phase)
-						}
-					}
-				}
+	}
+
+	if cfg.checkpoint != nil {
+		var done int
+		eligibleJobs, done = cfg.checkpoint.filter(eligibleJobs)
+		if done > 0 {
+			fmt.Fprintf(stdout, "Skipping %v jobs already deleted according to -checkpoint.\n", done)
+		}
+	}
+
+	var histogram map[string]int
+	if *reportAgeHistogram {
+		printAgeHistogram(stdout, eligibleJobs)
+		histogram = ageHistogramByLabel(eligibleJobs)
+	}
+
+	guard := guardrails{daysBelow: *warnDaysBelow, daysAbove: *warnDaysAbove, eligiblePercent: *warnEligiblePercent}
+	warnings := guard.check(cfg.olderThanDays, len(eligibleJobs), totalJobs)
+	for _, w := range warnings {
+		fmt.Fprintf(stdout, "WARNING: %s.\n", w)
+	}
+	if len(warnings) > 0 && cfg.deleteJobs && !*fromStdin && !*yes && isInteractive() {
+		if !confirm("Continue deleting?") {
+			fmt.Fprintln(stdout, "Aborted.")
+			os.Exit(0)
+		}
+	}
+
+	// Cap the run after all other selection so the eligible jobs go in
+	// -delete-order and the rest are left for the next run.
+	sortJobs(eligibleJobs, *deleteOrder)
+	deferred := 0
+	if *maxPerRun > 0 && len(eligibleJobs) > *maxPerRun {
+		deferred = len(eligibleJobs) - *maxPerRun
+		eligibleJobs = eligibleJobs[:*maxPerRun]
+	}
+
+	hash := planHash(eligibleJobs)
+	if !cfg.deleteJobs {
+		fmt.Fprintf(stdout, "Plan hash: %s\n", hash)
+	}
+	if *requirePlanHash != "" && *requirePlanHash != hash {
+		fmt.Fprintf(stdout, "The plan changed since it was approved, its hash is %s instead of %s. Not deleting anything.\n", hash, *requirePlanHash)
+		os.Exit(1)
+	}
+
+	// The plan is applied as computed, nothing is listed again after the
+	// confirmation.
+	if *plan && cfg.deleteJobs && !*yes {
+		if !isInteractive() {
+			fmt.Fprintln(stdout, "-plan needs an interactive terminal to confirm, use -yes to apply without confirmation.")
+			os.Exit(1)
+		}
+		printPlan(stdout, eligibleJobs)
+		if !confirm(fmt.Sprintf("Clean up these %d jobs?", len(eligibleJobs))) {
+			fmt.Fprintln(stdout, "Aborted.")
+			os.Exit(0)
+		}
+	}
+
+	sum := &runSummary{JobsConsidered: totalJobs, JobsEligible: len(eligibleJobs), JobsActive: activeJobs, SkippedNamespaces: skippedNamespaces, RetriedNamespaces: retriedNamespaces, AgeHistogram: histogram}
+	if protectedJobs > 0 {
+		sum.skip("cronjob-latest", protectedJobs)
+	}
+	if deferred > 0 {
+		sum.skip("deferred", deferred)
+	}
+	// From here on an interrupt stops the run between jobs, so the summary
+	// still covers everything that was done.
+	handleInterrupts()
+	if !cfg.deleteJobs {
+		fmt.Fprintln(stdout, "Jobs eligible for deletion with -f flag:")
+	}
+	cleanupJobs(client, eligibleJobs, cfg, sum, *jobConcurrency)
+	if !cfg.deleteJobs {
+		fmt.Fprintf(stdout, "Total Jobs: %v\n", len(eligibleJobs))
+	}
+	if cfg.reapPods && !cfg.noPodScan && !cfg.stopped(sum) {
+		for _, rj := range reapJobs {
+			reapJobPods(client, rj, cfg, stdout, sum)
+			if cfg.stopped(sum) {
+				break
 			}
-			fmt.Printf("Total orphaned Pods: %v beloning to %v jobs.\n", opCount, len(opJobs))
 		}
 	}
+	if cfg.reapRunning && !cfg.noPodScan && !cfg.stopped(sum) {
+		for _, rj := range runningJobs {
+			reapRunningJobPods(client, rj, cfg, stdout, sum)
+			if cfg.stopped(sum) {
+				break
+			}
+		}
+	}
+	if activeJobs > 0 {
+		fmt.Fprintf(stdout, "Skipped %v active jobs.\n", activeJobs)
+	}
+	if deferred > 0 {
+		fmt.Fprintf(stdout, "Deferred %v jobs to the next run (-max-per-run=%v).\n", deferred, *maxPerRun)
+	}
+	// A single cluster-wide scan is enough for orphans and CronJobs unless a
+	// pattern or file selected specific namespaces.
+	scanNamespaces := []string{*kubeNamespace}
+	if *allNamespaces {
+		scanNamespaces = []string{k8s.AllNamespaces}
+	} else if isNamespacePattern(*kubeNamespace) || *namespacesFile != "" {
+		scanNamespaces = namespaces
+	}
+	if *orphanedPods && !cfg.stopped(sum) {
+		orphanNamespaces := scanNamespaces
+		if !*scanAllNamespaces {
+			var skipped int
+			orphanNamespaces, skipped = namespacesWithJobs(scanNamespaces, eligibleJobs)
+			if skipped > 0 {
+				fmt.Fprintf(stdout, "Skipping the orphan scan of %v namespaces without eligible jobs, see -scan-all-namespaces.\n", skipped)
+			}
+		}
+		cleanupOrphans(client, orphanNamespaces, cfg, sum)
+	}
+	if *deleteStaleCronJobs && !cfg.stopped(sum) {
+		cleanupStaleCronJobs(client, scanNamespaces, *staleCronJobDays, cfg, sum)
+	}
+	if sum.PodsOrphaned > 0 {
+		fmt.Fprintf(stdout, "WARNING: %v pods would be left behind as orphans, see -job-propagation.\n", sum.PodsOrphaned)
+	}
+	printDeletedByReason(stdout, sum)
+	printObjectReduction(stdout, sum, !cfg.deleteJobs)
+	if len(sum.PartiallyDeleted) > 0 {
+		fmt.Fprintf(stdout, "Pods deleted but job delete failed: %s\n", strings.Join(sum.PartiallyDeleted, ", "))
+	}
+	if len(sum.SkippedNamespaces) > 0 {
+		fmt.Fprintf(stdout, "Skipped namespaces due to insufficient permissions: %s\n", strings.Join(sum.SkippedNamespaces, ", "))
+	}
+	if len(sum.RetriedNamespaces) > 0 {
+		fmt.Fprintf(stdout, "Namespaces that needed a retry pass: %s\n", strings.Join(sum.RetriedNamespaces, ", "))
+	}
+	if !cfg.deleteJobs {
+		printAPIEstimate(stdout, sum)
+	}
+	if *auditConfigMap != "" {
+		entry := newAuditEntry(now, cfg, sum)
+		if err := appendAuditLog(client, *auditNamespace, *auditConfigMap, entry, *auditEntries); err != nil {
+			fmt.Fprintf(stdout, "Unable to write audit log: %s\n", err.Error())
+		}
+	}
+	if *webhookURL != "" {
+		if err := postWebhook(*webhookURL, webhookHeaders, *kubeHTTPTimeout, newAuditEntry(now, cfg, sum)); err != nil {
+			fmt.Fprintf(stdout, "Unable to send webhook: %s\n", err.Error())
+		}
+	}
+	if state != nil && completeRun(cfg, sum) {
+		if err := state.save(now); err != nil {
+			fmt.Fprintf(stdout, "Unable to save last run: %s\n", err.Error())
+		}
+	}
+	debugf("Total API calls: %d\n", totalAPICalls())
+	if *reportJSON != "" {
+		if err := writeJSONReport(*reportJSON, newAuditEntry(now, cfg, sum), cfg.report); err != nil {
+			fmt.Fprintf(stdout, "Unable to write report: %s\n", err.Error())
+		}
+	}
+	if *output == outputPrometheusTextfile {
+		if err := writeTextfile(*outputFile, sum, !cfg.deleteJobs, start, time.Since(start)); err != nil {
+			fmt.Fprintf(stdout, "Unable to write metrics: %s\n", err.Error())
+		}
+	}
+	if tracer != nil {
+		if err := tracer.export(*otlpEndpoint, *kubeHTTPTimeout, sum, !cfg.deleteJobs); err != nil {
+			fmt.Fprintf(stdout, "Unable to export traces: %s\n", err.Error())
+		}
+	}
+	if *summaryLine {
+		printSummaryLine(stdout, sum, !cfg.deleteJobs, time.Since(start))
+	}
+	if interrupted() {
+		fmt.Fprintln(stdout, "Interrupted, the run is incomplete.")
+		os.Exit(exitInterrupted)
+	}
+	if cfg.stopped(sum) {
+		fmt.Fprintln(stdout, "Stopped at the first error (-fail-fast).")
+		os.Exit(1)
+	}
+	if warnings := thresholdWarnings(sum, *errorExitThreshold, *deleteWarnThreshold); len(warnings) > 0 {
+		for _, w := range warnings {
+			fmt.Fprintf(stdout, "WARNING: %s.\n", w)
+		}
+		os.Exit(exitThreshold)
+	}
 }
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestKubeJobSetNamespaces(t *testing.T) {
+	js := make(kubeJobSet)
+	js.Add("backup", kubePod{name: "backup-a", namespace: "team-a"})
+	js.Add("backup", kubePod{name: "backup-b1", namespace: "team-b"})
+	js.Add("backup", kubePod{name: "backup-b2", namespace: "team-b"})
+	if len(js) != 2 {
+		t.Fatalf("%d jobs, want 2: %v", len(js), js)
+	}
+	for key, want := range map[string]int{"team-a/backup": 1, "team-b/backup": 2} {
+		if n := len(js[key]); n != want {
+			t.Errorf("%s has %d pods, want %d", key, n, want)
+		}
+		ns, name := splitJobKey(key)
+		for _, p := range js[key] {
+			if p.namespace != ns {
+				t.Errorf("%s holds pod %s of namespace %s", key, p.name, p.namespace)
+			}
+		}
+		if name != "backup" {
+			t.Errorf("splitJobKey(%q) name = %q, want backup", key, name)
+		}
+	}
+}
+
+func TestStopped(t *testing.T) {
+	cfg := &runConfig{}
+	if cfg.stopped(&runSummary{Errors: 3}) {
+		t.Error("run without -fail-fast stopped on errors")
+	}
+	cfg.failFast = true
+	if cfg.stopped(&runSummary{}) {
+		t.Error("-fail-fast run stopped without errors")
+	}
+	if !cfg.stopped(&runSummary{Errors: 1}) {
+		t.Error("-fail-fast run didn't stop on an error")
+	}
+}
+
+func TestFailFastCleanup(t *testing.T) {
+	for _, failFast := range []bool{false, true} {
+		broken := completedJob("ns", "a-broken", 5)
+		fine := completedJob("ns", "b-fine", 5)
+		api, client := newFakeAPI(t)
+		api.serveJobs(fine)
+		api.handle("DELETE", "/apis/batch/v1/namespaces/ns/jobs/a-broken", func(w http.ResponseWriter, r *http.Request) {
+			writeStatus(w, r, http.StatusInternalServerError, "etcd unavailable")
+		})
+		api.servePods("ns")
+		cfg := testConfig()
+		cfg.deleteJobs = true
+		cfg.failFast = failFast
+		sum := &runSummary{}
+		cleanupJobs(client, []kubeJob{newKubeJob(broken, testNow), newKubeJob(fine, testNow)}, cfg, sum, 1)
+		want := 1
+		if failFast {
+			want = 0
+		}
+		if sum.Errors != 1 || sum.JobsDeleted != want {
+			t.Errorf("-fail-fast=%v: %d errors, %d jobs deleted, want 1 and %d", failFast, sum.Errors, sum.JobsDeleted, want)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Output modes for "-output".
+const (
+	outputText               = "text"
+	outputPrometheusTextfile = "prometheus-textfile"
+)
+
+// writeTextfile writes the run's counters in the Prometheus text exposition
+// format to path, for node_exporter's textfile collector. The file is
+// replaced atomically so the collector never reads a partial file.
+func writeTextfile(path string, s *runSummary, dryRun bool, now time.Time, duration time.Duration) error {
+	var buf bytes.Buffer
+	metric := func(name, help string, value interface{}) {
+		fmt.Fprintf(&buf, "# HELP jobliterator_%s %s\n", name, help)
+		fmt.Fprintf(&buf, "# TYPE jobliterator_%s gauge\n", name)
+		fmt.Fprintf(&buf, "jobliterator_%s %v\n", name, value)
+	}
+	dry := 0
+	if dryRun {
+		dry = 1
+	}
+	metric("jobs_considered", "Jobs listed by the last run.", s.JobsConsidered)
+	metric("jobs_eligible", "Jobs eligible for deletion in the last run.", s.JobsEligible)
+	metric("jobs_deleted", "Jobs deleted by the last run.", s.JobsDeleted)
+	metric("pods_deleted", "Pods of deleted jobs deleted by the last run.", s.PodsDeleted)
+	metric("orphan_pods_deleted", "Orphaned pods deleted by the last run.", s.OrphanPodsDeleted)
+	metric("cronjobs_deleted", "Stale CronJobs deleted by the last run.", s.CronJobsDeleted)
+	metric("errors", "Errors in the last run.", s.Errors)
+	metric("dry_run", "Whether the last run was a dry-run.", dry)
+	metric("last_run_timestamp_seconds", "Time the last run started.", now.Unix())
+	metric("last_run_duration_seconds", "Duration of the last run.", duration.Seconds())
+	fmt.Fprintf(&buf, "# HELP jobliterator_last_run_info The run ID of the last run.\n")
+	fmt.Fprintf(&buf, "# TYPE jobliterator_last_run_info gauge\n")
+	fmt.Fprintf(&buf, "jobliterator_last_run_info{run_id=%q} 1\n", runID)
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".jobliterator-*.prom")
+	if err != nil {
+		return fmt.Errorf("Failed to create metrics file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("Failed to write metrics file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("Failed to write metrics file: %v", err)
+	}
+	// The collector runs as a different user, TempFile creates files only
+	// readable by their owner.
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("Failed to write metrics file: %v", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteTextfile(t *testing.T) {
+	defer func(id string) { runID = id }(runID)
+	runID = "run-1"
+	dir := t.TempDir()
+	path := filepath.Join(dir, "jobliterator.prom")
+	if err := ioutil.WriteFile(path, []byte("stale\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s := &runSummary{JobsConsidered: 10, JobsEligible: 4, JobsDeleted: 3, PodsDeleted: 7, OrphanPodsDeleted: 2, CronJobsDeleted: 1, Errors: 1}
+	if err := writeTextfile(path, s, true, testNow, 1500*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string]string)
+	types := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		switch {
+		case len(fields) == 4 && fields[1] == "TYPE":
+			types[fields[2]] = fields[3]
+		case len(fields) == 2:
+			values[fields[0]] = fields[1]
+		}
+	}
+	want := map[string]string{
+		"jobliterator_jobs_considered":               "10",
+		"jobliterator_jobs_eligible":                 "4",
+		"jobliterator_jobs_deleted":                  "3",
+		"jobliterator_pods_deleted":                  "7",
+		"jobliterator_orphan_pods_deleted":           "2",
+		"jobliterator_cronjobs_deleted":              "1",
+		"jobliterator_errors":                        "1",
+		"jobliterator_dry_run":                       "1",
+		"jobliterator_last_run_timestamp_seconds":    "1710504000",
+		"jobliterator_last_run_duration_seconds":     "1.5",
+		`jobliterator_last_run_info{run_id="run-1"}`: "1",
+	}
+	for name, value := range want {
+		if values[name] != value {
+			t.Errorf("%s = %q, want %q", name, values[name], value)
+		}
+		if base := strings.SplitN(name, "{", 2)[0]; types[base] != "gauge" {
+			t.Errorf("%s has type %q, want gauge", base, types[base])
+		}
+	}
+	if len(values) != len(want) {
+		t.Errorf("Got %d metrics, want %d:\n%s", len(values), len(want), data)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0644 {
+		t.Errorf("Permissions %v, want 0644", perm)
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Temporary files left behind: %d files in %s", len(entries), dir)
+	}
+}
+
+func TestWriteTextfileError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing", "jobliterator.prom")
+	err := writeTextfile(path, &runSummary{}, false, testNow, time.Second)
+	if err == nil || !strings.HasPrefix(err.Error(), "Failed to create metrics file") {
+		t.Errorf("Error = %v, want a failure to create the file", err)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/ericchiang/k8s"
+	apiv1 "github.com/ericchiang/k8s/api/v1"
+)
+
+// isNamespacePattern reports whether the "-namespace" value is a shell-style
+// glob pattern rather than a literal namespace name.
+func isNamespacePattern(kubeNamespace string) bool {
+	return strings.ContainsAny(kubeNamespace, "*?[")
+}
+
+// listNamespaces returns the namespaces to search for jobs. A literal
+// namespace is returned as is, while a glob pattern is expanded against the
+// namespaces in the cluster. With allNamespaces, every namespace is listed
+// individually so that a namespace the client can't read doesn't fail the
+// whole run. If namespaces can't be listed, a single cluster-wide search is
+// used instead.
+func listNamespaces(client *k8s.Client, kubeNamespace string, allNamespaces bool) ([]string, error) {
+	pattern := isNamespacePattern(kubeNamespace)
+	if !allNamespaces && !pattern {
+		return []string{kubeNamespace}, nil
+	}
+	var nsList *apiv1.NamespaceList
+	err := apiRetry.do(func() (err error) {
+		countAPICall(apiList)
+		nsList, err = client.CoreV1().ListNamespaces(context.Background())
+		return err
+	})
+	if err != nil {
+		if isForbidden(err) && !pattern {
+			debugf("Not allowed to list namespaces, listing jobs cluster-wide instead\n")
+			return []string{k8s.AllNamespaces}, nil
+		}
+		return nil, fmt.Errorf("Unable to list namespaces: %v", err)
+	}
+	var names []string
+	for _, ns := range nsList.Items {
+		names = append(names, ns.Metadata.GetName())
+	}
+	if !pattern {
+		return names, nil
+	}
+	return matchNamespaces(names, kubeNamespace)
+}
+
+// checkNamespaceFlags validates the namespace selection. Operating on all
+// namespaces must be asked for explicitly, so an empty "-namespace" (say from
+// an unset variable) can't target the whole cluster by accident.
+func checkNamespaceFlags(kubeNamespace string, allNamespaces bool, namespacesFile string, required bool) error {
+	if kubeNamespace != "" && allNamespaces {
+		return fmt.Errorf("-namespace and -all-namespaces are mutually exclusive")
+	}
+	if namespacesFile != "" {
+		if kubeNamespace != "" || allNamespaces {
+			return fmt.Errorf("-namespaces-file can't be combined with -namespace or -all-namespaces")
+		}
+		return nil
+	}
+	if kubeNamespace == "" && !allNamespaces && required {
+		return fmt.Errorf("-namespace is empty, set a namespace or use -all-namespaces or -namespaces-file")
+	}
+	return nil
+}
+
+// readNamespacesFile reads the namespaces listed in the file, one per line.
+// Blank lines and everything after a "#" are ignored. It's an error for the
+// file to list no namespaces, as that's more likely a mistake than intended.
+func readNamespacesFile(name string) ([]string, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read -namespaces-file: %v", err)
+	}
+	var namespaces []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			namespaces = append(namespaces, line)
+		}
+	}
+	if len(namespaces) == 0 {
+		return nil, fmt.Errorf("-namespaces-file %s lists no namespaces", name)
+	}
+	return namespaces, nil
+}
+
+// printNamespaces prints the namespaces a run would process, one per line,
+// for "-list-namespaces".
+func printNamespaces(w io.Writer, namespaces []string) {
+	for _, ns := range namespaces {
+		if ns == k8s.AllNamespaces {
+			fmt.Fprintln(w, "(all namespaces, not allowed to list them)")
+			continue
+		}
+		fmt.Fprintln(w, ns)
+	}
+	fmt.Fprintf(w, "Total namespaces: %d\n", len(namespaces))
+}
+
+// matchNamespaces returns the names matching the glob pattern, or an error if
+// none match.
+func matchNamespaces(names []string, pattern string) ([]string, error) {
+	var matched []string
+	for _, name := range names {
+		ok, err := path.Match(pattern, name)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid namespace pattern %q: %v", pattern, err)
+		}
+		if ok {
+			matched = append(matched, name)
+		}
+	}
+	if len(matched) == 0 {
+		return nil, fmt.Errorf("No namespaces match %q", pattern)
+	}
+	return matched, nil
+}
+
+// namespacesWithJobs returns the namespaces at least one of the jobs is in,
+// and how many were left out. A cluster-wide scan is a single call and is
+// kept as is.
+func namespacesWithJobs(namespaces []string, jobs []kubeJob) ([]string, int) {
+	if len(namespaces) == 1 && namespaces[0] == k8s.AllNamespaces {
+		return namespaces, 0
+	}
+	withJobs := make(map[string]bool)
+	for _, dj := range jobs {
+		withJobs[dj.namespace] = true
+	}
+	var kept []string
+	for _, ns := range namespaces {
+		if withJobs[ns] {
+			kept = append(kept, ns)
+		}
+	}
+	return kept, len(namespaces) - len(kept)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/ericchiang/k8s"
+)
+
+func TestMatchNamespaces(t *testing.T) {
+	names := []string{"default", "team-a", "team-b", "team-a-staging", "kube-system"}
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"team-*", []string{"team-a", "team-b", "team-a-staging"}},
+		{"team-?", []string{"team-a", "team-b"}},
+		{"default", []string{"default"}},
+		{"*-system", []string{"kube-system"}},
+	}
+	for _, tt := range tests {
+		got, err := matchNamespaces(names, tt.pattern)
+		if err != nil {
+			t.Errorf("%q: %v", tt.pattern, err)
+			continue
+		}
+		if !equalStrings(got, tt.want) {
+			t.Errorf("%q matched %v, want %v", tt.pattern, got, tt.want)
+		}
+	}
+	if _, err := matchNamespaces(names, "prod-*"); err == nil {
+		t.Error("expected an error when nothing matches")
+	}
+	if _, err := matchNamespaces(names, "team-["); err == nil {
+		t.Error("expected an error for an invalid pattern")
+	}
+}
+
+func TestNamespacesWithJobs(t *testing.T) {
+	jobs := []kubeJob{{namespace: "a", name: "x"}, {namespace: "c", name: "y"}, {namespace: "a", name: "z"}, {namespace: "elsewhere", name: "w"}}
+	tests := []struct {
+		name       string
+		namespaces []string
+		jobs       []kubeJob
+		want       []string
+		skipped    int
+	}{
+		{"some without jobs", []string{"a", "b", "c", "d"}, jobs, []string{"a", "c"}, 2},
+		{"all with jobs", []string{"c", "a"}, jobs, []string{"c", "a"}, 0},
+		{"no eligible jobs", []string{"a", "b"}, nil, nil, 2},
+		{"cluster-wide", []string{k8s.AllNamespaces}, nil, []string{k8s.AllNamespaces}, 0},
+	}
+	for _, tt := range tests {
+		got, skipped := namespacesWithJobs(tt.namespaces, tt.jobs)
+		if !equalStrings(got, tt.want) || skipped != tt.skipped {
+			t.Errorf("%s: %v and %d skipped, want %v and %d", tt.name, got, skipped, tt.want, tt.skipped)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/ericchiang/k8s"
+	apiv1 "github.com/ericchiang/k8s/api/v1"
+)
+
+// deadNodes caches which nodes no longer exist for "-handle-dead-nodes", so
+// each node is looked up once per run.
+var deadNodes = &nodeCache{nodes: make(map[string]nodeLookup)}
+
+// nodeCache records which nodes were found to exist or not. It's safe for
+// concurrent use by the job workers.
+type nodeCache struct {
+	mu    sync.Mutex
+	nodes map[string]nodeLookup
+}
+
+// nodeLookup is the outcome of getting a node. Failed lookups, e.g. without
+// permission to get nodes, are cached too, so they aren't retried for every
+// pod on the node.
+type nodeLookup struct {
+	gone bool
+	err  error
+}
+
+// onDeadNode reports whether the pod was scheduled to a node that no longer
+// exists. Pods that were never scheduled aren't on a dead node.
+func (c *nodeCache) onDeadNode(client *k8s.Client, p *apiv1.Pod) (bool, error) {
+	node := p.Spec.GetNodeName()
+	if node == "" {
+		return false, nil
+	}
+	c.mu.Lock()
+	l, ok := c.nodes[node]
+	c.mu.Unlock()
+	if ok {
+		return l.gone, l.err
+	}
+	// The lock isn't held during the request so workers checking other
+	// nodes aren't held up. Concurrent lookups of the same node may both get
+	// it, which is harmless.
+	countAPICall(apiGet)
+	_, err := client.CoreV1().GetNode(context.Background(), node)
+	l = nodeLookup{gone: isNotFound(err)}
+	if err != nil && !l.gone {
+		l.err = fmt.Errorf("Unable to get node %s: %v", node, err)
+	}
+	c.mu.Lock()
+	c.nodes[node] = l
+	c.mu.Unlock()
+	return l.gone, l.err
+}
+
+// forceDeletePod deletes the pod without grace period. A pod on a dead node
+// never terminates otherwise, as no kubelet is left to confirm it.
+func forceDeletePod(client *k8s.Client, kp kubePod) error {
+	opts := deleteOptions{Kind: "DeleteOptions", APIVersion: "v1", GracePeriodSeconds: k8s.Int(0)}
+	err := deleteWithOptions(context.Background(), client, podPath(kp), opts)
+	if isNotFound(err) {
+		return nil
+	}
+	return err
+}
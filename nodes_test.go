@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ericchiang/k8s"
+	apiv1 "github.com/ericchiang/k8s/api/v1"
+	metav1 "github.com/ericchiang/k8s/apis/meta/v1"
+)
+
+// podOnNode returns a pod scheduled to the node.
+func podOnNode(node string) *apiv1.Pod {
+	return &apiv1.Pod{Metadata: &metav1.ObjectMeta{Name: k8s.String("p")}, Spec: &apiv1.PodSpec{NodeName: k8s.String(node)}}
+}
+
+func TestOnDeadNodeCachesLookups(t *testing.T) {
+	api, client := newFakeAPI(t)
+	api.handle("GET", "/api/v1/nodes/alive", func(w http.ResponseWriter, r *http.Request) {
+		writeObject(w, r, &apiv1.Node{Metadata: &metav1.ObjectMeta{Name: k8s.String("alive")}})
+	})
+	api.handle("GET", "/api/v1/nodes/secret", func(w http.ResponseWriter, r *http.Request) {
+		writeStatus(w, r, http.StatusForbidden, "forbidden")
+	})
+	c := &nodeCache{nodes: make(map[string]nodeLookup)}
+	tests := []struct {
+		node    string
+		dead    bool
+		wantErr bool
+	}{
+		{"alive", false, false},
+		{"gone", true, false},
+		{"secret", false, true},
+		{"", false, false},
+	}
+	for i := 0; i < 2; i++ {
+		for _, tt := range tests {
+			dead, err := c.onDeadNode(client, podOnNode(tt.node))
+			if dead != tt.dead || (err != nil) != tt.wantErr {
+				t.Errorf("Node %q: dead = %v, err = %v, want %v and error %v", tt.node, dead, err, tt.dead, tt.wantErr)
+			}
+		}
+	}
+	for _, node := range []string{"alive", "gone", "secret"} {
+		if n := api.count("GET", "/api/v1/nodes/"+node); n != 1 {
+			t.Errorf("Node %s looked up %d times, want once", node, n)
+		}
+	}
+}
+
+func TestOnDeadNodeDoesNotBlockOtherNodes(t *testing.T) {
+	api, client := newFakeAPI(t)
+	release := make(chan struct{})
+	api.handle("GET", "/api/v1/nodes/slow", func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		writeStatus(w, r, http.StatusNotFound, "not found")
+	})
+	c := &nodeCache{nodes: make(map[string]nodeLookup)}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		c.onDeadNode(client, podOnNode("slow"))
+	}()
+	defer wg.Wait()
+	defer close(release)
+
+	// Give the slow lookup time to start.
+	time.Sleep(20 * time.Millisecond)
+	done := make(chan struct{})
+	go func() {
+		c.onDeadNode(client, podOnNode("fast"))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Lookup of another node blocked by a pending one")
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ericchiang/k8s"
+	apiv1 "github.com/ericchiang/k8s/api/v1"
+)
+
+// getOrphanedPods returns the pods in the namespace whose job no longer exists,
+// grouped by job. Only pods matching selector are considered if it's set. With
+// explain, the owner chain of each orphaned pod is printed.
+func getOrphanedPods(client *k8s.Client, kubeNamespace, selector string, jobLabels []string, owner ownerChecker, verifyUID, explain bool) ([]kubeJob, error) {
+	var opJobs []kubeJob
+	opJobSet := make(kubeJobSet)
+	pods := new(apiv1.PodList)
+	podErr := apiRetry.do(func() error {
+		countAPICall(apiList)
+		return listRequest(context.Background(), client, podsPath(kubeNamespace), selectorQuery(selector), pods)
+	})
+	if podErr != nil {
+		return nil, fmt.Errorf("ERROR: %s.", podErr.Error())
+	}
+	if len(pods.Items) == 0 && selector != "" {
+		// Pods carry the labels of the job's pod template, not those of the
+		// job, so a selector for the latter may not match any pods.
+		fmt.Fprintf(stdout, "No pods match %q in namespace %s, check that the pod template has these labels.\n", selector, kubeNamespace)
+	}
+	// A namespace without pods simply has no orphans.
+	for _, p := range pods.Items {
+		jobName, orphaned, err := orphanedJob(client, p, jobLabels, owner, verifyUID)
+		if err != nil {
+			return nil, err
+		}
+		if orphaned {
+			if explain {
+				explainOrphan(client, p, jobLabels)
+			}
+			kp := newKubePod(p)
+			opJobSet.Add(jobName, kp)
+		}
+	}
+	for k, v := range opJobSet {
+		ns, name := splitJobKey(k)
+		opJobs = append(opJobs, kubeJob{name: name, namespace: ns, age: 0, pods: v})
+	}
+	return opJobs, nil
+}
+
+// podsPath returns the API path of the pods in the namespace.
+func podsPath(namespace string) string {
+	if namespace == k8s.AllNamespaces {
+		return "/api/v1/pods"
+	}
+	return "/api/v1/namespaces/" + namespace + "/pods"
+}
+
+// orphanedJob checks the pod's job label keys in order and reports whether
+// none of them resolve to an existing owner, along with the job name from the
+// first key present on the pod. Pods without any of the keys aren't job pods
+// and are never orphaned. With verifyUID, an owner whose UID differs from the
+// pod's owner reference is a different object reusing the name, and the pod
+// is orphaned.
+func orphanedJob(client *k8s.Client, p *apiv1.Pod, jobLabels []string, owner ownerChecker, verifyUID bool) (string, bool, error) {
+	pl := p.Metadata.GetLabels()
+	jobName := ""
+	for _, key := range jobLabels {
+		val, ok := pl[key]
+		if !ok {
+			continue
+		}
+		if jobName == "" {
+			jobName = val
+		}
+		uid := ""
+		if verifyUID {
+			uid = ownerUID(p, val)
+		}
+		exists, err := owner.exists(client, p.Metadata.GetNamespace(), val, uid)
+		if exists {
+			return val, false, nil
+		}
+		if isForbidden(err) {
+			// Ownership can't be determined, which doesn't make the pod an
+			// orphan.
+			fmt.Fprintf(stdout, "Not allowed to get the owner %s of pod %s in namespace %s, skipping the pod.\n", val, p.Metadata.GetName(), p.Metadata.GetNamespace())
+			return "", false, nil
+		}
+		if err != nil {
+			// The checkers report a missing owner without an error, any
+			// other failure says nothing about the owner.
+			return "", false, fmt.Errorf("Error getting job: %s", err.Error())
+		}
+	}
+	return jobName, jobName != "", nil
+}
+
+// orphanKept returns why the orphaned pod must be kept despite being finished,
+// or an empty string if it may be deleted. This is the final gate for pods
+// that might still be in use, e.g. by a debugging session.
+func orphanKept(kp kubePod, cfg *runConfig, now time.Time) string {
+	if _, ok := kp.annotations[cfg.orphanKeepAnnotation]; ok && cfg.orphanKeepAnnotation != "" {
+		return "it has the " + cfg.orphanKeepAnnotation + " annotation"
+	}
+	if age := now.Sub(kp.started); age < cfg.orphanMinAge {
+		return fmt.Sprintf("it started %v ago, less than -orphan-min-age", age.Truncate(time.Second))
+	}
+	return ""
+}
+
+// parseOrphanPhases parses the "-orphan-phases" flag value, a comma-separated
+// list of pod phases. Running pods are never deleted as orphans.
+func parseOrphanPhases(val string) (map[string]bool, error) {
+	phases := make(map[string]bool)
+	for _, phase := range strings.Split(val, ",") {
+		switch phase = strings.TrimSpace(phase); phase {
+		case "Pending", "Succeeded", "Failed", "Unknown":
+			phases[phase] = true
+		default:
+			return nil, fmt.Errorf("Invalid -orphan-phases phase %q, expected Pending, Succeeded, Failed or Unknown", phase)
+		}
+	}
+	return phases, nil
+}
+
+// hasDeletablePod reports whether any of the pods is in one of the phases.
+func hasDeletablePod(pods []kubePod, phases map[string]bool) bool {
+	for _, p := range pods {
+		if phases[p.phase] {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanupOrphans searches for pods whose job no longer exists and, if
+// cfg.deleteJobs is set, deletes those in one of cfg.orphanPhases. The
+// outcome is recorded in sum.
+func cleanupOrphans(client *k8s.Client, namespaces []string, cfg *runConfig, sum *runSummary) {
+	opCount := 0
+	fmt.Fprintln(stdout, "==============================")
+	fmt.Fprintln(stdout, "Searching for orphaned pods...")
+	fmt.Fprintln(stdout, "==============================")
+	var opJobs []kubeJob
+	for _, ns := range namespaces {
+		tracer.startNamespace("scan orphans", ns)
+		nsJobs, err := getOrphanedPods(client, ns, cfg.orphanSelector, cfg.jobLabels, cfg.owner, cfg.ownerUIDVerify, cfg.explainOrphans)
+		tracer.endNamespace()
+		if err != nil {
+			fmt.Fprintf(stdout, "Error fetching orphaned pods: %s", err.Error())
+			sum.Errors++
+			continue
+		}
+		opJobs = append(opJobs, nsJobs...)
+	}
+	for _, j := range opJobs {
+		if cfg.quiet() && !hasDeletablePod(j.pods, cfg.orphanPhases) {
+			continue
+		}
+		w := cfg.scopedWriter(stdout, j.namespace, j.name)
+		cfg.printItem(w, jobItem(j, actionOrphaned), "Job: %s\tNamespace: %s\n", j.name, j.namespace)
+		if len(j.pods) < 1 {
+			fmt.Fprintf(w, "Unable to find any pods associated with job %s.\n", j.name)
+			continue
+		}
+		deleted := 0
+		for _, op := range j.pods {
+			if cfg.orphanPhases[op.phase] {
+				if reason := orphanKept(op, cfg, time.Now()); reason != "" {
+					if !cfg.quiet() {
+						fmt.Fprintf(w, "\tKeeping pod %s, %s.\n", op.name, reason)
+					}
+					continue
+				}
+				opCount++
+				if !cfg.deleteJobs {
+					cfg.printItem(w, podItem(op, actionWouldDelete), "\tPod: %s\tNamespace: %s\tPhase: %s\n", op.name, op.namespace, op.phase)
+					sum.OrphanPodsDeleted++
+					sum.deleted(reasonOrphanCleanup)
+					continue
+				}
+				if err := checkDisruption(client, op, cfg); err != nil {
+					fmt.Fprintf(w, "\tNot deleting pod %s. %s.\n", op.name, err.Error())
+					sum.Errors++
+					if cfg.stopped(sum) {
+						return
+					}
+					continue
+				}
+				if err := savePodLogs(client, op, cfg); err != nil {
+					fmt.Fprintf(w, "\tUnable to save logs of pod %s, not deleting it. Error: %s\n", op.name, err.Error())
+					sum.Errors++
+					if cfg.stopped(sum) {
+						return
+					}
+					continue
+				}
+				cfg.printItem(w, podItem(op, actionDelete), "\tDeleting pod: %s\tNamespace: %s\tPhase: %s\n", op.name, op.namespace, op.phase)
+				podErr := deletePod(client, op, cfg, w)
+				if podErr != nil {
+					fmt.Fprintf(w, "\tUnable to delete pod %s. Error: %s\n", op.name, podErr.Error())
+					sum.Errors++
+					if cfg.stopped(sum) {
+						return
+					}
+					continue
+				}
+				sum.OrphanPodsDeleted++
+				sum.deleted(reasonOrphanCleanup)
+				deleted++
+				cfg.progress(w, deleted, len(j.pods), j.name)
+			} else if !cfg.quiet() {
+				fmt.Fprintf(w, "\tPod %s is not in one of the -orphan-phases but appears oprhaned.\n", op.name)
+				fmt.Fprintf(w, "\tPod %s is in phase %s, skipping.\n", op.name, op.phase)
+			}
+		}
+	}
+	fmt.Fprintf(stdout, "Total orphaned Pods: %v beloning to %v jobs.\n", opCount, len(opJobs))
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ericchiang/k8s"
+	batchv1 "github.com/ericchiang/k8s/apis/batch/v1"
+)
+
+func TestOrphanedJobOwnerErrors(t *testing.T) {
+	j := completedJob("ns", "backup", 5)
+	p := testPod(j, "backup-1", "Succeeded")
+	path := "/apis/batch/v1/namespaces/ns/jobs/backup"
+	tests := []struct {
+		name     string
+		status   int
+		orphaned bool
+		wantErr  bool
+	}{
+		{"exists", http.StatusOK, false, false},
+		{"missing", http.StatusNotFound, true, false},
+		{"server error", http.StatusInternalServerError, false, true},
+		{"unauthorized", http.StatusUnauthorized, false, true},
+		// Without permission to get the job the pod is skipped, not
+		// orphaned.
+		{"forbidden", http.StatusForbidden, false, false},
+	}
+	for _, tt := range tests {
+		api, client := newFakeAPI(t)
+		status := tt.status
+		api.handle("GET", path, func(w http.ResponseWriter, r *http.Request) {
+			if status == http.StatusOK {
+				writeObject(w, r, j)
+				return
+			}
+			writeStatus(w, r, status, http.StatusText(status))
+		})
+		_, orphaned, err := orphanedJob(client, p, []string{"job-name"}, jobOwner{}, false)
+		if orphaned != tt.orphaned || (err != nil) != tt.wantErr {
+			t.Errorf("%s: orphaned = %v, err = %v, want %v and error %v", tt.name, orphaned, err, tt.orphaned, tt.wantErr)
+		}
+	}
+}
+
+func TestOrphanKept(t *testing.T) {
+	cfg := testConfig()
+	cfg.orphanKeepAnnotation = "example.com/debugging"
+	cfg.orphanMinAge = time.Hour
+	now := testNow
+	tests := []struct {
+		name string
+		pod  kubePod
+		kept bool
+	}{
+		{"old", kubePod{started: now.Add(-2 * time.Hour)}, false},
+		{"recently started", kubePod{started: now.Add(-time.Minute)}, true},
+		{"annotated", kubePod{started: now.Add(-2 * time.Hour), annotations: map[string]string{"example.com/debugging": ""}}, true},
+		{"other annotation", kubePod{started: now.Add(-2 * time.Hour), annotations: map[string]string{"example.com/other": ""}}, false},
+	}
+	for _, tt := range tests {
+		if reason := orphanKept(tt.pod, cfg, now); (reason != "") != tt.kept {
+			t.Errorf("%s: kept = %q, want kept %v", tt.name, reason, tt.kept)
+		}
+	}
+	if reason := orphanKept(kubePod{started: now, annotations: map[string]string{"": ""}}, testConfig(), now); reason != "" {
+		t.Errorf("kept %q without -orphan-keep-annotation or -orphan-min-age", reason)
+	}
+}
+
+func TestCleanupOrphansKeepsFreshPods(t *testing.T) {
+	gone := completedJob("ns", "gone", 5)
+	old := testPod(gone, "gone-old", "Succeeded")
+	fresh := testPod(gone, "gone-fresh", "Succeeded")
+	fresh.Status.StartTime = metaTime(time.Now())
+	api, client := newFakeAPI(t)
+	api.servePods("ns", old, fresh)
+	cfg := testConfig()
+	cfg.deleteJobs = true
+	cfg.owner = jobOwner{}
+	cfg.orphanPhases = map[string]bool{"Succeeded": true, "Failed": true}
+	cfg.orphanMinAge = time.Hour
+	sum := &runSummary{}
+	cleanupOrphans(client, []string{"ns"}, cfg, sum)
+	if sum.OrphanPodsDeleted != 1 {
+		t.Errorf("deleted %d orphaned pods, want 1", sum.OrphanPodsDeleted)
+	}
+	if api.count("DELETE", "/api/v1/namespaces/ns/pods/gone-fresh") != 0 {
+		t.Error("recently started orphan was deleted")
+	}
+}
+
+func TestOrphanedJobNameReuse(t *testing.T) {
+	old := completedJob("ns", "backup", 5)
+	p := testPod(old, "backup-1", "Succeeded")
+	reused := completedJob("ns", "backup", 1)
+	reused.Metadata.Uid = k8s.String("recreated")
+	tests := []struct {
+		name      string
+		job       *batchv1.Job
+		verifyUID bool
+		orphaned  bool
+	}{
+		{"same job", old, true, false},
+		{"name reused", reused, true, true},
+		{"name reused without -owner-uid-verify", reused, false, false},
+	}
+	for _, tt := range tests {
+		api, client := newFakeAPI(t)
+		api.serveJobs(tt.job)
+		jobName, orphaned, err := orphanedJob(client, p, []string{"job-name"}, jobOwner{}, tt.verifyUID)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if orphaned != tt.orphaned || jobName != "backup" {
+			t.Errorf("%s: job %q orphaned = %v, want backup orphaned %v", tt.name, jobName, orphaned, tt.orphaned)
+		}
+	}
+
+	// A pod created before owner references were set can't be checked.
+	p.Metadata.OwnerReferences = nil
+	api, client := newFakeAPI(t)
+	api.serveJobs(reused)
+	if _, orphaned, _ := orphanedJob(client, p, []string{"job-name"}, jobOwner{}, true); orphaned {
+		t.Error("Pod without an owner reference orphaned by a UID mismatch")
+	}
+}
+
+func TestGetOrphanedPodsForbiddenOwner(t *testing.T) {
+	j := completedJob("ns", "backup", 5)
+	api, client := newFakeAPI(t)
+	api.servePods("ns", testPod(j, "backup-1", "Succeeded"))
+	api.handle("GET", "/apis/batch/v1/namespaces/ns/jobs/backup", func(w http.ResponseWriter, r *http.Request) {
+		writeStatus(w, r, http.StatusForbidden, "forbidden")
+	})
+	out := captureStdout(t)
+	jobs, err := getOrphanedPods(client, "ns", "", []string{"job-name"}, jobOwner{}, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 0 {
+		t.Errorf("%d orphaned jobs, want none when the owner can't be read", len(jobs))
+	}
+	if !strings.Contains(out.String(), "Not allowed to get the owner backup of pod backup-1") {
+		t.Errorf("Skipped pod not reported, output: %q", out.String())
+	}
+}
+
+func TestParseOrphanPhases(t *testing.T) {
+	phases, err := parseOrphanPhases(" Succeeded,Failed , Pending")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(phases) != 3 || !phases["Succeeded"] || !phases["Failed"] || !phases["Pending"] {
+		t.Errorf("Phases %v, want Succeeded, Failed and Pending", phases)
+	}
+	for _, val := range []string{"Running", "Succeeded,Running", "succeeded", "Succeeded,,Failed", ""} {
+		if _, err := parseOrphanPhases(val); err == nil {
+			t.Errorf("%q accepted", val)
+		}
+	}
+}
+
+func TestHasDeletablePod(t *testing.T) {
+	phases := map[string]bool{"Succeeded": true, "Failed": true}
+	tests := []struct {
+		name string
+		pods []kubePod
+		want bool
+	}{
+		{"none", nil, false},
+		{"running", []kubePod{{phase: "Running"}, {phase: "Pending"}}, false},
+		{"one finished", []kubePod{{phase: "Running"}, {phase: "Failed"}}, true},
+	}
+	for _, tt := range tests {
+		if got := hasDeletablePod(tt.pods, phases); got != tt.want {
+			t.Errorf("%s: deletable = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"text/template"
+)
+
+// Actions reported to "-template" as .Action.
+const (
+	actionDelete      = "delete"
+	actionWouldDelete = "would-delete"
+	actionKeep        = "keep"
+	actionOrphaned    = "orphaned"
+)
+
+// outputItem is a job or pod as seen by a "-template" template, and as
+// written to the "-report-json" report.
+type outputItem struct {
+	// Kind is "job" or "pod".
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	// Age is in days.
+	Age int `json:"age_days"`
+	// Phase is only set for pods.
+	Phase  string `json:"phase,omitempty"`
+	Action string `json:"action"`
+}
+
+func jobItem(kj kubeJob, action string) outputItem {
+	return outputItem{Kind: "job", Name: kj.name, Namespace: kj.namespace, Age: kj.age, Action: action}
+}
+
+func podItem(kp kubePod, action string) outputItem {
+	return outputItem{Kind: "pod", Name: kp.name, Namespace: kp.namespace, Age: kp.age, Phase: kp.phase, Action: action}
+}
+
+// parseTemplate parses the "-template" flag value. An empty value returns a nil
+// template, meaning the default format is used.
+func parseTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	tmpl, err := template.New("output").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid -template: %v", err)
+	}
+	// Catch references to unknown fields before doing any work.
+	if err := tmpl.Execute(ioutil.Discard, outputItem{}); err != nil {
+		return nil, fmt.Errorf("Invalid -template: %v", err)
+	}
+	return tmpl, nil
+}
+
+// printItem writes the item using the "-template" template followed by a
+// newline, or using format and a if no template is set. With
+// "-print-kubectl" items that are deleted are followed by the equivalent
+// kubectl command. The item is also recorded for "-report-json".
+func (c *runConfig) printItem(w io.Writer, item outputItem, format string, a ...interface{}) {
+	c.report.add(item)
+	if c.template == nil {
+		fmt.Fprintf(w, format, a...)
+	} else {
+		if err := c.template.Execute(w, item); err != nil {
+			fmt.Fprintf(w, "Unable to execute -template: %v", err)
+		}
+		fmt.Fprintln(w)
+	}
+	if c.printKubectl && (item.Action == actionDelete || item.Action == actionWouldDelete) {
+		fmt.Fprintln(w, kubectlCommand(item))
+	}
+}
+
+// kubectlCommand returns the kubectl command deleting the item. Pods of a job
+// may already be gone by the time the command runs, so missing objects are
+// ignored.
+func kubectlCommand(item outputItem) string {
+	return fmt.Sprintf("kubectl delete %s -n %s %s --ignore-not-found",
+		item.Kind, shellQuote(item.Namespace), shellQuote(item.Name))
+}
+
+// shellQuote returns s quoted for a POSIX shell. Names the API server accepts
+// never need quoting, so they're returned as is.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789.-_") == "" {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseTemplate(t *testing.T) {
+	tmpl, err := parseTemplate("")
+	if err != nil || tmpl != nil {
+		t.Errorf("parseTemplate(\"\") = %v, %v, want no template", tmpl, err)
+	}
+	if _, err := parseTemplate("{{.Kind}} {{.Namespace}}/{{.Name}} {{.Age}} {{.Phase}} {{.Action}}"); err != nil {
+		t.Errorf("Valid template rejected: %v", err)
+	}
+	for _, text := range []string{"{{.Missing}}", "{{.Name"} {
+		_, err := parseTemplate(text)
+		if err == nil || !strings.HasPrefix(err.Error(), "Invalid -template: ") {
+			t.Errorf("parseTemplate(%q) error = %v, want an invalid -template error", text, err)
+		}
+	}
+}
+
+func TestPrintItem(t *testing.T) {
+	item := outputItem{Kind: "job", Name: "backup", Namespace: "ns", Age: 3, Action: actionDelete}
+	tmpl, err := parseTemplate("{{.Action}} {{.Kind}} {{.Namespace}}/{{.Name}} {{.Age}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name string
+		cfg  *runConfig
+		item outputItem
+		want string
+	}{
+		{"default format", &runConfig{}, item, "Deleting job: backup\n"},
+		{"template", &runConfig{template: tmpl}, item, "delete job ns/backup 3\n"},
+		{"print kubectl", &runConfig{printKubectl: true}, item,
+			"Deleting job: backup\nkubectl delete job -n ns backup --ignore-not-found\n"},
+		{"print kubectl kept", &runConfig{printKubectl: true}, outputItem{Kind: "job", Name: "backup", Namespace: "ns", Action: actionKeep},
+			"Deleting job: backup\n"},
+	} {
+		var out bytes.Buffer
+		tc.cfg.report = &itemRecorder{}
+		tc.cfg.printItem(&out, tc.item, "Deleting job: %s\n", tc.item.Name)
+		if out.String() != tc.want {
+			t.Errorf("%s: output %q, want %q", tc.name, out.String(), tc.want)
+		}
+		if len(tc.cfg.report.items) != 1 || tc.cfg.report.items[0] != tc.item {
+			t.Errorf("%s: recorded %+v, want the item", tc.name, tc.cfg.report.items)
+		}
+	}
+	// Without -report-json the recorder is nil.
+	(&runConfig{}).printItem(&bytes.Buffer{}, item, "\n")
+}
+
+func TestKubectlCommandQuoting(t *testing.T) {
+	for _, tc := range []struct {
+		namespace, name, want string
+	}{
+		{"ns", "backup-1.v2", "kubectl delete job -n ns backup-1.v2 --ignore-not-found"},
+		{"my ns", "a;rm -rf /", "kubectl delete job -n 'my ns' 'a;rm -rf /' --ignore-not-found"},
+		{"ns", "it's", `kubectl delete job -n ns 'it'\''s' --ignore-not-found`},
+		{"", "$(id)", "kubectl delete job -n '' '$(id)' --ignore-not-found"},
+	} {
+		got := kubectlCommand(outputItem{Kind: "job", Namespace: tc.namespace, Name: tc.name})
+		if got != tc.want {
+			t.Errorf("kubectlCommand(%q, %q) = %s, want %s", tc.namespace, tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestItemRecorder(t *testing.T) {
+	var nilRecorder *itemRecorder
+	nilRecorder.add(outputItem{Name: "ignored"})
+
+	r := &itemRecorder{}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r.add(outputItem{Kind: "pod", Name: fmt.Sprintf("pod-%d", i)})
+		}(i)
+	}
+	wg.Wait()
+	if len(r.items) != 10 {
+		t.Errorf("Recorded %d items, want 10", len(r.items))
+	}
+}
+
+func TestWriteJSONReport(t *testing.T) {
+	defer func(id string) { runID = id }(runID)
+	runID = "run-1"
+	dir := t.TempDir()
+	path := filepath.Join(dir, "report.json")
+	if err := ioutil.WriteFile(path, []byte("previous run"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	r := &itemRecorder{}
+	r.add(outputItem{Kind: "job", Name: "backup", Namespace: "ns", Age: 12, Action: actionDelete})
+	r.add(outputItem{Kind: "pod", Name: "backup-1", Namespace: "ns", Age: 12, Phase: "Succeeded", Action: actionDelete})
+	cfg := testConfig()
+	cfg.deleteJobs = true
+	entry := newAuditEntry(testNow, cfg, &runSummary{JobsConsidered: 5, JobsEligible: 1, JobsDeleted: 1, PodsDeleted: 1})
+	if err := writeJSONReport(path, entry, r); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report map[string]interface{}
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("Invalid report %s: %v", data, err)
+	}
+	for key, want := range map[string]interface{}{
+		"time":            "2024-03-15T12:00:00Z",
+		"mode":            "delete",
+		"run_id":          "run-1",
+		"jobs_considered": 5.0,
+		"jobs_eligible":   1.0,
+		"jobs_deleted":    1.0,
+		"pods_deleted":    1.0,
+		"errors":          0.0,
+	} {
+		if report[key] != want {
+			t.Errorf("%s = %v, want %v", key, report[key], want)
+		}
+	}
+	wantItems := []interface{}{
+		map[string]interface{}{"kind": "job", "name": "backup", "namespace": "ns", "age_days": 12.0, "action": "delete"},
+		map[string]interface{}{"kind": "pod", "name": "backup-1", "namespace": "ns", "age_days": 12.0, "phase": "Succeeded", "action": "delete"},
+	}
+	if !reflect.DeepEqual(report["items"], wantItems) {
+		t.Errorf("items = %v, want %v", report["items"], wantItems)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0644 {
+		t.Errorf("Permissions %v, want 0644", perm)
+	}
+	if entries, _ := ioutil.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("Temporary files left behind: %d files in %s", len(entries), dir)
+	}
+}
+
+func TestWriteJSONReportEmpty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.json")
+	if err := writeJSONReport(path, newAuditEntry(testNow, testConfig(), &runSummary{}), &itemRecorder{}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte(`"items": []`)) || !bytes.Contains(data, []byte(`"mode": "dry-run"`)) {
+		t.Errorf("Report %s doesn't have an empty items list in dry-run", data)
+	}
+}
+
+func TestWriteJSONReportError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing", "report.json")
+	err := writeJSONReport(path, auditEntry{}, &itemRecorder{})
+	if err == nil || !strings.HasPrefix(err.Error(), "Failed to create report file") {
+		t.Errorf("Error = %v, want a failure to create the file", err)
+	}
+}
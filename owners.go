@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/ericchiang/k8s"
+	apiv1 "github.com/ericchiang/k8s/api/v1"
+)
+
+// ownerChecker decides whether the owner a pod's job label refers to still
+// exists, which is what makes a pod orphaned or not.
+type ownerChecker interface {
+	// exists reports whether the owner named name exists in namespace. If
+	// uid isn't empty, an owner with a different UID is a new object that
+	// reuses the name and doesn't count. API errors other than 404 are
+	// returned.
+	exists(client *k8s.Client, namespace, name, uid string) (bool, error)
+}
+
+// jobOwner checks for a batch/v1 Job.
+type jobOwner struct{}
+
+func (jobOwner) exists(client *k8s.Client, namespace, name, uid string) (bool, error) {
+	countAPICall(apiGet)
+	j, err := client.BatchV1().GetJob(context.Background(), name, namespace)
+	if isNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return uid == "" || j.Metadata.GetUid() == uid, nil
+}
+
+// resourceOwner checks for an arbitrary namespaced resource, e.g. a custom
+// resource of a CRD-based job system.
+type resourceOwner struct {
+	group    string
+	version  string
+	resource string
+}
+
+func (o resourceOwner) exists(client *k8s.Client, namespace, name, uid string) (bool, error) {
+	path := fmt.Sprintf("/apis/%s/%s/namespaces/%s/%s/%s", o.group, o.version, namespace, o.resource, name)
+	if o.group == "" {
+		path = fmt.Sprintf("/api/%s/namespaces/%s/%s/%s", o.version, namespace, o.resource, name)
+	}
+	countAPICall(apiGet)
+	body, err := rawRequest(context.Background(), client, "GET", path, nil)
+	if isNotFound(err) {
+		return false, nil
+	}
+	if err != nil || uid == "" {
+		return err == nil, err
+	}
+	var obj struct {
+		Metadata struct {
+			UID string `json:"uid"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(body, &obj); err != nil {
+		return false, fmt.Errorf("Unable to decode %s %s: %v", o.resource, name, err)
+	}
+	return obj.Metadata.UID == uid, nil
+}
+
+// ownerUID returns the UID of the pod's owner reference named name, or an
+// empty string if the pod has none.
+func ownerUID(p *apiv1.Pod, name string) string {
+	for _, ref := range p.Metadata.GetOwnerReferences() {
+		if ref.GetName() == name {
+			return ref.GetUid()
+		}
+	}
+	return ""
+}
+
+// ownerPreset is a known job system: the pod label naming the owner and the
+// owner's resource.
+type ownerPreset struct {
+	jobLabel string
+	owner    resourceOwner
+}
+
+var ownerPresets = map[string]ownerPreset{
+	"argo": {
+		jobLabel: "workflows.argoproj.io/workflow",
+		owner:    resourceOwner{group: "argoproj.io", version: "v1alpha1", resource: "workflows"},
+	},
+}
+
+// parseOwnerResource parses a "group/version/resource" value, or
+// "version/resource" for the core group.
+func parseOwnerResource(val string) (resourceOwner, error) {
+	parts := strings.Split(val, "/")
+	switch {
+	case len(parts) == 3 && parts[0] != "" && parts[1] != "" && parts[2] != "":
+		return resourceOwner{group: parts[0], version: parts[1], resource: parts[2]}, nil
+	case len(parts) == 2 && parts[0] != "" && parts[1] != "":
+		return resourceOwner{version: parts[0], resource: parts[1]}, nil
+	}
+	return resourceOwner{}, fmt.Errorf("Invalid owner resource %q, expected \"group/version/resource\"", val)
+}
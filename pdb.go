@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ericchiang/k8s"
+)
+
+// podDisruptionBudget is the subset of a policy/v1 or v1beta1
+// PodDisruptionBudget needed to decide whether a pod may be deleted.
+type podDisruptionBudget struct {
+	Metadata struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	Spec struct {
+		Selector *labelSelector `json:"selector"`
+	} `json:"spec"`
+	Status struct {
+		DisruptionsAllowed int `json:"disruptionsAllowed"`
+	} `json:"status"`
+}
+
+type podDisruptionBudgetList struct {
+	Items []podDisruptionBudget `json:"items"`
+}
+
+type labelSelector struct {
+	MatchLabels      map[string]string          `json:"matchLabels"`
+	MatchExpressions []labelSelectorRequirement `json:"matchExpressions"`
+}
+
+type labelSelectorRequirement struct {
+	Key      string   `json:"key"`
+	Operator string   `json:"operator"`
+	Values   []string `json:"values"`
+}
+
+// matches reports whether the labels match the selector. A missing selector
+// matches nothing. An empty one matches everything if emptyMatchesAll is set,
+// as for policy/v1 PodDisruptionBudgets, and nothing otherwise, as for
+// policy/v1beta1.
+func (s *labelSelector) matches(labels map[string]string, emptyMatchesAll bool) bool {
+	if s == nil {
+		return false
+	}
+	if len(s.MatchLabels) == 0 && len(s.MatchExpressions) == 0 {
+		return emptyMatchesAll
+	}
+	for k, v := range s.MatchLabels {
+		if labels[k] != v {
+			return false
+		}
+	}
+	for _, req := range s.MatchExpressions {
+		val, ok := labels[req.Key]
+		switch req.Operator {
+		case "In":
+			if !ok || !containsString(req.Values, val) {
+				return false
+			}
+		case "NotIn":
+			if ok && containsString(req.Values, val) {
+				return false
+			}
+		case "Exists":
+			if !ok {
+				return false
+			}
+		case "DoesNotExist":
+			if ok {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// podFinished reports whether the phase is terminal, i.e. the pod no longer
+// runs anything.
+func podFinished(phase string) bool {
+	return phase == "Succeeded" || phase == "Failed"
+}
+
+// pdbVersion is the policy API version PodDisruptionBudgets are read from. It
+// starts out as v1, available since Kubernetes 1.21, and falls back to
+// v1beta1, which was removed in 1.25, when the server doesn't serve v1.
+var pdbVersion = "v1"
+
+// checkDisruption returns an error if deleting the pod would violate a
+// PodDisruptionBudget covering it. Finished pods don't count towards a budget
+// and are never checked, nor is anything checked with cfg.ignorePDB.
+func checkDisruption(client *k8s.Client, kp kubePod, cfg *runConfig) error {
+	if podFinished(kp.phase) || cfg.ignorePDB {
+		return nil
+	}
+	path := func() string {
+		return fmt.Sprintf("/apis/policy/%s/namespaces/%s/poddisruptionbudgets", pdbVersion, kp.namespace)
+	}
+	countAPICall(apiList)
+	body, err := rawRequest(context.Background(), client, "GET", path(), nil)
+	if isNotFound(err) && pdbVersion == "v1" {
+		debugf("policy/v1 PodDisruptionBudgets aren't served, using policy/v1beta1\n")
+		pdbVersion = "v1beta1"
+		countAPICall(apiList)
+		body, err = rawRequest(context.Background(), client, "GET", path(), nil)
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to list PodDisruptionBudgets: %v", err)
+	}
+	var pdbs podDisruptionBudgetList
+	if err := json.Unmarshal(body, &pdbs); err != nil {
+		return fmt.Errorf("Unable to decode PodDisruptionBudgets: %v", err)
+	}
+	for _, pdb := range pdbs.Items {
+		if pdb.Spec.Selector.matches(kp.labels, pdbVersion == "v1") && pdb.Status.DisruptionsAllowed < 1 {
+			return fmt.Errorf("Deleting it would violate PodDisruptionBudget %s", pdb.Metadata.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// pdbList returns a PodDisruptionBudget list with a single budget.
+func pdbList(name string, selector *labelSelector, allowed int) podDisruptionBudgetList {
+	var pdb podDisruptionBudget
+	pdb.Metadata.Name = name
+	pdb.Spec.Selector = selector
+	pdb.Status.DisruptionsAllowed = allowed
+	return podDisruptionBudgetList{Items: []podDisruptionBudget{pdb}}
+}
+
+func TestCheckDisruptionRestrictive(t *testing.T) {
+	defer func() { pdbVersion = "v1" }()
+	pending := kubePod{name: "backup-1", namespace: "ns", phase: "Pending", labels: map[string]string{"app": "backup"}}
+	tests := []struct {
+		name     string
+		selector *labelSelector
+		allowed  int
+		wantErr  bool
+	}{
+		{"matching, no disruptions allowed", &labelSelector{MatchLabels: map[string]string{"app": "backup"}}, 0, true},
+		{"matching, disruptions allowed", &labelSelector{MatchLabels: map[string]string{"app": "backup"}}, 1, false},
+		{"not matching", &labelSelector{MatchLabels: map[string]string{"app": "web"}}, 0, false},
+		{"empty selector", &labelSelector{}, 0, true},
+		{"no selector", nil, 0, false},
+	}
+	for _, tt := range tests {
+		pdbVersion = "v1"
+		api, client := newFakeAPI(t)
+		list := pdbList("budget", tt.selector, tt.allowed)
+		api.handle("GET", "/apis/policy/v1/namespaces/ns/poddisruptionbudgets", func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, list)
+		})
+		err := checkDisruption(client, pending, testConfig())
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	api, client := newFakeAPI(t)
+	api.handle("GET", "/apis/policy/v1/namespaces/ns/poddisruptionbudgets", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("finished pod checked against budgets")
+	})
+	if err := checkDisruption(client, kubePod{name: "done", namespace: "ns", phase: "Succeeded"}, testConfig()); err != nil {
+		t.Errorf("finished pod: %v", err)
+	}
+}
+
+func TestCheckDisruptionV1beta1Fallback(t *testing.T) {
+	defer func() { pdbVersion = "v1" }()
+	pending := kubePod{name: "backup-1", namespace: "ns", phase: "Pending", labels: map[string]string{"app": "backup"}}
+	api, client := newFakeAPI(t)
+	// Only v1beta1 is served, where an empty selector matches nothing.
+	api.handle("GET", "/apis/policy/v1beta1/namespaces/ns/poddisruptionbudgets", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, pdbList("everything", &labelSelector{}, 0))
+	})
+	if err := checkDisruption(client, pending, testConfig()); err != nil {
+		t.Errorf("empty v1beta1 selector: %v", err)
+	}
+	if pdbVersion != "v1beta1" {
+		t.Errorf("pdbVersion = %q, want v1beta1", pdbVersion)
+	}
+	api.handle("GET", "/apis/policy/v1beta1/namespaces/ns/poddisruptionbudgets", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, pdbList("backup", &labelSelector{MatchLabels: map[string]string{"app": "backup"}}, 0))
+	})
+	if err := checkDisruption(client, pending, testConfig()); err == nil {
+		t.Error("restrictive v1beta1 budget not enforced")
+	}
+	if n := api.count("GET", "/apis/policy/v1/"); n != 1 {
+		t.Errorf("policy/v1 probed %d times, want once", n)
+	}
+}
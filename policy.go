@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+
+	batchv1 "github.com/ericchiang/k8s/apis/batch/v1"
+	"github.com/ghodss/yaml"
+)
+
+// policy holds per-namespace cleanup rules read from a "-policy" file.
+type policy struct {
+	Rules []policyRule `json:"rules"`
+}
+
+// policyRule applies to the namespaces matching any of its Namespaces glob
+// patterns. Unset fields fall back to the flags.
+type policyRule struct {
+	Namespaces []string `json:"namespaces"`
+	// Days overrides "-days".
+	Days *int `json:"days"`
+	// Selector overrides "-selector".
+	Selector string `json:"selector"`
+	// KeepLast keeps the newest KeepLast jobs of the namespace regardless of
+	// their age.
+	KeepLast int `json:"keepLast"`
+	// ProtectAnnotation keeps jobs carrying this annotation.
+	ProtectAnnotation string `json:"protectAnnotation"`
+}
+
+// readPolicy reads and validates a YAML or JSON policy file. Unknown fields are
+// an error, since a misspelled field would silently fall back to the flags.
+func readPolicy(name string) (*policy, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read -policy: %v", err)
+	}
+	data, err = yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse -policy: %v", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var p policy
+	if err := dec.Decode(&p); err != nil {
+		return nil, fmt.Errorf("Invalid -policy %s: %v", name, err)
+	}
+	for i, r := range p.Rules {
+		if len(r.Namespaces) == 0 {
+			return nil, fmt.Errorf("Invalid -policy %s: rule %d has no namespaces", name, i+1)
+		}
+		for _, pattern := range r.Namespaces {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("Invalid -policy %s: rule %d: invalid namespace pattern %q", name, i+1, pattern)
+			}
+		}
+		if (r.Days != nil && *r.Days < 0) || r.KeepLast < 0 {
+			return nil, fmt.Errorf("Invalid -policy %s: rule %d: days and keepLast can't be negative", name, i+1)
+		}
+	}
+	return &p, nil
+}
+
+// rule returns the first rule matching the namespace, or nil if there is none
+// or p is nil.
+func (p *policy) rule(namespace string) *policyRule {
+	if p == nil {
+		return nil
+	}
+	for i := range p.Rules {
+		for _, pattern := range p.Rules[i].Namespaces {
+			if ok, _ := path.Match(pattern, namespace); ok {
+				return &p.Rules[i]
+			}
+		}
+	}
+	return nil
+}
+
+// selectorFor returns the label selector for jobs in the namespace.
+func (c *runConfig) selectorFor(namespace string) string {
+	if r := c.policy.rule(namespace); r != nil && r.Selector != "" {
+		return r.Selector
+	}
+	return c.selector
+}
+
+// keepLastJobs returns the "namespace/name" keys of the newest jobs of each
+// namespace whose policy rule sets keepLast.
+func keepLastJobs(jobs []*batchv1.Job, p *policy) map[string]bool {
+	byNamespace := make(map[string][]*batchv1.Job)
+	for _, j := range jobs {
+		byNamespace[j.Metadata.GetNamespace()] = append(byNamespace[j.Metadata.GetNamespace()], j)
+	}
+	keep := make(map[string]bool)
+	for ns, nsJobs := range byNamespace {
+		r := p.rule(ns)
+		if r == nil || r.KeepLast == 0 {
+			continue
+		}
+		sort.Slice(nsJobs, func(a, b int) bool {
+			return nsJobs[a].Metadata.GetCreationTimestamp().GetSeconds() > nsJobs[b].Metadata.GetCreationTimestamp().GetSeconds()
+		})
+		for i := 0; i < r.KeepLast && i < len(nsJobs); i++ {
+			keep[ns+"/"+nsJobs[i].Metadata.GetName()] = true
+		}
+	}
+	return keep
+}
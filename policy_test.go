@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	batchv1 "github.com/ericchiang/k8s/apis/batch/v1"
+)
+
+// writePolicy writes the policy file and returns its path.
+func writePolicy(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPolicyRuleResolution(t *testing.T) {
+	p, err := readPolicy(writePolicy(t, `
+rules:
+- namespaces: ["prod"]
+  days: 30
+  selector: app=batch
+- namespaces: ["prod-*", "staging"]
+  days: 7
+  keepLast: 2
+- namespaces: ["*"]
+  protectAnnotation: example.com/keep
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := testConfig()
+	cfg.olderThanDays = 3
+	cfg.selector = "team=data"
+	cfg.policy = p
+	tests := []struct {
+		namespace string
+		days      int
+		selector  string
+	}{
+		// The first matching rule wins, even if a later one matches too.
+		{"prod", 30, "app=batch"},
+		{"prod-eu", 7, "team=data"},
+		{"staging", 7, "team=data"},
+		// The catch-all rule sets neither, so the flags apply.
+		{"dev", 3, "team=data"},
+	}
+	for _, tt := range tests {
+		if days := cfg.daysFor(tt.namespace, nil); days != tt.days {
+			t.Errorf("%s: days %d, want %d", tt.namespace, days, tt.days)
+		}
+		if selector := cfg.selectorFor(tt.namespace); selector != tt.selector {
+			t.Errorf("%s: selector %q, want %q", tt.namespace, selector, tt.selector)
+		}
+	}
+	if r := p.rule("dev"); r == nil || r.ProtectAnnotation != "example.com/keep" {
+		t.Errorf("dev: rule %+v, want the catch-all rule", r)
+	}
+	var none *policy
+	if r := none.rule("prod"); r != nil {
+		t.Errorf("Rule %+v without a -policy", r)
+	}
+}
+
+func TestReadPolicyInvalid(t *testing.T) {
+	tests := map[string]string{
+		"unknown field": "rules:\n- namespaces: [\"a\"]\n  dayz: 3\n",
+		"no namespaces": "rules:\n- days: 3\n",
+		"bad pattern":   "rules:\n- namespaces: [\"[\"]\n",
+		"negative days": "rules:\n- namespaces: [\"a\"]\n  days: -1\n",
+		"negative keep": "rules:\n- namespaces: [\"a\"]\n  keepLast: -1\n",
+		"not a policy":  "rules: 3\n",
+		"invalid yaml":  "rules: [\n",
+	}
+	for name, data := range tests {
+		if _, err := readPolicy(writePolicy(t, data)); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
+
+func TestKeepLastJobs(t *testing.T) {
+	p := &policy{Rules: []policyRule{{Namespaces: []string{"prod"}, KeepLast: 2}}}
+	job := func(ns, name string, createdDaysAgo int) *batchv1.Job {
+		j := completedJob(ns, name, 1)
+		j.Metadata.CreationTimestamp = metaTime(testNow.Add(-time.Duration(createdDaysAgo) * 24 * time.Hour))
+		return j
+	}
+	jobs := []*batchv1.Job{job("prod", "a", 3), job("prod", "b", 1), job("prod", "c", 2), job("dev", "d", 1)}
+	got := keepLastJobs(jobs, p)
+	if len(got) != 2 || !got["prod/b"] || !got["prod/c"] {
+		t.Errorf("Kept %v, want the two newest jobs of prod", got)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// deleteLimiter spaces out all job, pod and CronJob deletions with
+// "-delete-rate". The zero value doesn't limit anything.
+var deleteLimiter rateLimiter
+
+// rateLimiter allows one operation per interval, shared by all goroutines. It's
+// a token bucket holding a single token, so there are no bursts.
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// setRate limits l to perSecond operations per second. Zero or less disables
+// the limit.
+func (l *rateLimiter) setRate(perSecond float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.interval = 0
+	if perSecond > 0 {
+		l.interval = time.Duration(float64(time.Second) / perSecond)
+	}
+}
+
+// wait blocks until the next operation is allowed.
+func (l *rateLimiter) wait() {
+	l.mu.Lock()
+	if l.interval == 0 {
+		l.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	at := l.next
+	if at.Before(now) {
+		at = now
+	}
+	l.next = at.Add(l.interval)
+	l.mu.Unlock()
+	time.Sleep(at.Sub(now))
+}
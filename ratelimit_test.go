@@ -0,0 +1,43 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterStaysUnderRate(t *testing.T) {
+	var l rateLimiter
+	l.setRate(100)
+	const ops = 20
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < ops/4; j++ {
+				l.wait()
+			}
+		}()
+	}
+	wg.Wait()
+	// The first operation doesn't wait, so n operations take at least n-1
+	// intervals.
+	if elapsed, min := time.Since(start), (ops-1)*10*time.Millisecond; elapsed < min {
+		t.Errorf("%d operations at 100/s took %v, want at least %v", ops, elapsed, min)
+	}
+}
+
+func TestRateLimiterDisabled(t *testing.T) {
+	var l rateLimiter
+	l.setRate(100)
+	l.setRate(0)
+	start := time.Now()
+	for i := 0; i < 1000; i++ {
+		l.wait()
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("1000 operations without a limit took %v", elapsed)
+	}
+}
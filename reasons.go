@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	batchv1 "github.com/ericchiang/k8s/apis/batch/v1"
+)
+
+// deletionReason is why an object was cleaned up, tallied in the summary. Each
+// reason only applies to one kind of object.
+type deletionReason string
+
+const (
+	reasonCompletedAndOld deletionReason = "completed-and-old"
+	reasonFailedAndOld    deletionReason = "failed-and-old"
+	reasonMarked          deletionReason = "marked"
+	reasonStaleActive     deletionReason = "stale-active"
+	reasonDuplicate       deletionReason = "duplicate"
+	reasonListed          deletionReason = "listed"
+	reasonOrphanCleanup   deletionReason = "orphan-cleanup"
+	reasonStaleCronJob    deletionReason = "stale-cronjob"
+)
+
+// ageReason returns the reason an old job is deleted, depending on whether it
+// failed.
+func ageReason(j *batchv1.Job) deletionReason {
+	if finishedCondition(j, []string{"Failed"}) != nil {
+		return reasonFailedAndOld
+	}
+	return reasonCompletedAndOld
+}
+
+// printDeletedByReason writes the deletion counts by reason to w, if any.
+func printDeletedByReason(w io.Writer, s *runSummary) {
+	if len(s.DeletedByReason) == 0 {
+		return
+	}
+	var reasons []string
+	for reason := range s.DeletedByReason {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	counts := make([]string, len(reasons))
+	for i, reason := range reasons {
+		counts[i] = fmt.Sprintf("%s=%d", reason, s.DeletedByReason[reason])
+	}
+	fmt.Fprintf(w, "Deleted by reason: %s\n", strings.Join(counts, ", "))
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	batchv1 "github.com/ericchiang/k8s/apis/batch/v1"
+	"github.com/golang/protobuf/proto"
+)
+
+func TestEligibleJobReasons(t *testing.T) {
+	marked := completedJob("ns", "marked", 5)
+	marked.Metadata.Annotations = map[string]string{markedForDeletionAnnotation: testNow.AddDate(0, 0, -3).Format(time.RFC3339)}
+	stale := newTestJob("ns", "stale")
+	stale.Status.Active = proto.Int32(1)
+	tests := []struct {
+		name       string
+		job        *batchv1.Job
+		conditions bool
+		sweep      bool
+		stale      bool
+		reason     deletionReason
+	}{
+		{"completed", completedJob("ns", "done", 5), false, false, false, reasonCompletedAndOld},
+		// Failed jobs have no completion time, only a condition.
+		{"failed", failedJob("ns", "failed", 5), true, false, false, reasonFailedAndOld},
+		{"marked", marked, false, true, false, reasonMarked},
+		{"stale active", stale, false, false, true, reasonStaleActive},
+	}
+	for _, tt := range tests {
+		cfg := testConfig()
+		cfg.conditionAge = tt.conditions
+		cfg.sweepMarked = tt.sweep
+		kj, ok := eligibleJob(tt.job, testNow, cfg, tt.stale)
+		if !ok || kj.reason != tt.reason {
+			t.Errorf("%s: eligible = %v, reason %q, want %q", tt.name, ok, kj.reason, tt.reason)
+		}
+	}
+}
+
+func TestDeletedByReason(t *testing.T) {
+	api, client := newFakeAPI(t)
+	done, failed := completedJob("ns", "done", 5), failedJob("ns", "failed", 5)
+	gone := completedJob("ns", "gone", 5)
+	api.serveJobs(done, failed)
+	api.servePods("ns", testPod(done, "done-1", "Succeeded"), testPod(failed, "failed-1", "Failed"), testPod(gone, "gone-1", "Succeeded"))
+	cfg := testConfig()
+	cfg.deleteJobs = true
+	cfg.conditionAge = true
+	cfg.owner = jobOwner{}
+	cfg.orphanPhases = map[string]bool{"Succeeded": true}
+	sum := &runSummary{}
+	captureStdout(t)
+	// Before the jobs are deleted, only the pod of the missing job is
+	// orphaned.
+	cleanupOrphans(client, []string{"ns"}, cfg, sum)
+	cleanupJobs(client, []kubeJob{newKubeJob(done, testNow), newKubeJob(failed, testNow)}, cfg, sum, 1)
+
+	want := map[string]int{string(reasonCompletedAndOld): 1, string(reasonFailedAndOld): 1, string(reasonOrphanCleanup): 1}
+	if len(sum.DeletedByReason) != len(want) {
+		t.Errorf("Deleted by reason %v, want %v", sum.DeletedByReason, want)
+	}
+	for reason, n := range want {
+		if sum.DeletedByReason[reason] != n {
+			t.Errorf("%s: %d deleted, want %d", reason, sum.DeletedByReason[reason], n)
+		}
+	}
+	var out bytes.Buffer
+	printDeletedByReason(&out, sum)
+	if got := out.String(); got != "Deleted by reason: completed-and-old=1, failed-and-old=1, orphan-cleanup=1\n" {
+		t.Errorf("Printed %q", got)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// jsonReport is the file written by "-report-json": the run's audit entry
+// along with every job and pod printed during the run.
+type jsonReport struct {
+	auditEntry
+	Items []outputItem `json:"items"`
+}
+
+// itemRecorder collects the items printed during a run for "-report-json". A
+// nil recorder collects nothing. It's safe for concurrent use.
+type itemRecorder struct {
+	mu    sync.Mutex
+	items []outputItem
+}
+
+func (r *itemRecorder) add(item outputItem) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.items = append(r.items, item)
+	r.mu.Unlock()
+}
+
+// writeJSONReport writes the report to path, replacing the file only once it's
+// complete.
+func writeJSONReport(path string, entry auditEntry, r *itemRecorder) error {
+	report := jsonReport{auditEntry: entry, Items: r.items}
+	if report.Items == nil {
+		report.Items = []outputItem{}
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Failed to encode report: %v", err)
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".jobliterator-*.json")
+	if err != nil {
+		return fmt.Errorf("Failed to create report file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("Failed to write report file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("Failed to write report file: %v", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("Failed to write report file: %v", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
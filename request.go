@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/ericchiang/k8s"
+	"github.com/ericchiang/k8s/api/unversioned"
+	"github.com/ericchiang/k8s/runtime"
+	"github.com/golang/protobuf/proto"
+)
+
+// rawRequest sends a request for the API path using the client's endpoint and
+// credentials, for calls the generated client methods don't cover. body is
+// JSON encoded if not nil. The response body is returned, and non-2xx
+// responses are returned as a *k8s.APIError.
+func rawRequest(ctx context.Context, client *k8s.Client, method, path string, body interface{}) ([]byte, error) {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, client.Endpoint+path, r)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, */*")
+	return send(client, req)
+}
+
+// listRequest lists the objects at the API path into list. The generated list
+// methods only send the label selectors k8s.LabelSelector can build, so lists
+// taking a selector from the command line are sent with their query
+// parameters here. The response is protobuf encoded, like the generated
+// client's.
+func listRequest(ctx context.Context, client *k8s.Client, path string, query url.Values, list proto.Message) error {
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	req, err := http.NewRequest("GET", client.Endpoint+path, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", protobufContentType)
+	body, err := send(client, req)
+	if err != nil {
+		return err
+	}
+	return decodeProtobuf(body, list)
+}
+
+// send sends the request with the client's credentials and returns the
+// response body. Non-2xx responses are returned as a *k8s.APIError.
+func send(client *k8s.Client, req *http.Request) ([]byte, error) {
+	if client.SetHeaders != nil {
+		if err := client.SetHeaders(req.Header); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := client.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return respBody, nil
+	}
+	status := new(unversioned.Status)
+	if resp.Header.Get("Content-Type") == protobufContentType {
+		err = decodeProtobuf(respBody, status)
+	} else {
+		err = json.Unmarshal(respBody, status)
+	}
+	if err != nil || status.Message == nil {
+		status.Message = k8s.String(string(respBody))
+	}
+	return nil, &k8s.APIError{Status: status, Code: resp.StatusCode}
+}
+
+const protobufContentType = "application/vnd.kubernetes.protobuf"
+
+// protobufMagic prefixes every protobuf encoded object from the API server.
+var protobufMagic = []byte("k8s\x00")
+
+// decodeProtobuf decodes an object the API server encoded as protobuf, which
+// is wrapped in a runtime.Unknown behind the magic prefix.
+func decodeProtobuf(data []byte, msg proto.Message) error {
+	if !bytes.HasPrefix(data, protobufMagic) {
+		return fmt.Errorf("Response isn't a protobuf encoded Kubernetes object")
+	}
+	u := new(runtime.Unknown)
+	if err := u.Unmarshal(data[len(protobufMagic):]); err != nil {
+		return fmt.Errorf("Unable to decode response: %v", err)
+	}
+	return proto.Unmarshal(u.Raw, msg)
+}
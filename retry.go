@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ericchiang/k8s"
+)
+
+// apiRetry retries the list calls made to the Kubernetes API. The zero value
+// doesn't retry.
+var apiRetry retryPolicy
+
+// retryPolicy decides which failed API calls are retried and how often.
+// Transport errors such as DNS failures, refused connections or TLS errors are
+// always retryable, API errors only if their status code is in codes.
+type retryPolicy struct {
+	retries int
+	codes   map[int]bool
+	// backoff is the delay before the first retry, doubled for every
+	// further one.
+	backoff time.Duration
+}
+
+// parseRetryCodes parses the "-retry-codes" flag value, a comma-separated list
+// of HTTP status codes.
+func parseRetryCodes(val string) (map[int]bool, error) {
+	codes := make(map[int]bool)
+	if val == "" {
+		return codes, nil
+	}
+	for _, entry := range strings.Split(val, ",") {
+		code, err := strconv.Atoi(strings.TrimSpace(entry))
+		if err != nil || code < 100 || code > 599 {
+			return nil, fmt.Errorf("Invalid -retry-codes status code %q", entry)
+		}
+		codes[code] = true
+	}
+	return codes, nil
+}
+
+// retryable reports whether the call that failed with err is worth retrying.
+func (p *retryPolicy) retryable(err error) bool {
+	if apiErr, ok := err.(*k8s.APIError); ok {
+		return p.codes[apiErr.Code]
+	}
+	return err != nil
+}
+
+// do calls fn until it succeeds, fails with an error that isn't retryable or
+// the retries are used up, and returns its last error. An interrupted run
+// isn't kept waiting for retries.
+func (p *retryPolicy) do(fn func() error) error {
+	delay := p.backoff
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= p.retries || !p.retryable(err) || interrupted() {
+			return err
+		}
+		debugf("API call failed, retrying in %v: %v\n", delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
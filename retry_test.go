@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRetryable(t *testing.T) {
+	p := &retryPolicy{retries: 3, codes: map[int]bool{http.StatusTooManyRequests: true, http.StatusServiceUnavailable: true}}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"success", nil, false},
+		{"transport error", errors.New("dial tcp: connection refused"), true},
+		{"retried code", apiError(http.StatusServiceUnavailable), true},
+		{"other code", apiError(http.StatusNotFound), false},
+		{"forbidden", apiError(http.StatusForbidden), false},
+	}
+	for _, tt := range tests {
+		if got := p.retryable(tt.err); got != tt.want {
+			t.Errorf("%s: retryable = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRetryDo(t *testing.T) {
+	p := &retryPolicy{retries: 2, codes: map[int]bool{http.StatusServiceUnavailable: true}}
+	calls := func(errs ...error) int {
+		n := 0
+		p.do(func() error {
+			n++
+			if n > len(errs) {
+				return nil
+			}
+			return errs[n-1]
+		})
+		return n
+	}
+	unavailable := apiError(http.StatusServiceUnavailable)
+	if n := calls(unavailable, unavailable, unavailable, unavailable); n != 3 {
+		t.Errorf("%d calls with 2 retries, want 3", n)
+	}
+	if n := calls(unavailable); n != 2 {
+		t.Errorf("%d calls succeeding on the first retry, want 2", n)
+	}
+	if n := calls(apiError(http.StatusNotFound)); n != 1 {
+		t.Errorf("%d calls failing with a 404, want 1", n)
+	}
+
+	atomic.StoreInt32(&interruptSignals, 1)
+	defer atomic.StoreInt32(&interruptSignals, 0)
+	if n := calls(unavailable, unavailable); n != 1 {
+		t.Errorf("%d calls after an interrupt, want 1", n)
+	}
+}
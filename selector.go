@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	selectorKey   = `([A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?/)?[A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?`
+	selectorValue = `([A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?)?`
+)
+
+// selectorRequirement matches a single requirement of a label selector:
+// "key", "!key", "key=value", "key==value", "key!=value", "key in (a,b)" or
+// "key notin (a,b)".
+var selectorRequirement = regexp.MustCompile(`^(!?` + selectorKey + `|` +
+	selectorKey + `\s*(=|==|!=)\s*` + selectorValue + `|` +
+	selectorKey + `\s+(in|notin)\s*\(\s*` + selectorValue + `(\s*,\s*` + selectorValue + `)*\s*\))$`)
+
+// validateSelector checks the syntax of a label selector, so a typo fails the
+// run up front instead of with an API error later. An empty selector selects
+// everything.
+func validateSelector(flagName, selector string) error {
+	for _, req := range splitSelector(selector) {
+		if !selectorRequirement.MatchString(strings.TrimSpace(req)) {
+			return fmt.Errorf("Invalid -%s %q, %q isn't a label selector requirement", flagName, selector, req)
+		}
+	}
+	return nil
+}
+
+// orphanScanSelector returns the label selector of the orphan scan, the
+// "-orphan-selector" or, with "-scope-orphans", the job "-selector".
+func orphanScanSelector(selector, orphanSelector string, scope bool) (string, error) {
+	if !scope {
+		return orphanSelector, nil
+	}
+	if orphanSelector != "" {
+		return "", fmt.Errorf("-scope-orphans and -orphan-selector can't be combined.")
+	}
+	return selector, nil
+}
+
+// splitSelector splits the selector into its requirements at the commas that
+// aren't inside a set of values.
+func splitSelector(selector string) []string {
+	if strings.TrimSpace(selector) == "" {
+		return nil
+	}
+	var reqs []string
+	depth, start := 0, 0
+	for i, r := range selector {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				reqs = append(reqs, selector[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(reqs, selector[start:])
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	apiv1 "github.com/ericchiang/k8s/api/v1"
+	metav1 "github.com/ericchiang/k8s/apis/meta/v1"
+)
+
+func TestScopeOrphans(t *testing.T) {
+	if got, err := orphanScanSelector("team=a", "", true); got != "team=a" || err != nil {
+		t.Errorf("-scope-orphans: selector = %q, err = %v, want team=a", got, err)
+	}
+	if got, err := orphanScanSelector("team=a", "", false); got != "" || err != nil {
+		t.Errorf("unscoped: selector = %q, err = %v, want none", got, err)
+	}
+	if got, err := orphanScanSelector("team=a", "app=web", false); got != "app=web" || err != nil {
+		t.Errorf("-orphan-selector: selector = %q, err = %v, want app=web", got, err)
+	}
+	if _, err := orphanScanSelector("team=a", "app=web", true); err == nil {
+		t.Error("expected an error combining -scope-orphans and -orphan-selector")
+	}
+
+	// The scoped selector is what the orphan scan lists pods with.
+	api, client := newFakeAPI(t)
+	var selectors []string
+	api.handle("GET", "/api/v1/namespaces/ns/pods", func(w http.ResponseWriter, r *http.Request) {
+		selectors = append(selectors, r.URL.Query().Get("labelSelector"))
+		writeObject(w, r, &apiv1.PodList{Metadata: &metav1.ListMeta{}})
+	})
+	selector, _ := orphanScanSelector("team=a", "", true)
+	if _, err := getOrphanedPods(client, "ns", selector, []string{"job-name"}, jobOwner{}, false, false); err != nil {
+		t.Fatal(err)
+	}
+	if !equalStrings(selectors, []string{"team=a"}) {
+		t.Errorf("pods listed with selectors %q, want team=a", selectors)
+	}
+}
+
+func TestValidateSelector(t *testing.T) {
+	valid := []string{
+		"",
+		"app",
+		"!app",
+		"app=web",
+		"app==web",
+		"app!=web",
+		"app=",
+		"example.com/team=data",
+		"env in (dev, staging),tier notin (db)",
+		" app = web , !legacy ",
+	}
+	for _, s := range valid {
+		if err := validateSelector("selector", s); err != nil {
+			t.Errorf("%q rejected: %v", s, err)
+		}
+	}
+	invalid := []string{
+		"app=web,",
+		"app=web=x",
+		"app =~ web",
+		"env in dev",
+		"env in (dev",
+		"-app=web",
+		"app=we b",
+		"example.com/=x",
+	}
+	for _, s := range invalid {
+		if err := validateSelector("orphan-selector", s); err == nil {
+			t.Errorf("%q accepted", s)
+		} else if !strings.Contains(err.Error(), "-orphan-selector") {
+			t.Errorf("%q: error %q doesn't name the flag", s, err)
+		}
+	}
+}
+
+func TestOrphanSelectorScopesTheScan(t *testing.T) {
+	gone := completedJob("ns", "gone", 5)
+	web := testPod(gone, "gone-web", "Succeeded")
+	web.Metadata.Labels["app"] = "web"
+	batch := testPod(gone, "gone-batch", "Succeeded")
+	batch.Metadata.Labels["app"] = "batch"
+	api, client := newFakeAPI(t)
+	api.servePods("ns", web, batch)
+
+	for _, tt := range []struct {
+		selector string
+		pods     []string
+	}{
+		{"", []string{"gone-web", "gone-batch"}},
+		{"app=web", []string{"gone-web"}},
+	} {
+		jobs, err := getOrphanedPods(client, "ns", tt.selector, []string{"job-name"}, jobOwner{}, false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var pods []string
+		for _, j := range jobs {
+			for _, p := range j.pods {
+				pods = append(pods, p.name)
+			}
+		}
+		if !equalStrings(pods, tt.pods) {
+			t.Errorf("Selector %q: orphaned pods %v, want %v", tt.selector, pods, tt.pods)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+)
+
+// exitInterrupted is the exit code of a run stopped by SIGINT or SIGTERM, the
+// shell convention for SIGINT.
+const exitInterrupted = 130
+
+// interruptSignals counts the interrupts received.
+var interruptSignals int32
+
+// handleInterrupts makes the first SIGINT or SIGTERM stop the run gracefully:
+// the job being cleaned up is finished, no further ones are started and the
+// summary of what was done so far is printed as usual. A second signal exits
+// immediately.
+func handleInterrupts() {
+	ch := make(chan os.Signal, 2)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		for range ch {
+			if atomic.AddInt32(&interruptSignals, 1) > 1 {
+				fmt.Fprintln(stdout, "Interrupted again, exiting immediately.")
+				os.Exit(exitInterrupted)
+			}
+			fmt.Fprintln(stdout, "Interrupted, finishing the current job. Interrupt again to exit immediately.")
+		}
+	}()
+}
+
+// interrupted reports whether the run was asked to stop.
+func interrupted() bool {
+	return atomic.LoadInt32(&interruptSignals) > 0
+}
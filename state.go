@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ericchiang/k8s"
+	apiv1 "github.com/ericchiang/k8s/api/v1"
+	metav1 "github.com/ericchiang/k8s/apis/meta/v1"
+)
+
+// lastRunKey is the ConfigMap data key holding the last run mark.
+const lastRunKey = "last-run"
+
+// runState stores the time of the last successful run for "-incremental".
+type runState interface {
+	// load returns the last run time, and false if there was no previous run.
+	load() (time.Time, bool, error)
+	save(t time.Time) error
+}
+
+// fileState keeps the last run mark as an RFC3339 timestamp in a file.
+type fileState struct {
+	path string
+}
+
+func (s fileState) load() (time.Time, bool, error) {
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("Failed to read state file: %v", err)
+	}
+	return parseLastRun(string(data))
+}
+
+func (s fileState) save(t time.Time) error {
+	// Write to a temporary file first so a crash can't leave a truncated mark.
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(t.UTC().Format(time.RFC3339)+"\n"), 0644); err != nil {
+		return fmt.Errorf("Failed to write state file: %v", err)
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// configMapState keeps the last run mark in a ConfigMap, for clusters where
+// the pod has no persistent storage.
+type configMapState struct {
+	client    *k8s.Client
+	namespace string
+	name      string
+}
+
+func (s configMapState) load() (time.Time, bool, error) {
+	countAPICall(apiGet)
+	cm, err := s.client.CoreV1().GetConfigMap(context.Background(), s.name, s.namespace)
+	if isNotFound(err) {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("Failed to get ConfigMap %s/%s: %v", s.namespace, s.name, err)
+	}
+	val, ok := cm.Data[lastRunKey]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	return parseLastRun(val)
+}
+
+func (s configMapState) save(t time.Time) error {
+	mark := t.UTC().Format(time.RFC3339)
+	countAPICall(apiGet)
+	cm, err := s.client.CoreV1().GetConfigMap(context.Background(), s.name, s.namespace)
+	if isNotFound(err) {
+		cm = &apiv1.ConfigMap{
+			Metadata: &metav1.ObjectMeta{Name: k8s.String(s.name), Namespace: k8s.String(s.namespace)},
+			Data:     map[string]string{lastRunKey: mark},
+		}
+		countAPICall(apiCreate)
+		if _, err := s.client.CoreV1().CreateConfigMap(context.Background(), cm); err != nil {
+			return fmt.Errorf("Failed to create ConfigMap %s/%s: %v", s.namespace, s.name, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to get ConfigMap %s/%s: %v", s.namespace, s.name, err)
+	}
+	if cm.Data == nil {
+		cm.Data = make(map[string]string)
+	}
+	cm.Data[lastRunKey] = mark
+	countAPICall(apiUpdate)
+	if _, err := s.client.CoreV1().UpdateConfigMap(context.Background(), cm); err != nil {
+		return fmt.Errorf("Failed to update ConfigMap %s/%s: %v", s.namespace, s.name, err)
+	}
+	return nil
+}
+
+func parseLastRun(val string) (time.Time, bool, error) {
+	t, err := time.Parse(time.RFC3339, strings.TrimSpace(val))
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("Invalid last run mark %q: %v", val, err)
+	}
+	return t, true, nil
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// exitThreshold is the exit code of a run that crossed "-error-exit-threshold"
+// or "-delete-warn-threshold".
+const exitThreshold = 2
+
+// summaryLinePrefix marks the final summary line so it can be found in logs.
+const summaryLinePrefix = "JOBLITERATOR_SUMMARY "
+
+// runSummary counts what a run cleaned up. In dry-run the counts are what
+// would have been deleted.
+//
+// New counts must be safe to leave zero, so entries written by older versions
+// still decode.
+type runSummary struct {
+	// JobsConsidered counts the jobs listed before any were selected.
+	JobsConsidered int `json:"jobs_considered"`
+	JobsEligible   int `json:"jobs_eligible"`
+	// JobsActive counts the jobs skipped because they're still active.
+	JobsActive        int `json:"jobs_active,omitempty"`
+	JobsDeleted       int `json:"jobs_deleted"`
+	PodsDeleted       int `json:"pods_deleted"`
+	OrphanPodsDeleted int `json:"orphan_pods_deleted"`
+	Errors            int `json:"errors"`
+	// CronJobsDeleted counts stale CronJobs deleted by
+	// "-delete-stale-cronjobs".
+	CronJobsDeleted int `json:"cronjobs_deleted,omitempty"`
+	// AssociatedDeleted counts the ConfigMaps and Secrets deleted along with
+	// their job.
+	AssociatedDeleted int `json:"associated_deleted,omitempty"`
+	// AssociatedByKind splits AssociatedDeleted into "ConfigMap" and
+	// "Secret".
+	AssociatedByKind map[string]int `json:"associated_by_kind,omitempty"`
+	// PodsOrphaned counts the pods a dry-run with "-job-propagation Orphan"
+	// would leave behind as orphans.
+	PodsOrphaned int `json:"pods_orphaned,omitempty"`
+	// JobsStuck are deleted jobs still present after "-wait-finalizers".
+	JobsStuck int `json:"jobs_stuck,omitempty"`
+	// PartiallyDeleted are the "namespace/name" of jobs whose pods were
+	// deleted but the job itself couldn't be.
+	PartiallyDeleted []string `json:"partially_deleted,omitempty"`
+	// Skipped counts eligible jobs that weren't cleaned up by reason, e.g.
+	// "changed" for jobs modified since they were selected.
+	Skipped map[string]int `json:"skipped,omitempty"`
+	// Restarts are the total container restarts by "namespace/name" of the
+	// cleaned up jobs that had any, with "-report-restarts".
+	Restarts map[string]int32 `json:"restarts,omitempty"`
+	// DeletedByReason counts the deleted jobs, CronJobs and orphaned pods by
+	// deletionReason. The kinds share the map, telling them apart by reason:
+	// orphaned pods are reasonOrphanCleanup, CronJobs reasonStaleCronJob and
+	// everything else is a job. Pods deleted along with their job aren't
+	// counted.
+	DeletedByReason map[string]int `json:"deleted_by_reason,omitempty"`
+	// SkippedNamespaces are the namespaces skipped due to insufficient
+	// permissions.
+	SkippedNamespaces []string `json:"skipped_namespaces,omitempty"`
+	// RetriedNamespaces are the namespaces whose jobs were only listed in a
+	// retry pass, see "-namespace-retries".
+	RetriedNamespaces []string `json:"retried_namespaces,omitempty"`
+	// AgeHistogram counts the eligible jobs in each age bucket by label,
+	// with "-report-age-histogram".
+	AgeHistogram map[string]int `json:"age_histogram,omitempty"`
+}
+
+// add merges the counts of other into s. JobsConsidered, JobsEligible,
+// JobsActive, SkippedNamespaces, RetriedNamespaces and AgeHistogram are
+// properties of the whole run and aren't merged.
+func (s *runSummary) add(other *runSummary) {
+	s.JobsDeleted += other.JobsDeleted
+	s.PodsDeleted += other.PodsDeleted
+	s.OrphanPodsDeleted += other.OrphanPodsDeleted
+	s.Errors += other.Errors
+	s.JobsStuck += other.JobsStuck
+	s.PodsOrphaned += other.PodsOrphaned
+	s.CronJobsDeleted += other.CronJobsDeleted
+	s.AssociatedDeleted += other.AssociatedDeleted
+	s.PartiallyDeleted = append(s.PartiallyDeleted, other.PartiallyDeleted...)
+	for job, n := range other.Restarts {
+		if s.Restarts == nil {
+			s.Restarts = make(map[string]int32)
+		}
+		s.Restarts[job] += n
+	}
+	for kind, n := range other.AssociatedByKind {
+		if s.AssociatedByKind == nil {
+			s.AssociatedByKind = make(map[string]int)
+		}
+		s.AssociatedByKind[kind] += n
+	}
+	for reason, n := range other.DeletedByReason {
+		if s.DeletedByReason == nil {
+			s.DeletedByReason = make(map[string]int)
+		}
+		s.DeletedByReason[reason] += n
+	}
+	for reason, n := range other.Skipped {
+		s.skip(reason, n)
+	}
+}
+
+// skip records n jobs skipped for reason.
+func (s *runSummary) skip(reason string, n int) {
+	if s.Skipped == nil {
+		s.Skipped = make(map[string]int)
+	}
+	s.Skipped[reason] += n
+}
+
+// deleted records an object deleted for reason.
+func (s *runSummary) deleted(reason deletionReason) {
+	if s.DeletedByReason == nil {
+		s.DeletedByReason = make(map[string]int)
+	}
+	s.DeletedByReason[string(reason)]++
+}
+
+// associated records a deleted ConfigMap or Secret.
+func (s *runSummary) associated(kind string) {
+	s.AssociatedDeleted++
+	if s.AssociatedByKind == nil {
+		s.AssociatedByKind = make(map[string]int)
+	}
+	s.AssociatedByKind[kind]++
+}
+
+// objectsDeleted returns the number of deleted API objects by kind, leaving
+// out kinds with none. Pods removed by the garbage collector aren't counted.
+func objectsDeleted(s *runSummary) map[string]int {
+	counts := map[string]int{
+		"Job":     s.JobsDeleted,
+		"Pod":     s.PodsDeleted + s.OrphanPodsDeleted,
+		"CronJob": s.CronJobsDeleted,
+	}
+	for kind, n := range s.AssociatedByKind {
+		counts[kind] += n
+	}
+	for kind, n := range counts {
+		if n == 0 {
+			delete(counts, kind)
+		}
+	}
+	return counts
+}
+
+// printObjectReduction writes the total and per-kind number of deleted API
+// objects to w, as an estimate of the reduction in objects stored in etcd.
+func printObjectReduction(w io.Writer, s *runSummary, dryRun bool) {
+	counts := objectsDeleted(s)
+	var kinds []string
+	total := 0
+	for kind, n := range counts {
+		kinds = append(kinds, kind)
+		total += n
+	}
+	sort.Strings(kinds)
+	perKind := make([]string, len(kinds))
+	for i, kind := range kinds {
+		perKind[i] = fmt.Sprintf("%s=%d", kind, counts[kind])
+	}
+	verb := "Reduced"
+	if dryRun {
+		verb = "Would reduce"
+	}
+	fmt.Fprintf(w, "%s cluster object count by %d", verb, total)
+	if len(perKind) > 0 {
+		fmt.Fprintf(w, " (%s)", strings.Join(perKind, ", "))
+	}
+	fmt.Fprintln(w, ".")
+}
+
+// printAPIEstimate writes an estimate of the API calls a real run would make
+// to w, from the lists and gets the dry-run made and a delete for each object
+// it would have deleted. Calls a real run only makes while deleting aren't
+// known and left out: PodDisruptionBudget lists, the gets of -save-logs,
+// -force-terminate and -wait-finalizers, and retried job deletes.
+func printAPIEstimate(w io.Writer, s *runSummary) {
+	deletes := apiCallCount(apiDelete) + s.JobsDeleted + s.PodsDeleted + s.OrphanPodsDeleted + s.CronJobsDeleted + s.AssociatedDeleted
+	lists, gets := apiCallCount(apiList), apiCallCount(apiGet)
+	fmt.Fprintf(w, "Estimated API calls for a real run: %v lists, %v gets, %v deletes (%v total).\n", lists, gets, deletes, lists+gets+deletes)
+	fmt.Fprintln(w, "Not estimated: PodDisruptionBudget lists, -save-logs, -force-terminate and -wait-finalizers gets, and retried job deletes.")
+}
+
+// thresholdWarnings returns a warning for each threshold the run crossed. A
+// negative threshold is disabled.
+func thresholdWarnings(s *runSummary, errorThreshold, deleteThreshold int) []string {
+	var warnings []string
+	if errorThreshold >= 0 && s.Errors > errorThreshold {
+		warnings = append(warnings, fmt.Sprintf("%d errors, more than -error-exit-threshold=%d", s.Errors, errorThreshold))
+	}
+	deleted := s.JobsDeleted + s.PodsDeleted + s.OrphanPodsDeleted + s.CronJobsDeleted + s.AssociatedDeleted
+	if deleteThreshold >= 0 && deleted > deleteThreshold {
+		warnings = append(warnings, fmt.Sprintf("%d deletions, more than -delete-warn-threshold=%d", deleted, deleteThreshold))
+	}
+	return warnings
+}
+
+// summaryLine is the single JSON line printed at the end of a run, meant to be
+// grepped from logs and parsed.
+type summaryLine struct {
+	JobsDeleted int    `json:"jobs_deleted"`
+	PodsDeleted int    `json:"pods_deleted"`
+	JobsActive  int    `json:"jobs_active"`
+	Errors      int    `json:"errors"`
+	DryRun      bool   `json:"dry_run"`
+	DurationMs  int64  `json:"duration_ms"`
+	RunID       string `json:"run_id"`
+	// ObjectsDeleted are the deleted API objects by kind.
+	ObjectsDeleted map[string]int `json:"objects_deleted,omitempty"`
+	// AgeHistogram is set with "-report-age-histogram".
+	AgeHistogram map[string]int `json:"age_histogram,omitempty"`
+}
+
+// printSummaryLine writes the summary as a single prefixed JSON line to w.
+// Orphaned pods are included in the pod count.
+func printSummaryLine(w io.Writer, s *runSummary, dryRun bool, duration time.Duration) {
+	line, err := json.Marshal(summaryLine{
+		JobsDeleted:    s.JobsDeleted,
+		PodsDeleted:    s.PodsDeleted + s.OrphanPodsDeleted,
+		JobsActive:     s.JobsActive,
+		Errors:         s.Errors,
+		DryRun:         dryRun,
+		DurationMs:     int64(duration / time.Millisecond),
+		RunID:          runID,
+		ObjectsDeleted: objectsDeleted(s),
+		AgeHistogram:   s.AgeHistogram,
+	})
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "%s%s\n", summaryLinePrefix, line)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// resetAPICalls zeroes the API call counters for the test.
+func resetAPICalls(t *testing.T) {
+	saved := apiCalls
+	apiCalls = [numAPIVerbs]int64{}
+	t.Cleanup(func() { apiCalls = saved })
+}
+
+func TestAPIEstimateMatchesRealRun(t *testing.T) {
+	run := func(deleteJobs bool) *runSummary {
+		old := completedJob("ns", "old", 5)
+		other := completedJob("ns", "other", 5)
+		gone := completedJob("ns", "gone", 5)
+		api, client := newFakeAPI(t)
+		api.serveJobs(old, other)
+		api.servePods("ns",
+			testPod(old, "old-1", "Succeeded"), testPod(old, "old-2", "Failed"),
+			testPod(other, "other-1", "Succeeded"),
+			testPod(gone, "gone-1", "Succeeded"))
+		cfg := testConfig()
+		cfg.deleteJobs = deleteJobs
+		cfg.verifyCompletion = true
+		cfg.owner = jobOwner{}
+		cfg.orphanPhases = map[string]bool{"Succeeded": true, "Failed": true}
+		sum := &runSummary{}
+		cleanupOrphans(client, []string{"ns"}, cfg, sum)
+		cleanupJobs(client, []kubeJob{newKubeJob(old, testNow), newKubeJob(other, testNow)}, cfg, sum, 1)
+		return sum
+	}
+	captureStdout(t)
+
+	resetAPICalls(t)
+	var out bytes.Buffer
+	printAPIEstimate(&out, run(false))
+	var lists, gets, deletes, total int
+	if _, err := fmt.Sscanf(out.String(), "Estimated API calls for a real run: %d lists, %d gets, %d deletes (%d total).", &lists, &gets, &deletes, &total); err != nil {
+		t.Fatalf("Unable to parse %q: %v", out.String(), err)
+	}
+
+	resetAPICalls(t)
+	sum := run(true)
+	if sum.JobsDeleted != 2 || sum.PodsDeleted != 3 || sum.OrphanPodsDeleted != 1 {
+		t.Fatalf("Real run deleted %d jobs, %d pods and %d orphaned pods, want 2, 3 and 1", sum.JobsDeleted, sum.PodsDeleted, sum.OrphanPodsDeleted)
+	}
+	if got := apiCallCount(apiList); got != lists {
+		t.Errorf("Estimated %d lists, real run made %d", lists, got)
+	}
+	if got := apiCallCount(apiGet); got != gets {
+		t.Errorf("Estimated %d gets, real run made %d", gets, got)
+	}
+	if got := apiCallCount(apiDelete); got != deletes {
+		t.Errorf("Estimated %d deletes, real run made %d", deletes, got)
+	}
+	if total != totalAPICalls() {
+		t.Errorf("Estimated %d calls in total, real run made %d", total, totalAPICalls())
+	}
+}
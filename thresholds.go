@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// labelThreshold overrides "-days" for jobs with a label set to a value.
+type labelThreshold struct {
+	key   string
+	value string
+	days  int
+}
+
+// parseLabelThresholds parses the "-label-threshold" flag value, a
+// comma-separated list of KEY=VALUE:DAYS entries.
+func parseLabelThresholds(val string) ([]labelThreshold, error) {
+	var thresholds []labelThreshold
+	for _, entry := range splitList(val) {
+		sep := strings.LastIndex(entry, ":")
+		eq := strings.Index(entry, "=")
+		if sep < 0 || eq < 1 || eq > sep {
+			return nil, fmt.Errorf("Invalid -label-threshold %q, expected KEY=VALUE:DAYS", entry)
+		}
+		days, err := strconv.Atoi(entry[sep+1:])
+		if err != nil || days < 0 {
+			return nil, fmt.Errorf("Invalid -label-threshold %q, days must be a non-negative number", entry)
+		}
+		thresholds = append(thresholds, labelThreshold{key: entry[:eq], value: entry[eq+1 : sep], days: days})
+	}
+	return thresholds, nil
+}
+
+// daysFor returns the age threshold in days for a job in the namespace with the
+// labels. The first matching "-label-threshold" entry wins, then the
+// namespace's "-policy" rule, and jobs matching neither use olderThanDays.
+func (c *runConfig) daysFor(namespace string, labels map[string]string) int {
+	for _, t := range c.labelThresholds {
+		if val, ok := labels[t.key]; ok && val == t.value {
+			return t.days
+		}
+	}
+	if r := c.policy.rule(namespace); r != nil && r.Days != nil {
+		return *r.Days
+	}
+	return c.olderThanDays
+}
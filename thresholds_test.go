@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/ericchiang/k8s"
+)
+
+func TestParseLabelThresholds(t *testing.T) {
+	got, err := parseLabelThresholds("tier=batch:1, team=data:14,app.kubernetes.io/part-of=etl:30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []labelThreshold{{"tier", "batch", 1}, {"team", "data", 14}, {"app.kubernetes.io/part-of", "etl", 30}}
+	if len(got) != len(want) {
+		t.Fatalf("thresholds = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("threshold %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	for _, val := range []string{"tier=batch", "tier:1", "=batch:1", "tier=batch:-1", "tier=batch:soon"} {
+		if _, err := parseLabelThresholds(val); err == nil {
+			t.Errorf("%q: expected an error", val)
+		}
+	}
+}
+
+func TestDaysFor(t *testing.T) {
+	cfg := testConfig()
+	cfg.olderThanDays = 7
+	cfg.labelThresholds, _ = parseLabelThresholds("tier=batch:1,team=data:14")
+	cfg.policy = &policy{Rules: []policyRule{{Namespaces: []string{"ci-*"}, Days: k8s.Int(2)}, {Namespaces: []string{"prod"}}}}
+	tests := []struct {
+		name      string
+		namespace string
+		labels    map[string]string
+		want      int
+	}{
+		{"no match", "default", nil, 7},
+		{"label", "default", map[string]string{"tier": "batch"}, 1},
+		{"other label value", "default", map[string]string{"tier": "web"}, 7},
+		{"first matching label wins", "default", map[string]string{"team": "data", "tier": "batch"}, 1},
+		{"label over policy", "ci-main", map[string]string{"team": "data"}, 14},
+		{"policy", "ci-main", nil, 2},
+		{"policy rule without days", "prod", nil, 7},
+	}
+	for _, tt := range tests {
+		if got := cfg.daysFor(tt.namespace, tt.labels); got != tt.want {
+			t.Errorf("%s: daysFor = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ericchiang/k8s"
+)
+
+// tracer records the spans of the run for "-otlp-endpoint". A nil tracer
+// records nothing.
+var tracer *runTracer
+
+// otlpBatchSize is the most spans sent in a single export request.
+const otlpBatchSize = 512
+
+// Span kinds and status codes of the OTLP trace protocol.
+const (
+	spanKindInternal = 1
+	spanKindClient   = 3
+	statusError      = 2
+)
+
+// span is a finished or open span of the run's trace.
+type span struct {
+	id     string
+	parent string
+	name   string
+	kind   int
+	start  time.Time
+	end    time.Time
+	attrs  map[string]interface{}
+	failed bool
+}
+
+// runTracer collects the spans of a run: a root span for the run, a span for
+// each namespace processed and one for every API call. They're kept in memory
+// and exported when the run ends. It's safe for concurrent use.
+type runTracer struct {
+	mu      sync.Mutex
+	traceID string
+	root    *span
+	// open are the namespace spans in progress, innermost last. API calls
+	// are children of the innermost one, or of the root.
+	open  []*span
+	spans []*span
+}
+
+func newTracer(start time.Time) *runTracer {
+	t := &runTracer{traceID: randomHex(16)}
+	t.root = &span{id: randomHex(8), name: "jobliterator.run", kind: spanKindInternal, start: start,
+		attrs: map[string]interface{}{"jobliterator.run_id": runID}}
+	return t
+}
+
+// randomHex returns n random bytes, hex encoded.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// startNamespace opens a span for work in the namespace, which must be ended
+// with endNamespace. It's meant for the sequential parts of the run.
+func (t *runTracer) startNamespace(name, namespace string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := &span{id: randomHex(8), parent: t.parent().id, name: name, kind: spanKindInternal, start: time.Now(),
+		attrs: map[string]interface{}{"k8s.namespace.name": namespace}}
+	t.open = append(t.open, s)
+}
+
+// endNamespace ends the innermost span opened with startNamespace.
+func (t *runTracer) endNamespace() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.open) == 0 {
+		return
+	}
+	s := t.open[len(t.open)-1]
+	t.open = t.open[:len(t.open)-1]
+	s.end = time.Now()
+	t.spans = append(t.spans, s)
+}
+
+// parent returns the span new spans are children of. t.mu must be held.
+func (t *runTracer) parent() *span {
+	if len(t.open) > 0 {
+		return t.open[len(t.open)-1]
+	}
+	return t.root
+}
+
+// apiCall records a finished request to the Kubernetes API.
+func (t *runTracer) apiCall(req *http.Request, start time.Time, status int, err error) {
+	namespace, resource, name := parseAPIPath(req.URL.Path)
+	verb := strings.ToLower(req.Method)
+	if req.Method == "GET" && name == "" {
+		verb = "list"
+	}
+	attrs := map[string]interface{}{
+		"http.request.method": req.Method,
+		"url.path":            req.URL.Path,
+		"duration_ms":         int64(time.Since(start) / time.Millisecond),
+	}
+	if namespace != "" {
+		attrs["k8s.namespace.name"] = namespace
+	}
+	if resource == "jobs" && name != "" {
+		attrs["k8s.job.name"] = name
+	}
+	if status != 0 {
+		attrs["http.response.status_code"] = status
+	}
+	if err != nil {
+		attrs["error.message"] = err.Error()
+	}
+	spanName := verb + " " + resource
+	if resource == "" {
+		spanName = req.Method + " " + req.URL.Path
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.spans = append(t.spans, &span{id: randomHex(8), parent: t.parent().id, name: spanName,
+		kind: spanKindClient, start: start, end: time.Now(), attrs: attrs, failed: err != nil || status >= 400})
+}
+
+// parseAPIPath returns the namespace, resource and object name of an API path
+// such as /apis/batch/v1/namespaces/NAMESPACE/jobs/NAME. Parts that aren't
+// part of the path are empty.
+func parseAPIPath(path string) (namespace, resource, name string) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	// Skip the "api/v1" or "apis/group/version" prefix.
+	switch {
+	case len(parts) >= 2 && parts[0] == "api":
+		parts = parts[2:]
+	case len(parts) >= 3 && parts[0] == "apis":
+		parts = parts[3:]
+	default:
+		return "", "", ""
+	}
+	if len(parts) >= 2 && parts[0] == "namespaces" {
+		namespace = parts[1]
+		parts = parts[2:]
+		if len(parts) == 0 {
+			return namespace, "namespaces", namespace
+		}
+	}
+	if len(parts) > 0 {
+		resource = parts[0]
+	}
+	if len(parts) > 1 {
+		name = parts[1]
+	}
+	return namespace, resource, name
+}
+
+// tracingTransport records every request to the Kubernetes API as a span.
+type tracingTransport struct {
+	base http.RoundTripper
+}
+
+func (tt *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := tt.base.RoundTrip(req)
+	status := 0
+	if resp != nil {
+		status = resp.StatusCode
+	}
+	tracer.apiCall(req, start, status, err)
+	return resp, err
+}
+
+// traceClient records the client's requests with tracer.
+func traceClient(client *k8s.Client) {
+	base := client.Client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	client.Client.Transport = &tracingTransport{base: base}
+}
+
+// export ends the root span and sends all spans to the OTLP/HTTP endpoint,
+// e.g. http://otel-collector:4318, in batches of otlpBatchSize.
+func (t *runTracer) export(endpoint string, timeout time.Duration, sum *runSummary, dryRun bool) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.root.end = time.Now()
+	t.root.attrs["jobliterator.dry_run"] = dryRun
+	t.root.attrs["jobliterator.jobs_deleted"] = sum.JobsDeleted
+	t.root.attrs["jobliterator.errors"] = sum.Errors
+	t.root.failed = sum.Errors > 0
+	spans := append([]*span{t.root}, t.spans...)
+	client := &http.Client{Timeout: timeout}
+	url := strings.TrimSuffix(endpoint, "/") + "/v1/traces"
+	for len(spans) > 0 {
+		n := len(spans)
+		if n > otlpBatchSize {
+			n = otlpBatchSize
+		}
+		if err := postSpans(client, url, t.traceID, spans[:n]); err != nil {
+			return err
+		}
+		spans = spans[n:]
+	}
+	return nil
+}
+
+// postSpans sends the spans as an OTLP/HTTP JSON export request.
+func postSpans(client *http.Client, url, traceID string, spans []*span) error {
+	type attr map[string]interface{}
+	attrList := func(attrs map[string]interface{}) []attr {
+		var list []attr
+		for k, v := range attrs {
+			var value attr
+			switch v := v.(type) {
+			case bool:
+				value = attr{"boolValue": v}
+			case int:
+				value = attr{"intValue": strconv.Itoa(v)}
+			case int64:
+				value = attr{"intValue": strconv.FormatInt(v, 10)}
+			default:
+				value = attr{"stringValue": fmt.Sprint(v)}
+			}
+			list = append(list, attr{"key": k, "value": value})
+		}
+		return list
+	}
+	var otlpSpans []attr
+	for _, s := range spans {
+		out := attr{
+			"traceId":           traceID,
+			"spanId":            s.id,
+			"name":              s.name,
+			"kind":              s.kind,
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        attrList(s.attrs),
+		}
+		if s.parent != "" {
+			out["parentSpanId"] = s.parent
+		}
+		if s.failed {
+			out["status"] = attr{"code": statusError}
+		}
+		otlpSpans = append(otlpSpans, out)
+	}
+	body, err := json.Marshal(attr{"resourceSpans": []attr{{
+		"resource":   attr{"attributes": attrList(map[string]interface{}{"service.name": "jobliterator", "service.version": version})},
+		"scopeSpans": []attr{{"scope": attr{"name": "jobliterator"}, "spans": otlpSpans}},
+	}}})
+	if err != nil {
+		return fmt.Errorf("Failed to encode spans: %v", err)
+	}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("Failed to export spans: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("Exporting spans failed: %s", resp.Status)
+	}
+	return nil
+}
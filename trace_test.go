@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	batchv1 "github.com/ericchiang/k8s/apis/batch/v1"
+	metav1 "github.com/ericchiang/k8s/apis/meta/v1"
+)
+
+// otlpSpan is a span as decoded from an OTLP/HTTP JSON export request.
+type otlpSpan struct {
+	TraceID      string `json:"traceId"`
+	SpanID       string `json:"spanId"`
+	ParentSpanID string `json:"parentSpanId"`
+	Name         string `json:"name"`
+	Kind         int    `json:"kind"`
+	Attributes   []struct {
+		Key   string                 `json:"key"`
+		Value map[string]interface{} `json:"value"`
+	} `json:"attributes"`
+	Status *struct {
+		Code int `json:"code"`
+	} `json:"status"`
+}
+
+func (s otlpSpan) attr(key string) interface{} {
+	for _, a := range s.Attributes {
+		if a.Key == key {
+			for _, v := range a.Value {
+				return v
+			}
+		}
+	}
+	return nil
+}
+
+func TestTraceAPICalls(t *testing.T) {
+	defer func(t *runTracer) { tracer = t }(tracer)
+	tracer = newTracer(testNow)
+	api, client := newFakeAPI(t)
+	traceClient(client)
+	j := completedJob("open", "backup", 5)
+	api.serveJobs(j)
+	api.handle("GET", "/apis/batch/v1/namespaces/open/jobs", func(w http.ResponseWriter, r *http.Request) {
+		writeObject(w, r, &batchv1.JobList{Metadata: &metav1.ListMeta{}, Items: []*batchv1.Job{j}})
+	})
+	api.handle("GET", "/apis/batch/v1/namespaces/locked/jobs", func(w http.ResponseWriter, r *http.Request) {
+		writeStatus(w, r, http.StatusForbidden, "forbidden")
+	})
+	captureStdout(t)
+	if _, _, _, err := listJobs(client, []string{"locked", "open"}, func(string) string { return "" }, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := deleteJob(client, newKubeJob(j, testNow), "Background"); err != nil {
+		t.Fatal(err)
+	}
+
+	var spans []otlpSpan
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" {
+			t.Errorf("Spans exported to %s, want /v1/traces", r.URL.Path)
+		}
+		var req struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []otlpSpan `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Invalid export request: %v", err)
+		}
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+	}))
+	defer collector.Close()
+	if err := tracer.export(collector.URL+"/", time.Second, &runSummary{JobsDeleted: 1}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	byName := make(map[string][]otlpSpan)
+	for _, s := range spans {
+		if s.TraceID != tracer.traceID {
+			t.Errorf("Span %s has trace ID %s, want %s", s.Name, s.TraceID, tracer.traceID)
+		}
+		byName[s.Name] = append(byName[s.Name], s)
+	}
+	if len(spans) != 6 {
+		t.Errorf("Got %d spans, want 6: the run, 2 namespaces, 2 lists and the delete", len(spans))
+	}
+	root := byName["jobliterator.run"]
+	if len(root) != 1 || root[0].ParentSpanID != "" || root[0].attr("jobliterator.jobs_deleted") != "1" {
+		t.Fatalf("Root spans %+v, want one with jobs_deleted 1", root)
+	}
+
+	// Each list is a child of its namespace's span, the forbidden one failed.
+	namespaces := make(map[string]string)
+	for _, s := range byName["list jobs"] {
+		if s.Kind == spanKindInternal {
+			if s.ParentSpanID != root[0].SpanID {
+				t.Errorf("Namespace span %s isn't a child of the run", s.attr("k8s.namespace.name"))
+			}
+			namespaces[s.SpanID] = s.attr("k8s.namespace.name").(string)
+		}
+	}
+	lists := 0
+	for _, s := range byName["list jobs"] {
+		if s.Kind != spanKindClient {
+			continue
+		}
+		lists++
+		ns := s.attr("k8s.namespace.name")
+		if namespaces[s.ParentSpanID] != ns {
+			t.Errorf("List in %v is a child of namespace %q", ns, namespaces[s.ParentSpanID])
+		}
+		failed := s.Status != nil && s.Status.Code == statusError
+		if failed != (ns == "locked") || s.attr("http.response.status_code") == nil {
+			t.Errorf("List in %v has status %+v and code %v", ns, s.Status, s.attr("http.response.status_code"))
+		}
+	}
+	if lists != 2 || len(namespaces) != 2 {
+		t.Errorf("Got %d list spans in %d namespaces, want 2 in 2", lists, len(namespaces))
+	}
+
+	del := byName["delete jobs"]
+	if len(del) != 1 {
+		t.Fatalf("Delete spans %+v, want one", del)
+	}
+	if del[0].ParentSpanID != root[0].SpanID || del[0].attr("k8s.job.name") != "backup" ||
+		del[0].attr("k8s.namespace.name") != "open" || del[0].attr("http.request.method") != "DELETE" || del[0].Status != nil {
+		t.Errorf("Delete span %+v, want a successful DELETE of open/backup under the run", del[0])
+	}
+}
+
+func TestParseAPIPath(t *testing.T) {
+	for _, tt := range []struct {
+		path, namespace, resource, name string
+	}{
+		{"/apis/batch/v1/namespaces/ns/jobs/backup", "ns", "jobs", "backup"},
+		{"/apis/batch/v1/namespaces/ns/jobs", "ns", "jobs", ""},
+		{"/api/v1/namespaces/ns/pods/backup-1/log", "ns", "pods", "backup-1"},
+		{"/api/v1/namespaces/ns", "ns", "namespaces", "ns"},
+		{"/apis/batch/v1/jobs", "", "jobs", ""},
+		{"/version", "", "", ""},
+	} {
+		namespace, resource, name := parseAPIPath(tt.path)
+		if namespace != tt.namespace || resource != tt.resource || name != tt.name {
+			t.Errorf("parseAPIPath(%q) = %q, %q, %q, want %q, %q, %q", tt.path, namespace, resource, name, tt.namespace, tt.resource, tt.name)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+
+	"github.com/ericchiang/k8s"
+)
+
+// Build information, set with e.g.
+//
+//	go build -ldflags "-X main.version=v1.2.0 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%FT%TZ)"
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+// printVersion prints the build information and, if client isn't nil, the
+// version of the Kubernetes API server.
+func printVersion(w io.Writer, client *k8s.Client) {
+	fmt.Fprintf(w, "jobliterator %s (commit %s, built %s, %s)\n", version, commit, buildDate, runtime.Version())
+	if client == nil {
+		return
+	}
+	v, err := client.Discovery().Version(context.Background())
+	if err != nil {
+		fmt.Fprintf(w, "Kubernetes server: unavailable (%v)\n", err)
+		return
+	}
+	fmt.Fprintf(w, "Kubernetes server: %s (%s)\n", v.GitVersion, v.Platform)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// webhookAttempts is how many times the summary is POSTed before giving up.
+const webhookAttempts = 3
+
+// webhookRetryDelay is the delay before the first retry, growing by as much
+// for every further one.
+var webhookRetryDelay = time.Second
+
+// parseWebhookHeaders parses the "-webhook-header" flag value, a
+// comma-separated list of NAME:VALUE headers.
+func parseWebhookHeaders(val string) (http.Header, error) {
+	headers := make(http.Header)
+	if val == "" {
+		return headers, nil
+	}
+	for _, entry := range strings.Split(val, ",") {
+		sep := strings.Index(entry, ":")
+		if sep <= 0 {
+			return nil, fmt.Errorf("Invalid -webhook-header %q, expected NAME:VALUE", entry)
+		}
+		headers.Add(strings.TrimSpace(entry[:sep]), strings.TrimSpace(entry[sep+1:]))
+	}
+	return headers, nil
+}
+
+// postWebhook POSTs the entry, the same as written to the audit log, as JSON
+// to url. Connection errors and 429 or 5xx responses are retried with a
+// growing delay, other responses fail at once.
+func postWebhook(url string, headers http.Header, timeout time.Duration, entry auditEntry) error {
+	body, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("Failed to encode webhook payload: %v", err)
+	}
+	client := &http.Client{Timeout: timeout}
+	for attempt := 1; ; attempt++ {
+		retry, err := sendWebhook(client, url, headers, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt == webhookAttempts {
+			return err
+		}
+		debugf("Webhook attempt %d failed, retrying: %v\n", attempt, err)
+		time.Sleep(time.Duration(attempt) * webhookRetryDelay)
+	}
+}
+
+// sendWebhook makes a single POST, reporting whether a failure is worth
+// retrying.
+func sendWebhook(client *http.Client, url string, headers http.Header, body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("Invalid -webhook URL: %v", err)
+	}
+	for name, values := range headers {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("Failed to POST webhook: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode/100 == 5
+	return retry, fmt.Errorf("Webhook responded %s", resp.Status)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// webhookServer returns a server answering the webhook POSTs with the given
+// status codes in turn, and the number of POSTs received.
+func webhookServer(t *testing.T, codes ...int) (*httptest.Server, *int32) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		if r.Method != "POST" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Got %s with content type %q, want a JSON POST", r.Method, r.Header.Get("Content-Type"))
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer token" {
+			t.Errorf("Authorization header %q, want %q", got, "Bearer token")
+		}
+		var entry auditEntry
+		if err := json.NewDecoder(r.Body).Decode(&entry); err != nil || entry.RunID != "run-1" || entry.JobsDeleted != 3 {
+			t.Errorf("Payload %+v, %v, want the audit entry", entry, err)
+		}
+		w.WriteHeader(codes[int(n-1)%len(codes)])
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func TestPostWebhook(t *testing.T) {
+	defer func(d time.Duration) { webhookRetryDelay = d }(webhookRetryDelay)
+	webhookRetryDelay = time.Millisecond
+	headers, err := parseWebhookHeaders("Authorization: Bearer token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := auditEntry{RunID: "run-1", runSummary: runSummary{JobsDeleted: 3}}
+	tests := []struct {
+		name      string
+		codes     []int
+		wantErr   string
+		wantCalls int32
+	}{
+		{"success", []int{http.StatusNoContent}, "", 1},
+		{"retry then success", []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK}, "", 3},
+		{"retries used up", []int{http.StatusBadGateway}, "Webhook responded 502 Bad Gateway", webhookAttempts},
+		{"no retry on 4xx", []int{http.StatusBadRequest, http.StatusOK}, "Webhook responded 400 Bad Request", 1},
+	}
+	for _, tt := range tests {
+		srv, calls := webhookServer(t, tt.codes...)
+		err := postWebhook(srv.URL, headers, time.Second, entry)
+		if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.wantErr)
+		}
+		if *calls != tt.wantCalls {
+			t.Errorf("%s: %d POSTs, want %d", tt.name, *calls, tt.wantCalls)
+		}
+	}
+}
+
+func TestPostWebhookConnectionError(t *testing.T) {
+	defer func(d time.Duration) { webhookRetryDelay = d }(webhookRetryDelay)
+	webhookRetryDelay = time.Millisecond
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	err := postWebhook(srv.URL, nil, time.Second, auditEntry{})
+	if err == nil || !strings.HasPrefix(err.Error(), "Failed to POST webhook") {
+		t.Errorf("Error %v, want a failed POST", err)
+	}
+}
+
+func TestParseWebhookHeaders(t *testing.T) {
+	headers, err := parseWebhookHeaders("Authorization: Bearer a:b, X-Team:batch,X-Team:infra")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := headers.Get("Authorization"); got != "Bearer a:b" {
+		t.Errorf("Authorization = %q, want %q", got, "Bearer a:b")
+	}
+	if got := headers["X-Team"]; !equalStrings(got, []string{"batch", "infra"}) {
+		t.Errorf("X-Team = %v, want [batch infra]", got)
+	}
+	if headers, err := parseWebhookHeaders(""); err != nil || len(headers) != 0 {
+		t.Errorf("Empty value = %v, %v, want no headers", headers, err)
+	}
+	for _, val := range []string{"Authorization", ":value", "X-Team:batch,", "X-Team:batch,,X-Other:1"} {
+		if _, err := parseWebhookHeaders(val); err == nil || !strings.HasPrefix(err.Error(), "Invalid -webhook-header") {
+			t.Errorf("parseWebhookHeaders(%q) error %v, want an invalid -webhook-header error", val, err)
+		}
+	}
+}
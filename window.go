@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// weekdays maps the day names of "-no-delete-window" to weekdays.
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// deleteWindow is a recurring period during which nothing is deleted, e.g.
+// business hours during a change freeze.
+type deleteWindow struct {
+	// days are the days the window starts on, nil for every day.
+	days map[time.Weekday]bool
+	// start and end are minutes since midnight. A window ending before it
+	// starts runs past midnight into the next day.
+	start, end int
+}
+
+// parseDeleteWindow parses the "-no-delete-window" flag value: days, a time
+// range or both, e.g. "Mon-Fri 09:00-17:00", "Sat,Sun" or "22:00-06:00".
+func parseDeleteWindow(val string) (*deleteWindow, error) {
+	fields := strings.Fields(val)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, fmt.Errorf("Invalid -no-delete-window %q, expected DAYS, HH:MM-HH:MM or both", val)
+	}
+	w := &deleteWindow{start: 0, end: 24 * 60}
+	if !strings.Contains(fields[0], ":") {
+		days, err := parseWeekdays(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		w.days = days
+		fields = fields[1:]
+	}
+	if len(fields) == 0 {
+		return w, nil
+	}
+	times := strings.Split(fields[0], "-")
+	if len(times) != 2 {
+		return nil, fmt.Errorf("Invalid -no-delete-window time range %q, expected HH:MM-HH:MM", fields[0])
+	}
+	var err error
+	if w.start, err = parseClock(times[0]); err != nil {
+		return nil, err
+	}
+	if w.end, err = parseClock(times[1]); err != nil {
+		return nil, err
+	}
+	if w.start == w.end || w.start == 24*60 {
+		return nil, fmt.Errorf("Invalid -no-delete-window time range %q", fields[0])
+	}
+	return w, nil
+}
+
+// parseWeekdays parses a comma-separated list of day names and ranges of them,
+// e.g. "Mon-Fri" or "Sat,Sun". Ranges may wrap around the week, e.g. "Fri-Mon".
+func parseWeekdays(val string) (map[time.Weekday]bool, error) {
+	days := make(map[time.Weekday]bool)
+	for _, item := range strings.Split(val, ",") {
+		bounds := strings.Split(item, "-")
+		if len(bounds) > 2 {
+			return nil, fmt.Errorf("Invalid -no-delete-window days %q", item)
+		}
+		var ends []time.Weekday
+		for _, b := range bounds {
+			d, ok := weekdays[strings.ToLower(b)]
+			if !ok {
+				return nil, fmt.Errorf("Invalid -no-delete-window day %q, expected Mon, Tue, Wed, Thu, Fri, Sat or Sun", b)
+			}
+			ends = append(ends, d)
+		}
+		for d := ends[0]; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == ends[len(ends)-1] {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+// parseClock parses a HH:MM time of day into minutes since midnight. 24:00 is
+// allowed as the end of a day.
+func parseClock(val string) (int, error) {
+	var h, m int
+	if n, err := fmt.Sscanf(val, "%d:%d", &h, &m); err != nil || n != 2 || len(val) != 5 ||
+		h < 0 || m < 0 || m > 59 || h > 24 || h == 24 && m != 0 {
+		return 0, fmt.Errorf("Invalid -no-delete-window time %q, expected HH:MM", val)
+	}
+	return h*60 + m, nil
+}
+
+// contains reports whether t, in the window's time zone, is within the
+// window.
+func (w *deleteWindow) contains(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+	if w.start < w.end {
+		return w.onDay(day) && m >= w.start && m < w.end
+	}
+	// Past midnight the window belongs to the previous day.
+	return w.onDay(day) && m >= w.start || w.onDay((day+6)%7) && m < w.end
+}
+
+func (w *deleteWindow) onDay(d time.Weekday) bool {
+	return w.days == nil || w.days[d]
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeleteWindowContains(t *testing.T) {
+	// 2024-03-15 is a Friday.
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2024, 3, day, hour, min, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		window string
+		t      time.Time
+		want   bool
+	}{
+		{"Mon-Fri 09:00-17:00", at(15, 9, 0), true},
+		{"Mon-Fri 09:00-17:00", at(15, 16, 59), true},
+		{"Mon-Fri 09:00-17:00", at(15, 17, 0), false},
+		{"Mon-Fri 09:00-17:00", at(15, 8, 59), false},
+		{"Mon-Fri 09:00-17:00", at(16, 12, 0), false},
+		{"Sat,Sun", at(16, 0, 0), true},
+		{"Sat,Sun", at(17, 23, 59), true},
+		{"Sat,Sun", at(18, 0, 0), false},
+		{"Fri-Mon", at(18, 12, 0), true},
+		{"Fri-Mon", at(19, 12, 0), false},
+		{"12:00-24:00", at(15, 23, 59), true},
+		{"12:00-24:00", at(16, 0, 0), false},
+		// Past midnight the window belongs to the day it started on.
+		{"22:00-06:00", at(15, 22, 0), true},
+		{"22:00-06:00", at(16, 5, 59), true},
+		{"22:00-06:00", at(16, 6, 0), false},
+		{"22:00-06:00", at(15, 21, 59), false},
+		{"Fri 22:00-06:00", at(16, 3, 0), true},
+		{"Fri 22:00-06:00", at(16, 22, 30), false},
+		{"Fri 22:00-06:00", at(15, 3, 0), false},
+	}
+	for _, tt := range tests {
+		w, err := parseDeleteWindow(tt.window)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.window, err)
+		}
+		if got := w.contains(tt.t); got != tt.want {
+			t.Errorf("%q at %s: contains = %v, want %v", tt.window, tt.t.Format("Mon 15:04"), got, tt.want)
+		}
+	}
+}
+
+func TestDeleteWindowDST(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Stockholm")
+	if err != nil {
+		t.Skipf("No time zone data: %v", err)
+	}
+	w, err := parseDeleteWindow("01:30-03:30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Clocks went from 02:00 to 03:00 on 2024-03-31, and from 03:00 back
+	// to 02:00 on 2024-10-27. The window follows the wall clock.
+	tests := []struct {
+		t    time.Time
+		want bool
+	}{
+		{time.Date(2024, 3, 31, 0, 30, 0, 0, time.UTC), true},  // 01:30 CET
+		{time.Date(2024, 3, 31, 1, 15, 0, 0, time.UTC), true},  // 03:15 CEST
+		{time.Date(2024, 3, 31, 1, 30, 0, 0, time.UTC), false}, // 03:30 CEST
+		{time.Date(2024, 10, 27, 0, 30, 0, 0, time.UTC), true}, // 02:30 CEST
+		{time.Date(2024, 10, 27, 1, 30, 0, 0, time.UTC), true}, // 02:30 CET
+		{time.Date(2024, 10, 27, 2, 30, 0, 0, time.UTC), false},
+	}
+	for _, tt := range tests {
+		local := tt.t.In(loc)
+		if got := w.contains(local); got != tt.want {
+			t.Errorf("%s: contains = %v, want %v", local.Format("2006-01-02 15:04 MST"), got, tt.want)
+		}
+	}
+}
+
+func TestParseDeleteWindowInvalid(t *testing.T) {
+	for _, val := range []string{"", "Mon Tue 09:00-17:00", "Funday", "09:00", "09:00-09:00", "24:00-06:00", "9:00-17:00", "09:60-17:00", "Mon-Tue-Wed"} {
+		if _, err := parseDeleteWindow(val); err == nil {
+			t.Errorf("%q accepted", val)
+		}
+	}
+}